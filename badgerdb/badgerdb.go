@@ -1,7 +1,17 @@
 package badgerdb
 
 import (
+	"bytes"
+	"context"
+	"databases/kv"
+	"databases/stores"
+	"strconv"
+	"sync/atomic"
+	"time"
+
 	"github.com/dgraph-io/badger"
+	"github.com/dgraph-io/badger/pb"
+	"github.com/philippgille/gokv"
 	"github.com/philippgille/gokv/encoding"
 	"github.com/philippgille/gokv/util"
 )
@@ -14,6 +24,22 @@ type Store struct {
 
 // Set stores the given value for the given key.
 func (s Store) Set(k string, v interface{}) error {
+	return s.SetContext(context.Background(), k, v)
+}
+
+// SetContext stores the given value for the given key
+// unless the context is done before the value is written.
+func (s Store) SetContext(ctx context.Context, k string, v interface{}) error {
+	return s.set(ctx, k, v, 0)
+}
+
+// SetWithTTL stores the given value for the given key.
+// The value expires after ttl, which BadgerDB tracks in whole seconds.
+func (s Store) SetWithTTL(k string, v interface{}, ttl time.Duration) error {
+	return s.set(context.Background(), k, v, ttl)
+}
+
+func (s Store) set(ctx context.Context, k string, v interface{}, ttl time.Duration) error {
 	if err := util.CheckKeyAndValue(k, v); err != nil {
 		return err
 	}
@@ -23,8 +49,17 @@ func (s Store) Set(k string, v interface{}) error {
 		return err
 	}
 
+	// The transaction is discarded instead of committed
+	// if the context is done in the meantime.
+	e := newEntry(k, data)
+	if ttl > 0 {
+		e = e.WithTTL(ttl)
+	}
 	err = s.Db.Update(func(txn *badger.Txn) error {
-		return txn.Set([]byte(k), data)
+		if err := txn.SetEntry(e); err != nil {
+			return err
+		}
+		return ctx.Err()
 	})
 	if err != nil {
 		return err
@@ -34,12 +69,21 @@ func (s Store) Set(k string, v interface{}) error {
 
 // Get retrieves the stored value for the given key.
 func (s Store) Get(k string, v interface{}) (found bool, err error) {
+	return s.GetContext(context.Background(), k, v)
+}
+
+// GetContext retrieves the stored value for the given key
+// unless the context is done before the value is read.
+func (s Store) GetContext(ctx context.Context, k string, v interface{}) (found bool, err error) {
 	if err := util.CheckKeyAndValue(k, v); err != nil {
 		return false, err
 	}
 
 	var data []byte
 	err = s.Db.View(func(txn *badger.Txn) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		item, err := txn.Get([]byte(k))
 		if err != nil {
 			return err
@@ -62,15 +106,370 @@ func (s Store) Get(k string, v interface{}) (found bool, err error) {
 
 // Delete deletes the stored value for the given key.
 func (s Store) Delete(k string) error {
+	return s.DeleteContext(context.Background(), k)
+}
+
+// DeleteContext deletes the stored value for the given key
+// unless the context is done before the value is deleted.
+func (s Store) DeleteContext(ctx context.Context, k string) error {
 	if err := util.CheckKey(k); err != nil {
 		return err
 	}
 
 	return s.Db.Update(func(txn *badger.Txn) error {
-		return txn.Delete([]byte(k))
+		if err := txn.Delete([]byte(k)); err != nil {
+			return err
+		}
+		return ctx.Err()
+	})
+}
+
+// SetMany stores the given values for their keys.
+// The values are written with a single WriteBatch, which BadgerDB
+// splits into as few transactions as possible.
+func (s Store) SetMany(values map[string]interface{}) error {
+	wb := s.Db.NewWriteBatch()
+	defer wb.Cancel()
+	for k, v := range values {
+		if err := util.CheckKeyAndValue(k, v); err != nil {
+			return err
+		}
+		data, err := s.Codec.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if err := wb.SetEntry(newEntry(k, data)); err != nil {
+			return err
+		}
+	}
+	return wb.Flush()
+}
+
+// GetMany retrieves the stored values for the given keys
+// in a single read-only transaction.
+func (s Store) GetMany(keys []string, vs []interface{}) (found []bool, err error) {
+	if len(keys) != len(vs) {
+		return nil, kv.ErrLengthMismatch
+	}
+	for i, k := range keys {
+		if err := util.CheckKeyAndValue(k, vs[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	found = make([]bool, len(keys))
+	err = s.Db.View(func(txn *badger.Txn) error {
+		for i, k := range keys {
+			item, err := txn.Get([]byte(k))
+			if err == badger.ErrKeyNotFound {
+				continue
+			} else if err != nil {
+				return err
+			}
+			err = item.Value(func(data []byte) error {
+				return s.Codec.Unmarshal(data, vs[i])
+			})
+			if err != nil {
+				return err
+			}
+			found[i] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// DeleteMany deletes the stored values for the given keys
+// with a single WriteBatch.
+func (s Store) DeleteMany(keys []string) error {
+	wb := s.Db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, k := range keys {
+		if err := util.CheckKey(k); err != nil {
+			return err
+		}
+		if err := wb.Delete([]byte(k)); err != nil {
+			return err
+		}
+	}
+	return wb.Flush()
+}
+
+// Update runs fn in a read-write BadgerDB transaction.
+// It returns kv.ErrConflict if a key that fn read was written
+// by another transaction before the commit.
+func (s Store) Update(fn func(tx kv.Txn) error) error {
+	err := s.Db.Update(func(txn *badger.Txn) error {
+		return fn(tx{txn: txn, codec: s.Codec})
+	})
+	if err == badger.ErrConflict {
+		return kv.ErrConflict
+	}
+	return err
+}
+
+// View runs fn in a read-only BadgerDB transaction.
+func (s Store) View(fn func(tx kv.Txn) error) error {
+	return s.Db.View(func(txn *badger.Txn) error {
+		return fn(tx{txn: txn, codec: s.Codec, readOnly: true})
+	})
+}
+
+// tx is a kv.TTLTxn for a BadgerDB transaction.
+type tx struct {
+	txn      *badger.Txn
+	codec    encoding.Codec
+	readOnly bool
+}
+
+func (t tx) Get(k string, v interface{}) (found bool, err error) {
+	if err := util.CheckKeyAndValue(k, v); err != nil {
+		return false, err
+	}
+	item, err := t.txn.Get([]byte(k))
+	if err == badger.ErrKeyNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, item.Value(func(data []byte) error {
+		return t.codec.Unmarshal(data, v)
+	})
+}
+
+func (t tx) Set(k string, v interface{}) error {
+	return t.SetWithTTL(k, v, 0)
+}
+
+// SetWithTTL stores the given value for the given key.
+// The value expires after ttl, which BadgerDB tracks in whole seconds.
+func (t tx) SetWithTTL(k string, v interface{}, ttl time.Duration) error {
+	if err := util.CheckKeyAndValue(k, v); err != nil {
+		return err
+	}
+	if t.readOnly {
+		return kv.ErrReadOnly
+	}
+	data, err := t.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	e := newEntry(k, data)
+	if ttl > 0 {
+		e = e.WithTTL(ttl)
+	}
+	return t.txn.SetEntry(e)
+}
+
+func (t tx) Delete(k string) error {
+	if err := util.CheckKey(k); err != nil {
+		return err
+	}
+	if t.readOnly {
+		return kv.ErrReadOnly
+	}
+	return t.txn.Delete([]byte(k))
+}
+
+// CompareAndSwap stores new for k if the stored value equals old
+// and reports whether it did.
+func (s Store) CompareAndSwap(k string, old, new interface{}) (swapped bool, err error) {
+	return kv.CompareAndSwap(s.modify, s.Codec, k, old, new)
+}
+
+// SetIfAbsent stores v for k if no value is stored for it
+// and reports whether it did.
+func (s Store) SetIfAbsent(k string, v interface{}) (set bool, err error) {
+	return kv.SetIfAbsent(s.modify, s.Codec, k, v)
+}
+
+// Increment adds delta to the int64 stored for k, or to 0 if none is,
+// and returns the result.
+func (s Store) Increment(k string, delta int64) (int64, error) {
+	return kv.Increment(s.modify, s.Codec, k, delta)
+}
+
+// modify implements kv.ModifyFunc with a transaction,
+// which is retried if it conflicts with another one.
+func (s Store) modify(k string, fn func(data []byte, found bool) ([]byte, bool, error)) error {
+	for {
+		err := s.Db.Update(func(txn *badger.Txn) error {
+			var data []byte
+			item, err := txn.Get([]byte(k))
+			found := err == nil
+			if found {
+				data, err = item.ValueCopy(nil)
+			}
+			if err != nil && err != badger.ErrKeyNotFound {
+				return err
+			}
+			newData, write, err := fn(data, found)
+			if err != nil || !write {
+				return err
+			}
+			return txn.SetEntry(newEntry(k, newData))
+		})
+		if err != badger.ErrConflict {
+			return err
+		}
+	}
+}
+
+// Scan returns an iterator over the keys that start with prefix
+// and lie within [start, end), in ascending order.
+// The iterator reads from a read-only transaction that is
+// discarded when the iterator is closed.
+func (s Store) Scan(prefix, start, end string) (kv.Iterator, error) {
+	lo, hi := kv.Bounds(prefix, start, end)
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = []byte(prefix)
+	txn := s.Db.NewTransaction(false)
+	it := &iterator{
+		txn:   txn,
+		it:    txn.NewIterator(opts),
+		lo:    []byte(lo),
+		codec: s.Codec,
+	}
+	if hi != "" {
+		it.hi = []byte(hi)
+	}
+	return it, nil
+}
+
+type iterator struct {
+	txn     *badger.Txn
+	it      *badger.Iterator
+	lo, hi  []byte
+	codec   encoding.Codec
+	started bool
+}
+
+func (it *iterator) Next() bool {
+	if !it.started {
+		it.it.Seek(it.lo)
+		it.started = true
+	} else if it.it.Valid() {
+		it.it.Next()
+	}
+	if !it.it.Valid() {
+		return false
+	}
+	return it.hi == nil || bytes.Compare(it.it.Item().Key(), it.hi) < 0
+}
+
+func (it *iterator) Key() string {
+	return string(it.it.Item().Key())
+}
+
+func (it *iterator) Value(v interface{}) error {
+	return it.it.Item().Value(func(data []byte) error {
+		return it.codec.Unmarshal(data, v)
 	})
 }
 
+func (it *iterator) Err() error {
+	return nil
+}
+
+func (it *iterator) Close() error {
+	it.it.Close()
+	it.txn.Discard()
+	return nil
+}
+
+// Watch returns a channel that receives an event for every change of
+// a key that starts with prefix, and a function that stops watching
+// and closes the channel. It subscribes to BadgerDB's own change feed,
+// so it also sees the writes of transactions and batches.
+func (s Store) Watch(prefix string) (<-chan kv.Event, func()) {
+	broker := kv.NewBroker()
+	events, stop := broker.Watch(prefix)
+
+	// Subscribe registers the subscription asynchronously. Writing
+	// a marker key until it arrives makes sure that Watch only returns
+	// once the subscription sees every later change. The marker expired
+	// before it was written, so it's never visible.
+	marker := []byte(markerPrefix + strconv.FormatInt(atomic.AddInt64(&watches, 1), 10))
+	subscribed := make(chan struct{})
+	var seen bool
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// The subscription also ends when the store is closed.
+		defer stop()
+		s.Db.Subscribe(ctx, func(list *pb.KVList) {
+			for _, item := range list.Kv {
+				switch {
+				case bytes.Equal(item.Key, marker):
+					if !seen {
+						seen = true
+						close(subscribed)
+					}
+				case !bytes.HasPrefix(item.Key, []byte(prefix)) ||
+					bytes.HasPrefix(item.Key, []byte("!badger!")) ||
+					bytes.HasPrefix(item.Key, []byte(markerPrefix)):
+					// BadgerDB also sends the changes of other subscriptions' prefixes,
+					// including the markers of other watchers.
+				case len(item.Value) == 0 && (len(item.UserMeta) == 0 || item.UserMeta[0]&metaValue == 0):
+					// The feed has no delete bit, but deletes are the only
+					// entries without a value that aren't marked as values.
+					broker.PublishDelete(string(item.Key))
+				default:
+					var deadline time.Time
+					if item.ExpiresAt > 0 {
+						deadline = time.Unix(int64(item.ExpiresAt), 0)
+					}
+					broker.PublishSet(string(item.Key), item.Value, deadline)
+				}
+			}
+		}, []byte(prefix), marker)
+	}()
+
+	for waiting := true; waiting; {
+		err := s.Db.Update(func(txn *badger.Txn) error {
+			return txn.SetEntry(&badger.Entry{Key: marker, Value: []byte{1}, ExpiresAt: 1})
+		})
+		if err != nil {
+			break
+		}
+		select {
+		case <-subscribed:
+			waiting = false
+		case <-done:
+			waiting = false
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	return events, func() {
+		// Stopping first unblocks a callback that waits for the watcher.
+		stop()
+		cancel()
+		<-done
+		broker.Close()
+	}
+}
+
+// watches counts the calls of Watch, to give each its own marker key.
+var watches int64
+
+// markerPrefix starts the marker keys of Watch.
+const markerPrefix = "!kv!watch!"
+
+// metaValue is the bit of an entry's UserMeta that marks it as a value,
+// so that the change feed of Watch tells empty values, which codec.Raw
+// produces, apart from deletes.
+const metaValue byte = 1
+
+// newEntry returns the entry that stores data for k.
+func newEntry(k string, data []byte) *badger.Entry {
+	return badger.NewEntry([]byte(k), data).WithMeta(metaValue)
+}
+
 // Close closes the store.
 func (s Store) Close() error {
 	return s.Db.Close()
@@ -109,3 +508,14 @@ func NewStore(options *Options) (Store, error) {
 
 	return result, nil
 }
+
+// init registers the store as "badgerdb" with package stores.
+func init() {
+	stores.Register("badgerdb", func(cfg map[string]interface{}) (gokv.Store, error) {
+		options := DefaultOptions
+		if err := stores.DecodeOptions(cfg, &options); err != nil {
+			return nil, err
+		}
+		return NewStore(&options)
+	})
+}
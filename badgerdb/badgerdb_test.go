@@ -1,6 +1,9 @@
 package badgerdb
 
 import (
+	"databases/codec"
+	"databases/kv"
+	"databases/storetest"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
@@ -8,6 +11,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/philippgille/gokv"
 	"github.com/philippgille/gokv/encoding"
 )
 
@@ -42,6 +46,61 @@ var NS = NetworkStats{
 	},
 }
 
+func TestStore(t *testing.T) {
+	storetest.TestStore(t, func(t *testing.T) gokv.Store {
+		tmpDir, err := ioutil.TempDir("", "store")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ops := &Options{
+			Dir:   tmpDir,
+			Codec: encoding.JSON,
+		}
+		s, err := NewStore(ops)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { os.RemoveAll(tmpDir) })
+		return s
+	})
+}
+
+// TestWatchEvents checks that watchers don't see each other's markers
+// and tell empty values apart from deletes.
+func TestWatchEvents(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	s, err := NewStore(&Options{Dir: tmpDir, Codec: codec.Raw})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	events, stop := s.Watch("")
+	defer stop()
+	_, stopOther := s.Watch("sen")
+	defer stopOther()
+	if err := s.Set("sen1", []byte{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("sen1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []kv.EventType{kv.EventSet, kv.EventDelete} {
+		select {
+		case e := <-events:
+			if e.Key != "sen1" || e.Type != want {
+				t.Errorf("got %v of %q, want %v of sen1", e.Type, e.Key, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for an event")
+		}
+	}
+}
+
 func BenchmarkSet(b *testing.B) {
 	tmpDir, _ := ioutil.TempDir("", "store")
 	defer os.RemoveAll(tmpDir)
@@ -0,0 +1,153 @@
+// Package backends lists the store implementations of this repository
+// as benchmark backends. The stores are opened through package stores
+// with options that keep their files in the benchmark's directory.
+package backends
+
+import (
+	"context"
+	"databases/bench"
+	"databases/mongodb"
+	"databases/stores"
+	_ "databases/stores/all"
+	"databases/writebehind"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/philippgille/gokv"
+	"github.com/philippgille/gokv/encoding"
+)
+
+// RedisAddress is the address of the Redis server, or Redis protocol
+// server like kvserver, that the "redis" backend uses. The backend is
+// only available when it is set. It writes to database 0 of the server
+// without emptying it first, so the database should be dedicated to it.
+var RedisAddress string
+
+// MongoDBURI is the connection string of the MongoDB server that the
+// "mongodb" backend uses. The backend is only available when it is set.
+// It drops and reuses the collection "bench" of the database "databases".
+var MongoDBURI string
+
+// All returns all backends, in the order of the README results table.
+func All() []bench.Backend {
+	all := []bench.Backend{
+		backend("ristretto", nil),
+		backend("bigcache", nil),
+		backend("memory", nil),
+		backend("moss", nil),
+		backend("pudge", func(dir string) map[string]interface{} {
+			return map[string]interface{}{"File": filepath.Join(dir, "db")}
+		}),
+		backend("badgerdb", inDir),
+		backend("nutsdb", inDir),
+	}
+	if RedisAddress != "" {
+		all = append(all, backend("redis", func(string) map[string]interface{} {
+			return map[string]interface{}{"Address": RedisAddress}
+		}))
+	}
+	if MongoDBURI != "" {
+		all = append(all, bench.Backend{Name: "mongodb", Open: openMongoDB})
+	}
+	return all
+}
+
+// WriteBehindPrefix prefixes the name of a backend to wrap its store in
+// a writebehind.Store, like "writebehind-nutsdb". The wrapped backends
+// aren't part of All.
+const WriteBehindPrefix = "writebehind-"
+
+// Lookup returns the backends with the given names.
+func Lookup(names ...string) ([]bench.Backend, error) {
+	all := All()
+	result := make([]bench.Backend, 0, len(names))
+	for _, name := range names {
+		found := false
+		for _, b := range all {
+			if b.Name == name {
+				result = append(result, b)
+				found = true
+				break
+			}
+		}
+		if inner := strings.TrimPrefix(name, WriteBehindPrefix); !found && inner != name {
+			b, err := Lookup(inner)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, writeBehind(b[0]))
+			found = true
+		}
+		if !found && name == "redis" {
+			return nil, fmt.Errorf("backends: the redis backend needs a RedisAddress")
+		}
+		if !found && name == "mongodb" {
+			return nil, fmt.Errorf("backends: the mongodb backend needs a MongoDBURI")
+		}
+		if !found {
+			return nil, fmt.Errorf("backends: unknown backend %q", name)
+		}
+	}
+	return result, nil
+}
+
+// backend returns the backend that opens the store registered with name
+// with the options that config returns for the benchmark's directory.
+// A nil config means the store's DefaultOptions.
+func backend(name string, config func(dir string) map[string]interface{}) bench.Backend {
+	return bench.Backend{
+		Name: name,
+		Open: func(dir string, codec encoding.Codec) (gokv.Store, error) {
+			cfg := map[string]interface{}{}
+			if config != nil {
+				cfg = config(dir)
+			}
+			cfg["codec"] = codec
+			return stores.Open(name, cfg)
+		},
+	}
+}
+
+// writeBehind returns the backend that wraps the store of b
+// in a writebehind.Store with the default options.
+func writeBehind(b bench.Backend) bench.Backend {
+	return bench.Backend{
+		Name: WriteBehindPrefix + b.Name,
+		Open: func(dir string, codec encoding.Codec) (gokv.Store, error) {
+			s, err := b.Open(dir, codec)
+			if err != nil {
+				return nil, err
+			}
+			ops := writebehind.DefaultOptions
+			ops.Codec = codec
+			wb, err := writebehind.NewStore(s, &ops)
+			if err != nil {
+				s.Close()
+				return nil, err
+			}
+			return wb, nil
+		},
+	}
+}
+
+// inDir is the config of stores that keep their files in a directory.
+func inDir(dir string) map[string]interface{} {
+	return map[string]interface{}{"Dir": dir}
+}
+
+func openMongoDB(dir string, codec encoding.Codec) (gokv.Store, error) {
+	s, err := backend("mongodb", func(string) map[string]interface{} {
+		return map[string]interface{}{"URI": MongoDBURI, "Collection": "bench"}
+	}).Open(dir, codec)
+	if err != nil {
+		return nil, err
+	}
+	// Start with an empty collection, but keep its TTL index.
+	ms := s.(mongodb.Store)
+	if _, err := ms.Collection.DeleteMany(context.Background(), map[string]interface{}{}); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
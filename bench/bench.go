@@ -0,0 +1,597 @@
+// Package bench drives gokv.Store implementations through identical workloads.
+//
+// Every backend gets the same key count, value, key distribution and warm-up
+// and is only ever accessed through the gokv.Store interface, so the results
+// of different backends can be compared with each other. The one exception
+// are scans, which use kv.Scanner where a backend supports it.
+package bench
+
+import (
+	"databases/codec"
+	"databases/kv"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/philippgille/gokv"
+	"github.com/philippgille/gokv/encoding"
+)
+
+// Backend is a store implementation that can be benchmarked.
+type Backend struct {
+	// Name of the backend, like "badgerdb".
+	Name string
+	// Open creates an empty store that keeps its files, if any, in dir.
+	Open func(dir string, codec encoding.Codec) (gokv.Store, error)
+}
+
+// Options are the options for a benchmark run.
+type Options struct {
+	// Number of distinct keys. Read and delete workloads load
+	// all of them before the measurement starts.
+	Keys int
+	// Kind of the written values.
+	ValueKind ValueKind
+	// Size of a value in bytes. It's the size of the data of payloads,
+	// and the approximate size of NetworkStats records encoded as JSON.
+	ValueSize int
+	// Maximum total size of the values of all keys. If Keys values of
+	// ValueSize bytes exceed it, fewer keys are used. 0 means no limit.
+	MaxDataSize int64
+	// Duration of the measurement of a single workload.
+	Duration time.Duration
+	// Duration of the warm-up that precedes the measurement.
+	Warmup time.Duration
+	// Seed of the random key selection.
+	Seed int64
+	// Number of goroutines that run the workload concurrently.
+	Workers int
+	// Distribution of the accessed keys. If its name is empty,
+	// every workload uses its own distribution.
+	Distribution Distribution
+	// Encoding format. Payloads are written as their data alone
+	// with codec.Raw, which can't encode NetworkStats records.
+	Codec encoding.Codec
+}
+
+// DefaultOptions is an Options object with default values.
+var DefaultOptions = Options{
+	Keys:        1000,
+	ValueKind:   StatsValue,
+	ValueSize:   256,
+	MaxDataSize: 256 << 20,
+	Duration:    time.Second,
+	Warmup:      100 * time.Millisecond,
+	Seed:        1,
+	Workers:     1,
+	Codec:       encoding.JSON,
+}
+
+// Result is the measurement of a single workload against a single backend.
+type Result struct {
+	Backend   string    `json:"backend"`
+	Workload  string    `json:"workload"`
+	Keys      int       `json:"keys"`
+	ValueKind ValueKind `json:"value_kind"`
+	ValueSize int       `json:"value_size"`
+	Codec     string    `json:"codec"`
+	// Size of the value encoded with the codec, which is what the
+	// backend stores. Comparing it across codecs shows the space that
+	// compression saves, and ns/op the time it costs.
+	StoredSize   int           `json:"stored_size"`
+	Distribution string        `json:"distribution"`
+	Workers      int           `json:"workers"`
+	Ops          int64         `json:"ops"`
+	Duration     time.Duration `json:"duration"`
+	// Wall-clock time per operation, like b.RunParallel reports it.
+	NsPerOp     int64   `json:"ns_per_op"`
+	OpsPerSec   float64 `json:"ops_per_sec"`
+	BytesPerOp  int64   `json:"bytes_per_op"`
+	AllocsPerOp int64   `json:"allocs_per_op"`
+	// Fraction of the reads that found a value.
+	HitRate float64 `json:"hit_rate"`
+	// Latencies of the operations the workload consists of.
+	Latencies []Latency `json:"latencies"`
+
+	histograms [numOperations]*Histogram
+}
+
+// Histogram returns the latency histogram of the operation,
+// or nil if the workload didn't run it.
+func (r Result) Histogram(op Operation) *Histogram {
+	if op < 0 || op >= numOperations {
+		return nil
+	}
+	return r.histograms[op]
+}
+
+// Latency summarizes the latencies of a single kind of operation.
+type Latency struct {
+	Operation Operation     `json:"operation"`
+	Count     int64         `json:"count"`
+	Mean      time.Duration `json:"mean"`
+	P50       time.Duration `json:"p50"`
+	P90       time.Duration `json:"p90"`
+	P99       time.Duration `json:"p99"`
+	P999      time.Duration `json:"p99_9"`
+	Max       time.Duration `json:"max"`
+}
+
+// newLatency summarizes the latencies recorded by h.
+func newLatency(op Operation, h *Histogram) Latency {
+	return Latency{
+		Operation: op,
+		Count:     h.Count(),
+		Mean:      h.Mean(),
+		P50:       h.Percentile(50),
+		P90:       h.Percentile(90),
+		P99:       h.Percentile(99),
+		P999:      h.Percentile(99.9),
+		Max:       h.Max(),
+	}
+}
+
+// Run runs every workload against every backend.
+// Each combination gets a freshly opened store.
+func Run(backends []Backend, workloads []Workload, options *Options) ([]Result, error) {
+	var results []Result
+	for _, b := range backends {
+		for _, w := range workloads {
+			r, err := RunOne(b, w, options)
+			if err != nil {
+				return results, err
+			}
+			results = append(results, r)
+		}
+	}
+	return results, nil
+}
+
+// RunOne runs a workload against a freshly opened store of the backend.
+// With more than one worker, the workers share the store and run the
+// workload concurrently, each with its own random source.
+func RunOne(backend Backend, workload Workload, options *Options) (Result, error) {
+	if options == nil {
+		options = &DefaultOptions
+	}
+	opts := *options
+	if opts.Keys <= 0 {
+		return Result{}, fmt.Errorf("bench: invalid key count %d", opts.Keys)
+	}
+	if opts.Duration <= 0 {
+		return Result{}, fmt.Errorf("bench: invalid duration %v", opts.Duration)
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+	if opts.ValueKind == "" {
+		opts.ValueKind = StatsValue
+	}
+	if opts.Codec == nil {
+		opts.Codec = encoding.JSON
+	}
+	if opts.MaxDataSize > 0 && opts.ValueSize > 0 && int64(opts.Keys)*int64(opts.ValueSize) > opts.MaxDataSize {
+		opts.Keys = int(opts.MaxDataSize / int64(opts.ValueSize))
+		if opts.Keys < 1 {
+			opts.Keys = 1
+		}
+	}
+
+	result := Result{
+		Backend:      backend.Name,
+		Workload:     workload.Name,
+		Keys:         opts.Keys,
+		ValueKind:    opts.ValueKind,
+		ValueSize:    opts.ValueSize,
+		Codec:        codecName(opts.Codec),
+		Distribution: distribution(workload, &opts).Name,
+		Workers:      opts.Workers,
+	}
+	err := withStore(backend, &opts, func(s gokv.Store) error {
+		r, err := newRun(s, workload, &opts)
+		if err != nil {
+			return err
+		}
+		data, err := opts.Codec.Marshal(r.value)
+		if err != nil {
+			return err
+		}
+		result.StoredSize = len(data)
+		workers := make([]*worker, opts.Workers)
+		for i := range workers {
+			workers[i] = r.newWorker(int64(i))
+		}
+
+		if err := parallel(workers, func(w *worker) error {
+			return w.warmup(opts.Warmup)
+		}); err != nil {
+			return err
+		}
+
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		start := time.Now()
+		deadline := start.Add(opts.Duration)
+		if err := parallel(workers, func(w *worker) error {
+			return w.measure(start, deadline)
+		}); err != nil {
+			return err
+		}
+		elapsed := time.Since(start)
+		runtime.ReadMemStats(&after)
+
+		var ops, reads, hits int64
+		var latencies [numOperations]Histogram
+		for _, w := range workers {
+			ops += w.ops
+			reads += w.reads
+			hits += w.hits
+			for op := range latencies {
+				latencies[op].Merge(&w.latencies[op])
+			}
+		}
+		result.Ops = ops
+		result.Duration = elapsed
+		result.OpsPerSec = float64(ops) / elapsed.Seconds()
+		// A slow store may not complete an op within the duration.
+		if ops > 0 {
+			result.NsPerOp = elapsed.Nanoseconds() / ops
+			result.BytesPerOp = int64(after.TotalAlloc-before.TotalAlloc) / ops
+			result.AllocsPerOp = int64(after.Mallocs-before.Mallocs) / ops
+		}
+		if reads > 0 {
+			result.HitRate = float64(hits) / float64(reads)
+		}
+		for op := range latencies {
+			if h := &latencies[op]; h.Count() > 0 {
+				result.histograms[op] = h
+				result.Latencies = append(result.Latencies, newLatency(Operation(op), h))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return result, fmt.Errorf("bench: %s/%s: %v", backend.Name, workload.Name, err)
+	}
+	return result, nil
+}
+
+// parallel calls fn for every worker in its own goroutine
+// and returns the first error.
+func parallel(workers []*worker, fn func(w *worker) error) error {
+	errs := make(chan error, len(workers))
+	for _, w := range workers {
+		go func(w *worker) {
+			errs <- fn(w)
+		}(w)
+	}
+	var first error
+	for range workers {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Benchmark runs a workload against a freshly opened store of the backend
+// with b.N operations, so that it can be used from a testing benchmark.
+func Benchmark(b *testing.B, backend Backend, workload Workload, options *Options) {
+	if options == nil {
+		options = &DefaultOptions
+	}
+	err := withStore(backend, options, func(s gokv.Store) error {
+		r, err := newRun(s, workload, options)
+		if err != nil {
+			return err
+		}
+		w := r.newWorker(0)
+		if err := w.warmup(options.Warmup); err != nil {
+			return err
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := w.step(); err != nil {
+				return err
+			}
+		}
+		b.StopTimer()
+		return nil
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+}
+
+// BenchmarkParallel is like Benchmark, but runs the operations from
+// multiple goroutines with b.RunParallel. The number of goroutines
+// is controlled with b.SetParallelism and the -cpu flag.
+func BenchmarkParallel(b *testing.B, backend Backend, workload Workload, options *Options) {
+	if options == nil {
+		options = &DefaultOptions
+	}
+	err := withStore(backend, options, func(s gokv.Store) error {
+		r, err := newRun(s, workload, options)
+		if err != nil {
+			return err
+		}
+		var id int64
+		errs := make(chan error, 1)
+		b.ReportAllocs()
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			w := r.newWorker(atomic.AddInt64(&id, 1))
+			for pb.Next() {
+				if err := w.step(); err != nil {
+					select {
+					case errs <- err:
+					default:
+					}
+					return
+				}
+			}
+		})
+		b.StopTimer()
+		select {
+		case err := <-errs:
+			return err
+		default:
+			return nil
+		}
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+}
+
+// withStore opens a store of the backend in a temporary directory,
+// calls fn and closes the store again.
+func withStore(backend Backend, options *Options, fn func(gokv.Store) error) error {
+	dir, err := ioutil.TempDir("", "bench-"+backend.Name)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	codec := options.Codec
+	if codec == nil {
+		codec = encoding.JSON
+	}
+	s, err := backend.Open(dir, codec)
+	if err != nil {
+		return err
+	}
+	if err := fn(s); err != nil {
+		s.Close()
+		return err
+	}
+	return s.Close()
+}
+
+// codecName returns the name the codec is registered with,
+// or its type if it isn't registered.
+func codecName(c encoding.Codec) string {
+	if name := codec.Name(c); name != "" {
+		return name
+	}
+	return fmt.Sprintf("%T", c)
+}
+
+// CheckCodec returns an error if the codec can't encode values of the kind,
+// like codec.Raw can't encode NetworkStats records.
+func CheckCodec(c encoding.Codec, kind ValueKind) error {
+	value, _, err := newValue(kind, 0, 1, c)
+	if err != nil {
+		return err
+	}
+	if _, err := c.Marshal(value); err != nil {
+		return fmt.Errorf("bench: can't write %s values with the %s codec: %v", kind, codecName(c), err)
+	}
+	return nil
+}
+
+// distribution returns the key distribution of the workload run.
+func distribution(workload Workload, options *Options) Distribution {
+	if options.Distribution.Name != "" {
+		return options.Distribution
+	}
+	if workload.Distribution.Name != "" {
+		return workload.Distribution
+	}
+	return Uniform
+}
+
+// run holds the state of a workload run that all of its workers share.
+type run struct {
+	store    gokv.Store
+	workload Workload
+	options  *Options
+	loaded   []string
+	value    interface{}
+	newDst   func() interface{}
+	// Number of the next record to insert.
+	next int64
+}
+
+// newRun creates the keys and the value for a workload run
+// and loads the keys into the store if the workload needs them.
+func newRun(s gokv.Store, workload Workload, options *Options) (*run, error) {
+	value, newDst, err := newValue(options.ValueKind, options.ValueSize, options.Seed, options.Codec)
+	if err != nil {
+		return nil, err
+	}
+	r := &run{
+		store:    s,
+		workload: workload,
+		options:  options,
+		loaded:   makeKeys(options.Keys),
+		value:    value,
+		newDst:   newDst,
+		next:     int64(options.Keys),
+	}
+	if workload.load {
+		for _, k := range r.loaded {
+			if err := s.Set(k, r.value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return r, nil
+}
+
+// newWorker creates the worker with the given ID.
+func (r *run) newWorker(id int64) *worker {
+	scanner, _ := r.store.(kv.Scanner)
+	return &worker{
+		run:     r,
+		scanner: scanner,
+		rnd:     rand.New(rand.NewSource(r.options.Seed + id)),
+		chooser: distribution(r.workload, r.options).NewKeyChooser(),
+		// Keys inserted by the worker are appended to its own copy.
+		keys: r.loaded[:len(r.loaded):len(r.loaded)],
+		dst:  r.newDst(),
+	}
+}
+
+// worker runs a workload against a store. Every worker runs in its own
+// goroutine, so it keeps its own random source, keys and statistics.
+type worker struct {
+	*run
+	rnd     *rand.Rand
+	chooser KeyChooser
+	keys    []string
+	// Scanner of the store, or nil if scans read the records one by one.
+	scanner kv.Scanner
+	// Value that reads decode into.
+	dst interface{}
+	// Number of measured operations.
+	ops int64
+	// Number of measured reads and of reads that found a value.
+	reads, hits int64
+	// Latencies of the measured operations.
+	latencies [numOperations]Histogram
+}
+
+func (w *worker) step() error {
+	_, err := w.workload.op(w)
+	return err
+}
+
+// warmup runs the workload for the duration d without measuring it.
+func (w *worker) warmup(d time.Duration) error {
+	deadline := time.Now().Add(d)
+	for time.Now().Before(deadline) {
+		if err := w.step(); err != nil {
+			return err
+		}
+	}
+	w.reads, w.hits = 0, 0
+	return nil
+}
+
+// measure runs the workload from start until the deadline
+// and records the latency of every operation.
+func (w *worker) measure(start, deadline time.Time) error {
+	// The end of an operation is the start of the next one,
+	// so that every operation costs a single clock reading.
+	last := start
+	for last.Before(deadline) {
+		op, err := w.workload.op(w)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		w.latencies[op].Record(now.Sub(last))
+		last = now
+		w.ops++
+	}
+	return nil
+}
+
+// key returns the next key according to the key distribution.
+func (w *worker) key() string {
+	return w.keys[w.chooser.Next(w.rnd, len(w.keys))]
+}
+
+// get reads the value of k and counts the hit or miss.
+func (w *worker) get(k string) error {
+	found, err := w.store.Get(k, w.dst)
+	if err != nil {
+		return err
+	}
+	w.reads++
+	if found {
+		w.hits++
+	}
+	return nil
+}
+
+// insert writes the value for a new key.
+func (w *worker) insert() error {
+	k := "sen" + strconv.FormatInt(atomic.AddInt64(&w.next, 1)-1, 10)
+	if err := w.store.Set(k, w.value); err != nil {
+		return err
+	}
+	w.keys = append(w.keys, k)
+	return nil
+}
+
+// scan reads up to n records, starting at one chosen by the key
+// distribution. Stores that implement kv.Scanner return the records
+// that follow it in key order. The others read the records with
+// consecutive record numbers one by one.
+func (w *worker) scan(n int) error {
+	start := w.chooser.Next(w.rnd, len(w.keys))
+	if w.scanner != nil {
+		err := w.scanRange(w.keys[start], n)
+		if err != kv.ErrNotSupported {
+			return err
+		}
+		w.scanner = nil
+	}
+	for i := start; i < start+n && i < len(w.keys); i++ {
+		if err := w.get(w.keys[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanRange reads up to n records in key order, starting at key start.
+func (w *worker) scanRange(start string, n int) error {
+	it, err := w.scanner.Scan("", start, "")
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n && it.Next(); i++ {
+		if err := it.Value(w.dst); err != nil {
+			it.Close()
+			return err
+		}
+		w.reads++
+		w.hits++
+	}
+	if err := it.Err(); err != nil {
+		it.Close()
+		return err
+	}
+	return it.Close()
+}
+
+// makeKeys creates the keys "sen0" to "sen<n-1>" up front,
+// so that building them isn't part of the measurement.
+func makeKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = "sen" + strconv.Itoa(i)
+	}
+	return keys
+}
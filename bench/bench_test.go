@@ -0,0 +1,493 @@
+package bench_test
+
+import (
+	"bytes"
+	"databases/bench"
+	"databases/bench/backends"
+	"databases/codec"
+	"databases/memory"
+	"databases/resp"
+	"encoding/json"
+	"math/rand"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	memory, err := backends.Lookup("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops := bench.DefaultOptions
+	ops.Keys = 100
+	ops.Duration = 10 * time.Millisecond
+	ops.Warmup = time.Millisecond
+
+	results, err := bench.Run(memory, bench.Workloads(), &ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(bench.Workloads()) {
+		t.Fatalf("got %d results, want %d", len(results), len(bench.Workloads()))
+	}
+	for i, r := range results {
+		if r.Backend != "memory" || r.Workload != bench.Workloads()[i].Name {
+			t.Errorf("unexpected result %+v", r)
+		}
+		if r.Ops <= 0 || r.NsPerOp <= 0 {
+			t.Errorf("%s: no operations measured: %+v", r.Workload, r)
+		}
+		var count int64
+		for _, l := range r.Latencies {
+			count += l.Count
+			if l.P50 > l.P99 || l.P99 > l.Max {
+				t.Errorf("%s: percentiles out of order: %+v", r.Workload, l)
+			}
+			if h := r.Histogram(l.Operation); h == nil || h.Count() != l.Count {
+				t.Errorf("%s: histogram of %v doesn't match its latency summary", r.Workload, l.Operation)
+			}
+		}
+		if count != r.Ops {
+			t.Errorf("%s: %d latencies recorded for %d operations", r.Workload, count, r.Ops)
+		}
+	}
+
+	ops.Duration = 0
+	if _, err := bench.RunOne(memory[0], bench.Get, &ops); err == nil {
+		t.Error("RunOne without a duration succeeded")
+	}
+}
+
+func TestRunConcurrent(t *testing.T) {
+	memory, err := backends.Lookup("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops := bench.DefaultOptions
+	ops.Keys = 100
+	ops.Duration = 10 * time.Millisecond
+	ops.Warmup = time.Millisecond
+	ops.Workers = 4
+
+	results, err := bench.Run(memory, []bench.Workload{bench.WorkloadA, bench.WorkloadD}, &ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if r.Workers != 4 || r.Ops <= 0 || r.OpsPerSec <= 0 {
+			t.Errorf("unexpected result %+v", r)
+		}
+	}
+}
+
+// TestScanMix checks that mixes with scans work without a MaxScanLength.
+func TestScanMix(t *testing.T) {
+	memory, err := backends.Lookup("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops := bench.DefaultOptions
+	ops.Keys = 100
+	ops.Duration = 10 * time.Millisecond
+	ops.Warmup = time.Millisecond
+	w := bench.NewWorkload("scan", bench.Mix{Scan: 1}, bench.Uniform)
+	if r, err := bench.RunOne(memory[0], w, &ops); err != nil || r.Ops == 0 {
+		t.Errorf("got %+v, %v", r, err)
+	}
+}
+
+func TestWriteScaling(t *testing.T) {
+	scaling := []bench.Result{
+		{Backend: "memory", Workload: "get", ValueKind: bench.RandomValue, ValueSize: 256, Workers: 1, OpsPerSec: 1000},
+		{Backend: "memory", Workload: "get", ValueKind: bench.RandomValue, ValueSize: 256, Workers: 4, OpsPerSec: 3000},
+		{Backend: "moss", Workload: "get", ValueKind: bench.RandomValue, ValueSize: 256, Workers: 4, OpsPerSec: 2000},
+	}
+	var buf bytes.Buffer
+	if err := bench.WriteReport(&buf, "scaling", scaling); err != nil {
+		t.Fatal(err)
+	}
+	want := "backend,workload,value_kind,value_size,codec,workers_1,workers_4\n" +
+		"memory,get,random,256,,1000,3000\n" +
+		"moss,get,random,256,,,2000\n"
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	var h bench.Histogram
+	for i := 1; i <= 1000; i++ {
+		h.Record(time.Duration(i) * time.Microsecond)
+	}
+	if h.Count() != 1000 || h.Min() != time.Microsecond || h.Max() != time.Millisecond {
+		t.Fatalf("got count %d, min %v, max %v", h.Count(), h.Min(), h.Max())
+	}
+	for _, tc := range []struct {
+		p    float64
+		want time.Duration
+	}{
+		{50, 500 * time.Microsecond},
+		{90, 900 * time.Microsecond},
+		{99, 990 * time.Microsecond},
+		{100, time.Millisecond},
+	} {
+		got := h.Percentile(tc.p)
+		// The histogram has a relative error below 1%.
+		if got < tc.want || got > tc.want+tc.want/100 {
+			t.Errorf("Percentile(%v) = %v, want %v", tc.p, got, tc.want)
+		}
+	}
+
+	var merged bench.Histogram
+	merged.Merge(&h)
+	merged.Record(time.Second)
+	if merged.Count() != 1001 || merged.Max() != time.Second || merged.Min() != time.Microsecond {
+		t.Errorf("got count %d, min %v, max %v after merge", merged.Count(), merged.Min(), merged.Max())
+	}
+	var total int64
+	for _, b := range merged.Buckets() {
+		total += b.Count
+	}
+	if total != merged.Count() {
+		t.Errorf("buckets count %d durations, want %d", total, merged.Count())
+	}
+}
+
+func TestDistributions(t *testing.T) {
+	const (
+		n    = 1000
+		runs = 100000
+	)
+	for _, d := range bench.Distributions() {
+		t.Run(d.Name, func(t *testing.T) {
+			rnd := rand.New(rand.NewSource(1))
+			chooser := d.NewKeyChooser()
+			counts := make([]int, n)
+			for i := 0; i < runs; i++ {
+				k := chooser.Next(rnd, n)
+				if k < 0 || k >= n {
+					t.Fatalf("Next returned %d, want a value in [0, %d)", k, n)
+				}
+				counts[k]++
+			}
+			var head, tail int
+			for i := 0; i < n/10; i++ {
+				head += counts[i]
+				tail += counts[n-1-i]
+			}
+			switch d.Name {
+			case "zipfian", "hotspot":
+				// Hotspot(0.2, 0.8) sends 40% of the accesses to the first 10% of the keys.
+				if head < runs/3 {
+					t.Errorf("first 10%% of the keys got only %d of %d accesses", head, runs)
+				}
+			case "latest":
+				if tail < runs/2 {
+					t.Errorf("last 10%% of the keys got only %d of %d accesses", tail, runs)
+				}
+			case "uniform", "sequential":
+				if head > runs/5 || tail > runs/5 {
+					t.Errorf("accesses are skewed: %d to the first and %d to the last 10%% of the keys", head, tail)
+				}
+			}
+		})
+	}
+}
+
+func TestZipfianGrowingKeys(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	chooser := bench.Zipfian(bench.DefaultTheta).NewKeyChooser()
+	for n := 1; n < 2000; n++ {
+		if k := chooser.Next(rnd, n); k < 0 || k >= n {
+			t.Fatalf("Next(%d) returned %d", n, k)
+		}
+	}
+}
+
+func TestLookupDistribution(t *testing.T) {
+	if _, err := bench.LookupDistribution("zipfian", 1); err == nil {
+		t.Error("theta of 1 returned no error")
+	}
+	if d, err := bench.LookupDistribution("hotspot", 0); err != nil || d.Name != "hotspot" {
+		t.Errorf("LookupDistribution(hotspot) = %v, %v", d.Name, err)
+	}
+	if _, err := bench.LookupDistribution("unknown", bench.DefaultTheta); err == nil {
+		t.Error("unknown distribution returned no error")
+	}
+}
+
+func TestNewValue(t *testing.T) {
+	for _, size := range []int{0, 256, 4096, 1 << 20} {
+		data, err := json.Marshal(bench.NewValue(size))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) < size || len(data) > size+256 {
+			t.Errorf("NewValue(%d) encodes to %d bytes", size, len(data))
+		}
+	}
+}
+
+func TestNewPayload(t *testing.T) {
+	for _, kind := range []bench.ValueKind{bench.CompressibleValue, bench.RandomValue} {
+		p, err := bench.NewPayload(kind, 4096, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(p.Data) != 4096 {
+			t.Errorf("%s payload has %d bytes, want 4096", kind, len(p.Data))
+		}
+	}
+	if _, err := bench.NewPayload(bench.StatsValue, 64, 1); err == nil {
+		t.Error("NewPayload of stats values returned no error")
+	}
+}
+
+func TestRunValueKinds(t *testing.T) {
+	memory, err := backends.Lookup("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, kind := range bench.ValueKinds {
+		ops := bench.DefaultOptions
+		ops.Keys = 100
+		ops.ValueKind = kind
+		ops.ValueSize = 1 << 20
+		ops.MaxDataSize = 10 << 20
+		ops.Duration = 10 * time.Millisecond
+		ops.Warmup = time.Millisecond
+
+		r, err := bench.RunOne(memory[0], bench.Get, &ops)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.ValueKind != kind || r.Keys != 10 {
+			t.Errorf("got value kind %q with %d keys, want %q with 10 keys", r.ValueKind, r.Keys, kind)
+		}
+		if r.HitRate != 1 {
+			t.Errorf("%s: hit rate %v, want 1", kind, r.HitRate)
+		}
+	}
+}
+
+func TestRunCodecs(t *testing.T) {
+	memory, err := backends.Lookup("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range codec.Names() {
+		c, err := codec.Lookup(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, kind := range bench.ValueKinds {
+			if err := bench.CheckCodec(c, kind); err != nil {
+				if name != "raw" || kind != bench.StatsValue {
+					t.Errorf("%s can't write %s values: %v", name, kind, err)
+				}
+				continue
+			}
+			ops := bench.DefaultOptions
+			ops.Keys = 10
+			ops.ValueKind = kind
+			ops.Codec = c
+			ops.Duration = 10 * time.Millisecond
+			ops.Warmup = time.Millisecond
+
+			r, err := bench.RunOne(memory[0], bench.Get, &ops)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Codec != name || r.HitRate != 1 {
+				t.Errorf("%s/%s: got codec %q with hit rate %v", name, kind, r.Codec, r.HitRate)
+			}
+		}
+	}
+	if err := bench.CheckCodec(codec.Raw, bench.StatsValue); err == nil {
+		t.Error("the raw codec can write stats values")
+	}
+}
+
+func TestRunCompressed(t *testing.T) {
+	memory, err := backends.Lookup("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := make(map[string]int)
+	for _, name := range []string{"json", "json+flate", "json+gzip"} {
+		c, err := codec.Lookup(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ops := bench.DefaultOptions
+		ops.Keys = 10
+		ops.ValueKind = bench.CompressibleValue
+		ops.ValueSize = 4096
+		ops.Codec = c
+		ops.Duration = 10 * time.Millisecond
+		ops.Warmup = time.Millisecond
+
+		r, err := bench.RunOne(memory[0], bench.Get, &ops)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Codec != name || r.HitRate != 1 {
+			t.Errorf("%s: got codec %q with hit rate %v", name, r.Codec, r.HitRate)
+		}
+		stored[name] = r.StoredSize
+	}
+	if stored["json"] < 4096 || stored["json+flate"] >= stored["json"]/4 || stored["json+gzip"] >= stored["json"]/4 {
+		t.Errorf("got stored sizes %v", stored)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	if _, err := backends.Lookup("memory", "unknown"); err == nil {
+		t.Error("Lookup of an unknown backend returned no error")
+	}
+	if _, err := backends.Lookup("writebehind-unknown"); err == nil {
+		t.Error("Lookup of an unknown wrapped backend returned no error")
+	}
+	wb, err := backends.Lookup("writebehind-memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops := bench.DefaultOptions
+	ops.Keys = 100
+	ops.Duration = 10 * time.Millisecond
+	ops.Warmup = time.Millisecond
+	r, err := bench.RunOne(wb[0], bench.Get, &ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Backend != "writebehind-memory" || r.HitRate != 1 {
+		t.Errorf("got backend %q with hit rate %v", r.Backend, r.HitRate)
+	}
+	if _, err := bench.LookupWorkload("unknown"); err == nil {
+		t.Error("LookupWorkload of an unknown workload returned no error")
+	}
+}
+
+func TestRedisBackend(t *testing.T) {
+	if _, err := backends.Lookup("redis"); err == nil {
+		t.Error("Lookup of redis without an address returned no error")
+	}
+
+	store, err := memory.NewStore(&memory.DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := resp.NewServer(store)
+	go srv.Serve(l)
+	defer srv.Close()
+
+	backends.RedisAddress = l.Addr().String()
+	defer func() { backends.RedisAddress = "" }()
+	redis, err := backends.Lookup("redis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops := bench.DefaultOptions
+	ops.Keys = 100
+	ops.Duration = 10 * time.Millisecond
+	ops.Warmup = time.Millisecond
+	results, err := bench.Run(redis, []bench.Workload{bench.WorkloadA, bench.WorkloadE}, &ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if r.Backend != "redis" || r.Ops <= 0 {
+			t.Errorf("unexpected result %+v", r)
+		}
+	}
+}
+
+var results = []bench.Result{
+	{Backend: "memory", Workload: "set", Keys: 10, ValueKind: bench.StatsValue, ValueSize: 256, Codec: "json", Distribution: "uniform", Workers: 1, Ops: 100, Duration: time.Millisecond, NsPerOp: 10000, OpsPerSec: 100000, BytesPerOp: 448, AllocsPerOp: 3},
+	{Backend: "memory", Workload: "get", Keys: 10, ValueKind: bench.StatsValue, ValueSize: 256, Codec: "json", Ops: 200, Duration: time.Millisecond, NsPerOp: 5000},
+	{Backend: "moss", Workload: "set", Keys: 10, ValueKind: bench.StatsValue, ValueSize: 256, Codec: "json", Ops: 50, Duration: time.Millisecond, NsPerOp: 20000, BytesPerOp: 3114, AllocsPerOp: 16},
+}
+
+func TestWriteMarkdown(t *testing.T) {
+	var buf bytes.Buffer
+	if err := bench.WriteReport(&buf, "markdown", results); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		`<td class="tg-5frq" colspan="4" align=Center>Write</td>`,
+		`<td class="tg-5frq" colspan="4" align=Center>Read</td>`,
+		`<td class="tg-8d8j" align=Center>memory</td>`,
+		`<td class="tg-8d8j" align=Center>3114</td>`,
+		`<td class="tg-8d8j" align=Center>-</td>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Markdown report doesn't contain %q:\n%s", want, out)
+		}
+	}
+	if n := strings.Count(out, "<th "); n != 1+2*4 {
+		t.Errorf("got %d header cells, want %d", n, 1+2*4)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := bench.WriteReport(&buf, "csv", results); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1+len(results) {
+		t.Fatalf("got %d lines, want %d", len(lines), 1+len(results))
+	}
+	if want := "memory,set,10,stats,256,json,0,uniform,1,100,1000000,10000,100000,448,3,0.0000"; lines[1] != want {
+		t.Errorf("got %q, want %q", lines[1], want)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := bench.WriteReport(&buf, "json", results); err != nil {
+		t.Fatal(err)
+	}
+	var got []bench.Result
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(results) || got[2].Backend != results[2].Backend || got[2].BytesPerOp != results[2].BytesPerOp {
+		t.Errorf("JSON report doesn't round-trip: %+v", got)
+	}
+	if err := bench.WriteReport(&buf, "yaml", results); err == nil {
+		t.Error("unknown format returned no error")
+	}
+}
+
+// BenchmarkStores runs every workload against every backend, so that
+// `go test -bench . ./bench` compares all of them in one run.
+func BenchmarkStores(b *testing.B) {
+	for _, backend := range backends.All() {
+		for _, workload := range bench.Workloads() {
+			b.Run(backend.Name+"/"+workload.Name, func(b *testing.B) {
+				bench.Benchmark(b, backend, workload, nil)
+			})
+		}
+	}
+}
+
+// BenchmarkStoresParallel is like BenchmarkStores, but runs the operations
+// from GOMAXPROCS goroutines. Use -cpu 1,2,4,8 to get a scaling curve.
+func BenchmarkStoresParallel(b *testing.B) {
+	for _, backend := range backends.All() {
+		for _, workload := range bench.Workloads() {
+			b.Run(backend.Name+"/"+workload.Name, func(b *testing.B) {
+				bench.BenchmarkParallel(b, backend, workload, nil)
+			})
+		}
+	}
+}
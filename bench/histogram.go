@@ -0,0 +1,159 @@
+package bench
+
+import (
+	"math/bits"
+	"time"
+)
+
+// subBuckets is the number of linear sub-buckets per power of two,
+// which bounds the relative error of a recorded value to 1/subBuckets.
+const (
+	subBucketBits = 7
+	subBuckets    = 1 << subBucketBits
+)
+
+// Histogram records durations with a relative error below 1%, like an
+// HDR histogram: values are counted in buckets whose width grows with
+// the power of two the value falls into.
+// The zero value is an empty histogram. It isn't safe for concurrent use.
+type Histogram struct {
+	counts   []int64
+	count    int64
+	sum      time.Duration
+	min, max time.Duration
+}
+
+// Bucket is a non-empty bucket of a histogram.
+type Bucket struct {
+	// Highest duration counted in the bucket.
+	Upper time.Duration `json:"upper"`
+	// Number of durations counted in the bucket.
+	Count int64 `json:"count"`
+}
+
+// Record adds the duration d to the histogram. Negative durations count as 0.
+func (h *Histogram) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	i := bucketIndex(uint64(d))
+	if i >= len(h.counts) {
+		counts := make([]int64, i+1, 2*(i+1))
+		copy(counts, h.counts)
+		h.counts = counts
+	}
+	h.counts[i]++
+	if h.count == 0 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	h.count++
+	h.sum += d
+}
+
+// Merge adds all durations recorded by other to the histogram.
+func (h *Histogram) Merge(other *Histogram) {
+	if other.count == 0 {
+		return
+	}
+	if len(other.counts) > len(h.counts) {
+		counts := make([]int64, len(other.counts))
+		copy(counts, h.counts)
+		h.counts = counts
+	}
+	for i, c := range other.counts {
+		h.counts[i] += c
+	}
+	if h.count == 0 || other.min < h.min {
+		h.min = other.min
+	}
+	if other.max > h.max {
+		h.max = other.max
+	}
+	h.count += other.count
+	h.sum += other.sum
+}
+
+// Count returns the number of recorded durations.
+func (h *Histogram) Count() int64 {
+	return h.count
+}
+
+// Min returns the lowest recorded duration.
+func (h *Histogram) Min() time.Duration {
+	return h.min
+}
+
+// Max returns the highest recorded duration.
+func (h *Histogram) Max() time.Duration {
+	return h.max
+}
+
+// Mean returns the mean of the recorded durations.
+func (h *Histogram) Mean() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.count)
+}
+
+// Percentile returns the duration that p percent of the recorded durations
+// don't exceed, up to the precision of the histogram. p is between 0 and 100.
+func (h *Histogram) Percentile(p float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := int64(p / 100 * float64(h.count))
+	if float64(rank) < p/100*float64(h.count) {
+		rank++
+	}
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			if upper := bucketUpper(i); upper < h.max {
+				return upper
+			}
+			return h.max
+		}
+	}
+	return h.max
+}
+
+// Buckets returns the non-empty buckets in ascending order.
+func (h *Histogram) Buckets() []Bucket {
+	var buckets []Bucket
+	for i, c := range h.counts {
+		if c > 0 {
+			buckets = append(buckets, Bucket{Upper: bucketUpper(i), Count: c})
+		}
+	}
+	return buckets
+}
+
+// bucketIndex returns the index of the bucket counting v.
+// Values below subBuckets get a bucket each, larger ones share
+// a bucket with the values that only differ in the bits below
+// the subBucketBits+1 most significant ones.
+func bucketIndex(v uint64) int {
+	if v < subBuckets {
+		return int(v)
+	}
+	shift := bits.Len64(v) - subBucketBits - 1
+	return subBuckets*(shift+1) + int(v>>uint(shift)) - subBuckets
+}
+
+// bucketUpper returns the highest value counted by the bucket with index i.
+func bucketUpper(i int) time.Duration {
+	if i < subBuckets {
+		return time.Duration(i)
+	}
+	shift := i/subBuckets - 1
+	base := uint64(i%subBuckets + subBuckets)
+	return time.Duration((base+1)<<uint(shift) - 1)
+}
@@ -0,0 +1,183 @@
+package bench
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// KeyChooser chooses which of the keys an operation accesses.
+// Key choosers are stateful and must not be shared between workers.
+type KeyChooser interface {
+	// Next returns the index of the next key out of n keys.
+	// n can grow between calls when a workload inserts keys.
+	Next(rnd *rand.Rand, n int) int
+}
+
+// Distribution is a named key distribution that creates key choosers.
+type Distribution struct {
+	// Name of the distribution, like "zipfian".
+	Name string
+	new  func() KeyChooser
+}
+
+// NewKeyChooser creates a key chooser with the distribution.
+func (d Distribution) NewKeyChooser() KeyChooser {
+	return d.new()
+}
+
+// DefaultTheta is the skew of the zipfian and latest distributions YCSB uses.
+const DefaultTheta = 0.99
+
+var (
+	// Uniform chooses every key with the same probability.
+	Uniform = Distribution{
+		Name: "uniform",
+		new:  func() KeyChooser { return uniform{} },
+	}
+	// Sequential chooses the keys one after the other, wrapping around at the end.
+	Sequential = Distribution{
+		Name: "sequential",
+		new:  func() KeyChooser { return &sequential{} },
+	}
+)
+
+// Zipfian chooses keys with a zipfian distribution, so that a few keys
+// get most of the accesses. The lower a key's index, the more popular it is.
+// theta must be between 0 and 1; the higher it is, the more skewed are the accesses.
+func Zipfian(theta float64) Distribution {
+	return Distribution{
+		Name: "zipfian",
+		new:  func() KeyChooser { return &zipfian{theta: theta} },
+	}
+}
+
+// Latest chooses keys with a zipfian distribution over their recency,
+// so that the most recently inserted keys are the most popular ones.
+func Latest(theta float64) Distribution {
+	return Distribution{
+		Name: "latest",
+		new:  func() KeyChooser { return latest{&zipfian{theta: theta}} },
+	}
+}
+
+// Hotspot chooses a key out of the first hotKeys fraction of keys for
+// the hotOps fraction of the accesses, and any other key otherwise.
+// Hotspot(0.2, 0.8) sends 80% of the accesses to 20% of the keys.
+func Hotspot(hotKeys, hotOps float64) Distribution {
+	return Distribution{
+		Name: "hotspot",
+		new:  func() KeyChooser { return hotspot{hotKeys: hotKeys, hotOps: hotOps} },
+	}
+}
+
+// Distributions returns all known distributions with their default parameters.
+func Distributions() []Distribution {
+	return []Distribution{Uniform, Zipfian(DefaultTheta), Hotspot(0.2, 0.8), Latest(DefaultTheta), Sequential}
+}
+
+// LookupDistribution returns the distribution with the given name.
+// The zipfian and latest distributions use the given theta.
+func LookupDistribution(name string, theta float64) (Distribution, error) {
+	if (name == "zipfian" || name == "latest") && (theta <= 0 || theta >= 1) {
+		return Distribution{}, fmt.Errorf("bench: theta must be between 0 and 1, got %v", theta)
+	}
+	switch name {
+	case "zipfian":
+		return Zipfian(theta), nil
+	case "latest":
+		return Latest(theta), nil
+	}
+	for _, d := range Distributions() {
+		if d.Name == name {
+			return d, nil
+		}
+	}
+	return Distribution{}, fmt.Errorf("bench: unknown distribution %q", name)
+}
+
+type uniform struct{}
+
+func (uniform) Next(rnd *rand.Rand, n int) int {
+	return rnd.Intn(n)
+}
+
+type sequential struct {
+	next int
+}
+
+func (s *sequential) Next(rnd *rand.Rand, n int) int {
+	i := s.next % n
+	s.next = i + 1
+	return i
+}
+
+type hotspot struct {
+	hotKeys, hotOps float64
+}
+
+func (h hotspot) Next(rnd *rand.Rand, n int) int {
+	hot := int(float64(n) * h.hotKeys)
+	if hot < 1 {
+		hot = 1
+	}
+	if hot >= n {
+		return rnd.Intn(n)
+	}
+	if rnd.Float64() < h.hotOps {
+		return rnd.Intn(hot)
+	}
+	return hot + rnd.Intn(n-hot)
+}
+
+type latest struct {
+	z *zipfian
+}
+
+func (l latest) Next(rnd *rand.Rand, n int) int {
+	return n - 1 - l.z.Next(rnd, n)
+}
+
+// zipfian is the zipfian generator of YCSB, which is based on
+// "Quickly Generating Billion-Record Synthetic Databases" by Gray et al.
+type zipfian struct {
+	theta float64
+	n     int
+	// zetan is the zeta function of n and theta, which
+	// is updated incrementally when n grows.
+	zetan float64
+	alpha float64
+	eta   float64
+}
+
+func (z *zipfian) Next(rnd *rand.Rand, n int) int {
+	if n != z.n {
+		z.resize(n)
+	}
+	u := rnd.Float64()
+	uz := u * z.zetan
+	if uz < 1 {
+		return 0
+	}
+	if uz < 1+math.Pow(0.5, z.theta) {
+		return 1
+	}
+	i := int(float64(n) * math.Pow(z.eta*u-z.eta+1, z.alpha))
+	if i >= n {
+		i = n - 1
+	}
+	return i
+}
+
+func (z *zipfian) resize(n int) {
+	if n < z.n {
+		z.n, z.zetan = 0, 0
+	}
+	for i := z.n + 1; i <= n; i++ {
+		z.zetan += 1 / math.Pow(float64(i), z.theta)
+	}
+	z.n = n
+	zeta2 := 1 + 1/math.Pow(2, z.theta)
+	z.alpha = 1 / (1 - z.theta)
+	z.eta = (1 - math.Pow(2/float64(n), 1-z.theta)) / (1 - zeta2/z.zetan)
+}
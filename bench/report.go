@@ -0,0 +1,348 @@
+package bench
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Formats are the names of the supported report formats.
+var Formats = []string{"markdown", "csv", "json", "latency", "scaling"}
+
+// WriteReport writes the results in the given format.
+func WriteReport(w io.Writer, format string, results []Result) error {
+	switch format {
+	case "markdown":
+		return WriteMarkdown(w, results)
+	case "csv":
+		return WriteCSV(w, results)
+	case "json":
+		return WriteJSON(w, results)
+	case "latency":
+		return WriteLatencies(w, results)
+	case "scaling":
+		return WriteScaling(w, results)
+	}
+	return fmt.Errorf("bench: unknown report format %q", format)
+}
+
+// columnTitles are the column group titles the README uses for the basic workloads.
+var columnTitles = map[string]string{
+	Set.Name:    "Write",
+	Get.Name:    "Read",
+	Delete.Name: "Delete",
+}
+
+// WriteMarkdown writes the results in the HTML table layout of the README,
+// one row per backend and one group of columns per workload.
+// Results with different value sizes or worker counts are written
+// as separate tables.
+func WriteMarkdown(w io.Writer, results []Result) error {
+	groups := distinct(results, tableTitle)
+	for i, title := range groups {
+		var group []Result
+		for _, r := range results {
+			if tableTitle(r) == title {
+				group = append(group, r)
+			}
+		}
+		if len(groups) > 1 {
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintf(w, "##### %s\n", title)
+		}
+		if err := writeTable(w, group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tableTitle returns the title of the Markdown table of the result.
+func tableTitle(r Result) string {
+	workers := "1 worker"
+	if r.Workers > 1 {
+		workers = strconv.Itoa(r.Workers) + " workers"
+	}
+	title := fmt.Sprintf("%s values of %d B", r.ValueKind, r.ValueSize)
+	if r.Codec != "" {
+		title += " as " + r.Codec
+	}
+	if r.StoredSize > 0 {
+		title += fmt.Sprintf(" (%d B stored)", r.StoredSize)
+	}
+	return title + ", " + workers
+}
+
+func writeTable(w io.Writer, results []Result) error {
+	backends := distinct(results, func(r Result) string { return r.Backend })
+	workloads := distinct(results, func(r Result) string { return r.Workload })
+	byKey := make(map[[2]string]Result, len(results))
+	for _, r := range results {
+		byKey[[2]string{r.Backend, r.Workload}] = r
+	}
+
+	p := &printer{w: w}
+	p.println(`<table class="tg">`)
+	p.println(`<thead>`)
+	p.println(`  <tr>`)
+	p.println(`    <th class="tg-bobw" align=Center>Database</th>`)
+	for range workloads {
+		for _, title := range []string{"Counter", "ns/op", "B/op ", "allocs/op"} {
+			p.printf("    <th class=\"tg-amwm\" align=Center>%s</th>\n", title)
+		}
+	}
+	p.println(`  </tr>`)
+	p.println(`</thead>`)
+	p.println(`<tbody>`)
+	p.println(`  <tr>`)
+	p.println(`    <td class="tg-5frq">operation</td>`)
+	for _, workload := range workloads {
+		name, ok := columnTitles[workload]
+		if !ok {
+			name = workload
+		}
+		p.printf("    <td class=\"tg-5frq\" colspan=\"4\" align=Center>%s</td>\n", name)
+	}
+	p.println(`  </tr>`)
+	for _, backend := range backends {
+		p.println(`  <tr>`)
+		p.printf("    <td class=\"tg-8d8j\" align=Center>%s</td>\n", backend)
+		for _, workload := range workloads {
+			r, ok := byKey[[2]string{backend, workload}]
+			cells := []string{"-", "-", "-", "-"}
+			if ok {
+				cells = []string{
+					strconv.FormatInt(r.Ops, 10),
+					strconv.FormatInt(r.NsPerOp, 10),
+					strconv.FormatInt(r.BytesPerOp, 10),
+					strconv.FormatInt(r.AllocsPerOp, 10),
+				}
+			}
+			for _, c := range cells {
+				p.printf("    <td class=\"tg-8d8j\" align=Center>%s</td>\n", c)
+			}
+		}
+		p.println(`  </tr>`)
+	}
+	p.println(`</tbody>`)
+	p.println(`</table>`)
+	return p.err
+}
+
+// csvHeader is the header row of the CSV report.
+var csvHeader = []string{
+	"backend", "workload", "keys", "value_kind", "value_size", "codec", "stored_size", "distribution", "workers", "ops", "duration_ns",
+	"ns_per_op", "ops_per_sec", "bytes_per_op", "allocs_per_op", "hit_rate",
+}
+
+// WriteCSV writes the results as CSV with a header row.
+func WriteCSV(w io.Writer, results []Result) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, r := range results {
+		record := []string{
+			r.Backend,
+			r.Workload,
+			strconv.Itoa(r.Keys),
+			string(r.ValueKind),
+			strconv.Itoa(r.ValueSize),
+			r.Codec,
+			strconv.Itoa(r.StoredSize),
+			r.Distribution,
+			strconv.Itoa(r.Workers),
+			strconv.FormatInt(r.Ops, 10),
+			strconv.FormatInt(r.Duration.Nanoseconds(), 10),
+			strconv.FormatInt(r.NsPerOp, 10),
+			strconv.FormatFloat(r.OpsPerSec, 'f', 0, 64),
+			strconv.FormatInt(r.BytesPerOp, 10),
+			strconv.FormatInt(r.AllocsPerOp, 10),
+			strconv.FormatFloat(r.HitRate, 'f', 4, 64),
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteScaling writes the throughput in operations per second of every
+// backend and workload as CSV, with one column per worker count, so that
+// throughput-vs-goroutines curves can be plotted from it.
+func WriteScaling(w io.Writer, results []Result) error {
+	type row struct {
+		backend, workload string
+		valueKind         ValueKind
+		valueSize         int
+		codec             string
+	}
+	rowKey := func(r Result) string {
+		return fmt.Sprintf("%s\x00%s\x00%s\x00%d\x00%s", r.Backend, r.Workload, r.ValueKind, r.ValueSize, r.Codec)
+	}
+	workers := distinct(results, func(r Result) string { return strconv.Itoa(r.Workers) })
+	rows := distinct(results, rowKey)
+	throughput := make(map[string]map[string]float64, len(rows))
+	first := make(map[string]row, len(rows))
+	for _, r := range results {
+		k := rowKey(r)
+		if throughput[k] == nil {
+			throughput[k] = make(map[string]float64)
+			first[k] = row{r.Backend, r.Workload, r.ValueKind, r.ValueSize, r.Codec}
+		}
+		throughput[k][strconv.Itoa(r.Workers)] = r.OpsPerSec
+	}
+
+	cw := csv.NewWriter(w)
+	header := []string{"backend", "workload", "value_kind", "value_size", "codec"}
+	for _, n := range workers {
+		header = append(header, "workers_"+n)
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, k := range rows {
+		r := first[k]
+		record := []string{r.backend, r.workload, string(r.valueKind), strconv.Itoa(r.valueSize), r.codec}
+		for _, n := range workers {
+			if ops, ok := throughput[k][n]; ok {
+				record = append(record, strconv.FormatFloat(ops, 'f', 0, 64))
+			} else {
+				record = append(record, "")
+			}
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// latencyHeader is the header row of the latency report.
+var latencyHeader = []string{
+	"backend", "workload", "value_kind", "value_size", "codec", "operation", "count",
+	"mean_ns", "p50_ns", "p90_ns", "p99_ns", "p99_9_ns", "max_ns",
+}
+
+// WriteLatencies writes the latency percentiles of every operation
+// of every result as CSV with a header row.
+func WriteLatencies(w io.Writer, results []Result) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(latencyHeader); err != nil {
+		return err
+	}
+	for _, r := range results {
+		for _, l := range r.Latencies {
+			record := []string{
+				r.Backend,
+				r.Workload,
+				string(r.ValueKind),
+				strconv.Itoa(r.ValueSize),
+				r.Codec,
+				l.Operation.String(),
+				strconv.FormatInt(l.Count, 10),
+				strconv.FormatInt(l.Mean.Nanoseconds(), 10),
+				strconv.FormatInt(l.P50.Nanoseconds(), 10),
+				strconv.FormatInt(l.P90.Nanoseconds(), 10),
+				strconv.FormatInt(l.P99.Nanoseconds(), 10),
+				strconv.FormatInt(l.P999.Nanoseconds(), 10),
+				strconv.FormatInt(l.Max.Nanoseconds(), 10),
+			}
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// histogramHeader is the header row of the histogram dump.
+var histogramHeader = []string{
+	"backend", "workload", "value_kind", "value_size", "codec", "operation", "upper_ns", "count", "cumulative",
+}
+
+// WriteHistograms writes every non-empty bucket of the latency histograms
+// of the results as CSV with a header row, for plotting. The cumulative
+// column is the fraction of the operations that took at most upper_ns.
+func WriteHistograms(w io.Writer, results []Result) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(histogramHeader); err != nil {
+		return err
+	}
+	for _, r := range results {
+		for _, l := range r.Latencies {
+			h := r.Histogram(l.Operation)
+			if h == nil {
+				continue
+			}
+			var seen int64
+			for _, b := range h.Buckets() {
+				seen += b.Count
+				record := []string{
+					r.Backend,
+					r.Workload,
+					string(r.ValueKind),
+					strconv.Itoa(r.ValueSize),
+					r.Codec,
+					l.Operation.String(),
+					strconv.FormatInt(b.Upper.Nanoseconds(), 10),
+					strconv.FormatInt(b.Count, 10),
+					strconv.FormatFloat(float64(seen)/float64(h.Count()), 'f', 6, 64),
+				}
+				if err := cw.Write(record); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON writes the results as an indented JSON array.
+func WriteJSON(w io.Writer, results []Result) error {
+	if results == nil {
+		results = []Result{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(results)
+}
+
+// distinct returns the distinct values of key in the order of their first occurrence.
+func distinct(results []Result, key func(Result) string) []string {
+	var values []string
+	seen := make(map[string]bool)
+	for _, r := range results {
+		k := key(r)
+		if !seen[k] {
+			seen[k] = true
+			values = append(values, k)
+		}
+	}
+	return values
+}
+
+// printer remembers the first write error, so that
+// the table can be written without checking every line.
+type printer struct {
+	w   io.Writer
+	err error
+}
+
+func (p *printer) printf(format string, args ...interface{}) {
+	if p.err == nil {
+		_, p.err = fmt.Fprintf(p.w, format, args...)
+	}
+}
+
+func (p *printer) println(s string) {
+	p.printf("%s\n", s)
+}
@@ -0,0 +1,141 @@
+package bench
+
+import (
+	"databases/codec"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/philippgille/gokv/encoding"
+)
+
+// NetworkStats is the value written by the benchmarks.
+// It's the same sensor statistics record the per-package benchmarks use.
+type NetworkStats struct {
+	SensorID   string           `json:"sensor_id"`
+	Updated    time.Time        `json:"updated"`
+	Interfaces []InterfaceStats `json:"interfaces"`
+}
+
+// InterfaceStats are the statistics of a single network interface.
+type InterfaceStats struct {
+	Interface string `json:"interface_name"`
+	TxBytes   int64  `json:"tx_bytes"`
+	TxPackets int64  `json:"tx_packets"`
+	TxErrors  int64  `json:"tx_errors"`
+	RxBytes   int64  `json:"rx_bytes"`
+	RxPackets int64  `json:"rx_packets"`
+	RxErrors  int64  `json:"rx_errors"`
+}
+
+// NewValue returns a NetworkStats record with as many interfaces as needed
+// for its JSON encoding to be at least size bytes long.
+// It always has at least one interface.
+func NewValue(size int) NetworkStats {
+	v := NetworkStats{
+		SensorID: "ses1",
+		Updated:  time.Date(2020, 8, 26, 0, 0, 0, 0, time.UTC),
+	}
+	iface := InterfaceStats{
+		Interface: "eth0",
+		TxBytes:   123,
+		TxPackets: 345,
+		TxErrors:  234,
+		RxBytes:   566,
+		RxPackets: 12,
+		RxErrors:  12,
+	}
+	v.Interfaces = []InterfaceStats{iface}
+	one, err := json.Marshal(v)
+	if err != nil || len(one) >= size {
+		return v
+	}
+	// Every further interface adds its encoding and a comma.
+	ifaceData, err := json.Marshal(iface)
+	if err != nil {
+		return v
+	}
+	per := len(ifaceData) + 1
+	n := 1 + (size-len(one)+per-1)/per
+	for len(v.Interfaces) < n {
+		v.Interfaces = append(v.Interfaces, iface)
+	}
+	return v
+}
+
+// ValueKind is the kind of values a benchmark writes.
+type ValueKind string
+
+// The kinds of values.
+const (
+	// StatsValue is a NetworkStats record.
+	StatsValue ValueKind = "stats"
+	// CompressibleValue is a Payload with repetitive text data.
+	CompressibleValue ValueKind = "compressible"
+	// RandomValue is a Payload with random, incompressible data.
+	RandomValue ValueKind = "random"
+)
+
+// ValueKinds are all kinds of values.
+var ValueKinds = []ValueKind{StatsValue, CompressibleValue, RandomValue}
+
+// SweepSizes are the value sizes of a value size sweep, from 64 B to 4 MB.
+var SweepSizes = []int{64, 256, 1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20}
+
+// Payload is a value with opaque data.
+type Payload struct {
+	ID   string `json:"id"`
+	Data []byte `json:"data"`
+}
+
+// compressibleText is repeated to build compressible payloads.
+const compressibleText = `{"interface_name":"eth0","tx_bytes":123,"tx_packets":345,"rx_bytes":566,"rx_packets":12}`
+
+// NewPayload returns a payload with size bytes of data of the given kind.
+// Random data is generated from the seed.
+func NewPayload(kind ValueKind, size int, seed int64) (Payload, error) {
+	if size < 0 {
+		size = 0
+	}
+	p := Payload{ID: "ses1", Data: make([]byte, size)}
+	switch kind {
+	case CompressibleValue:
+		for i := 0; i < size; i += len(compressibleText) {
+			copy(p.Data[i:], compressibleText)
+		}
+	case RandomValue:
+		rand.New(rand.NewSource(seed)).Read(p.Data)
+	default:
+		return Payload{}, fmt.Errorf("bench: no payload for value kind %q", kind)
+	}
+	return p, nil
+}
+
+// newValue returns the value of the given kind and size that a benchmark
+// writes with the codec and a function that creates values to read it into.
+// With codec.Raw, payloads are written as their data alone.
+func newValue(kind ValueKind, size int, seed int64, c encoding.Codec) (interface{}, func() interface{}, error) {
+	switch kind {
+	case StatsValue, "":
+		return NewValue(size), func() interface{} { return new(NetworkStats) }, nil
+	}
+	p, err := NewPayload(kind, size, seed)
+	if err != nil {
+		return nil, nil, err
+	}
+	if c == codec.Raw {
+		return p.Data, func() interface{} { return new([]byte) }, nil
+	}
+	return p, func() interface{} { return new(Payload) }, nil
+}
+
+// LookupValueKind returns the value kind with the given name.
+func LookupValueKind(name string) (ValueKind, error) {
+	for _, k := range ValueKinds {
+		if string(k) == name {
+			return k, nil
+		}
+	}
+	return "", fmt.Errorf("bench: unknown value kind %q", name)
+}
@@ -0,0 +1,185 @@
+package bench
+
+import (
+	"fmt"
+)
+
+// Operation is the kind of a single operation of a workload.
+type Operation int
+
+// The operations workloads consist of.
+const (
+	OpRead Operation = iota
+	OpWrite
+	OpUpdate
+	OpInsert
+	OpDelete
+	OpScan
+	OpReadModifyWrite
+	numOperations
+)
+
+var operationNames = [numOperations]string{
+	OpRead:            "read",
+	OpWrite:           "write",
+	OpUpdate:          "update",
+	OpInsert:          "insert",
+	OpDelete:          "delete",
+	OpScan:            "scan",
+	OpReadModifyWrite: "read-modify-write",
+}
+
+func (o Operation) String() string {
+	if o < 0 || o >= numOperations {
+		return fmt.Sprintf("Operation(%d)", int(o))
+	}
+	return operationNames[o]
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (o Operation) MarshalText() ([]byte, error) {
+	return []byte(o.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (o *Operation) UnmarshalText(text []byte) error {
+	for i, name := range operationNames {
+		if name == string(text) {
+			*o = Operation(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("bench: unknown operation %q", text)
+}
+
+// Workload is a named operation that is run repeatedly against a store.
+type Workload struct {
+	// Name of the workload, like "get".
+	Name string
+	// Distribution of the accessed keys, unless Options.Distribution is set.
+	Distribution Distribution
+	// load reports whether all keys are written before the run.
+	load bool
+	op   func(w *worker) (Operation, error)
+}
+
+// The basic workloads, each of which runs a single kind of operation
+// with uniformly distributed keys.
+var (
+	// Set writes values, inserting keys first and overwriting them later.
+	Set = Workload{
+		Name:         "set",
+		Distribution: Uniform,
+		op: func(w *worker) (Operation, error) {
+			return OpWrite, w.store.Set(w.key(), w.value)
+		},
+	}
+	// Get reads values of previously written keys.
+	Get = Workload{
+		Name:         "get",
+		Distribution: Uniform,
+		load:         true,
+		op: func(w *worker) (Operation, error) {
+			return OpRead, w.get(w.key())
+		},
+	}
+	// Delete deletes previously written keys. Keys that were already
+	// deleted are deleted again, just like in the per-package benchmarks.
+	Delete = Workload{
+		Name:         "delete",
+		Distribution: Uniform,
+		load:         true,
+		op: func(w *worker) (Operation, error) {
+			return OpDelete, w.store.Delete(w.key())
+		},
+	}
+)
+
+// Mix is the operation mix of a YCSB-style workload.
+// The proportions are relative to each other.
+type Mix struct {
+	// Proportion of reads of existing keys.
+	Read float64
+	// Proportion of overwrites of existing keys.
+	Update float64
+	// Proportion of writes of new keys.
+	Insert float64
+	// Proportion of scans of short key ranges.
+	Scan float64
+	// Proportion of reads that are followed by a write of the same key.
+	ReadModifyWrite float64
+	// Maximum number of records read by a scan.
+	// Scan lengths are uniformly distributed between 1 and MaxScanLength,
+	// which defaults to DefaultMaxScanLength.
+	MaxScanLength int
+}
+
+// DefaultMaxScanLength is the MaxScanLength of mixes that don't set it,
+// as in YCSB workload E.
+const DefaultMaxScanLength = 100
+
+// NewWorkload creates a workload that runs the operations of the mix
+// with the given proportions and key distribution.
+// All keys are loaded before the run.
+func NewWorkload(name string, mix Mix, d Distribution) Workload {
+	if mix.MaxScanLength <= 0 {
+		mix.MaxScanLength = DefaultMaxScanLength
+	}
+	total := mix.Read + mix.Update + mix.Insert + mix.Scan + mix.ReadModifyWrite
+	return Workload{
+		Name:         name,
+		Distribution: d,
+		load:         true,
+		op: func(w *worker) (Operation, error) {
+			p := w.rnd.Float64() * total
+			switch {
+			case p < mix.Read:
+				return OpRead, w.get(w.key())
+			case p < mix.Read+mix.Update:
+				return OpUpdate, w.store.Set(w.key(), w.value)
+			case p < mix.Read+mix.Update+mix.Insert:
+				return OpInsert, w.insert()
+			case p < mix.Read+mix.Update+mix.Insert+mix.Scan:
+				return OpScan, w.scan(1 + w.rnd.Intn(mix.MaxScanLength))
+			default:
+				k := w.key()
+				if err := w.get(k); err != nil {
+					return OpReadModifyWrite, err
+				}
+				return OpReadModifyWrite, w.store.Set(k, w.value)
+			}
+		},
+	}
+}
+
+// The YCSB core workloads. See
+// https://github.com/brianfrankcooper/YCSB/wiki/Core-Workloads.
+var (
+	// WorkloadA is update heavy: 50% reads and 50% updates.
+	WorkloadA = NewWorkload("ycsb-a", Mix{Read: 0.5, Update: 0.5}, Zipfian(DefaultTheta))
+	// WorkloadB is read mostly: 95% reads and 5% updates.
+	WorkloadB = NewWorkload("ycsb-b", Mix{Read: 0.95, Update: 0.05}, Zipfian(DefaultTheta))
+	// WorkloadC is read only.
+	WorkloadC = NewWorkload("ycsb-c", Mix{Read: 1}, Zipfian(DefaultTheta))
+	// WorkloadD reads the latest records: 95% reads and 5% inserts.
+	WorkloadD = NewWorkload("ycsb-d", Mix{Read: 0.95, Insert: 0.05}, Latest(DefaultTheta))
+	// WorkloadE scans short ranges: 95% scans and 5% inserts.
+	WorkloadE = NewWorkload("ycsb-e", Mix{Scan: 0.95, Insert: 0.05, MaxScanLength: DefaultMaxScanLength}, Zipfian(DefaultTheta))
+	// WorkloadF is read-modify-write: 50% reads and 50% read-modify-writes.
+	WorkloadF = NewWorkload("ycsb-f", Mix{Read: 0.5, ReadModifyWrite: 0.5}, Zipfian(DefaultTheta))
+)
+
+// Workloads returns all known workloads.
+func Workloads() []Workload {
+	return []Workload{Set, Get, Delete, WorkloadA, WorkloadB, WorkloadC, WorkloadD, WorkloadE, WorkloadF}
+}
+
+// LookupWorkload returns the workload with the given name.
+func LookupWorkload(name string) (Workload, error) {
+	for _, w := range Workloads() {
+		if w.Name == name {
+			return w, nil
+		}
+	}
+	return Workload{}, fmt.Errorf("bench: unknown workload %q", name)
+}
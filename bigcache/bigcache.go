@@ -1,21 +1,50 @@
 package bigcache
 
 import (
+	"context"
+	"databases/kv"
+	"databases/stores"
+	"math"
+	"sync"
 	"time"
 
 	"github.com/allegro/bigcache/v2"
+	"github.com/philippgille/gokv"
 	"github.com/philippgille/gokv/encoding"
 	"github.com/philippgille/gokv/util"
 )
 
 // Store is a gokv.Store implementation for BigCache.
+//
+// Its atomic operations CompareAndSwap, SetIfAbsent and Increment are
+// best-effort: they are only atomic with respect to each other, not to
+// Set and Delete, and BigCache may evict a value at any time.
 type Store struct {
 	Db    *bigcache.BigCache
 	Codec encoding.Codec
+	// mu serializes the atomic operations.
+	mu *sync.Mutex
 }
 
 // Set stores the given value for the given key.
 func (s Store) Set(k string, v interface{}) error {
+	return s.SetContext(context.Background(), k, v)
+}
+
+// SetContext stores the given value for the given key
+// unless the context is done before the value is written.
+func (s Store) SetContext(ctx context.Context, k string, v interface{}) error {
+	return s.set(ctx, k, v, time.Time{})
+}
+
+// SetWithTTL stores the given value for the given key.
+// The value expires after ttl; expired values are
+// never returned and are evicted like any other entry.
+func (s Store) SetWithTTL(k string, v interface{}, ttl time.Duration) error {
+	return s.set(context.Background(), k, v, kv.Deadline(ttl))
+}
+
+func (s Store) set(ctx context.Context, k string, v interface{}, deadline time.Time) error {
 	if err := util.CheckKeyAndValue(k, v); err != nil {
 		return err
 	}
@@ -23,14 +52,26 @@ func (s Store) Set(k string, v interface{}) error {
 	if err != nil {
 		return err
 	}
-	return s.Db.Set(k, data)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.Db.Set(k, kv.WithExpiry(data, deadline))
 }
 
 // Get retrieves the stored value for the given key.
 func (s Store) Get(k string, v interface{}) (found bool, err error) {
+	return s.GetContext(context.Background(), k, v)
+}
+
+// GetContext retrieves the stored value for the given key
+// unless the context is done before the value is read.
+func (s Store) GetContext(ctx context.Context, k string, v interface{}) (found bool, err error) {
 	if err := util.CheckKeyAndValue(k, v); err != nil {
 		return false, err
 	}
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
 
 	data, err := s.Db.Get(k)
 	if err != nil {
@@ -39,15 +80,31 @@ func (s Store) Get(k string, v interface{}) (found bool, err error) {
 		}
 		return false, err
 	}
+	deadline, data, err := kv.SplitExpiry(data)
+	if err != nil {
+		return false, err
+	}
+	if kv.Expired(deadline, time.Now()) {
+		return false, nil
+	}
 
 	return true, s.Codec.Unmarshal(data, v)
 }
 
 // Delete deletes the stored value for the given key.
 func (s Store) Delete(k string) error {
+	return s.DeleteContext(context.Background(), k)
+}
+
+// DeleteContext deletes the stored value for the given key
+// unless the context is done before the value is deleted.
+func (s Store) DeleteContext(ctx context.Context, k string) error {
 	if err := util.CheckKey(k); err != nil {
 		return err
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
 	err := s.Db.Delete(k)
 	if err != nil {
@@ -59,6 +116,70 @@ func (s Store) Delete(k string) error {
 	return nil
 }
 
+// SetMany stores the given values for their keys one by one,
+// because BigCache has no batches.
+func (s Store) SetMany(values map[string]interface{}) error {
+	return kv.SetEach(s, values)
+}
+
+// GetMany retrieves the stored values for the given keys one by one.
+func (s Store) GetMany(keys []string, vs []interface{}) (found []bool, err error) {
+	return kv.GetEach(s, keys, vs)
+}
+
+// DeleteMany deletes the stored values for the given keys one by one.
+func (s Store) DeleteMany(keys []string) error {
+	return kv.DeleteEach(s, keys)
+}
+
+// CompareAndSwap stores new for k if the stored value equals old
+// and reports whether it did.
+func (s Store) CompareAndSwap(k string, old, new interface{}) (swapped bool, err error) {
+	return kv.CompareAndSwap(s.modify, s.Codec, k, old, new)
+}
+
+// SetIfAbsent stores v for k if no value is stored for it
+// and reports whether it did.
+func (s Store) SetIfAbsent(k string, v interface{}) (set bool, err error) {
+	return kv.SetIfAbsent(s.modify, s.Codec, k, v)
+}
+
+// Increment adds delta to the int64 stored for k, or to 0 if none is,
+// and returns the result.
+func (s Store) Increment(k string, delta int64) (int64, error) {
+	return kv.Increment(s.modify, s.Codec, k, delta)
+}
+
+// modify implements kv.ModifyFunc while holding the lock
+// of the atomic operations.
+func (s Store) modify(k string, fn func(data []byte, found bool) ([]byte, bool, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := s.Db.Get(k)
+	found := err == nil
+	if err != nil && err != bigcache.ErrEntryNotFound {
+		return err
+	}
+	if found {
+		deadline, value, err := kv.SplitExpiry(data)
+		if err != nil {
+			return err
+		}
+		data, found = value, !kv.Expired(deadline, time.Now())
+	}
+	newData, write, err := fn(data, found)
+	if err != nil || !write {
+		return err
+	}
+	return s.Db.Set(k, kv.WithExpiry(newData, time.Time{}))
+}
+
+// Scan returns kv.ErrNotSupported, because BigCache doesn't keep its keys
+// in any order and can't enumerate them.
+func (s Store) Scan(prefix, start, end string) (kv.Iterator, error) {
+	return nil, kv.ErrNotSupported
+}
+
 // Close closes the store.
 func (s Store) Close() error {
 	return s.Db.Close()
@@ -71,6 +192,7 @@ type Options struct {
 	HardMaxCacheSize int
 	// Time after which an entry can be evicted.
 	// 0 means no eviction.
+	// Use SetWithTTL to let single entries expire earlier.
 	Eviction time.Duration
 	// Encoding format.
 	Codec encoding.Codec
@@ -88,6 +210,13 @@ func NewStore(options *Options) (Store, error) {
 	}
 
 	config := bigcache.DefaultConfig(options.Eviction)
+	config.HardMaxCacheSize = options.HardMaxCacheSize
+	if options.Eviction == 0 {
+		// A zero life window would make BigCache evict every entry
+		// older than a second instead of none.
+		config.LifeWindow = math.MaxInt64
+		config.CleanWindow = 0
+	}
 	cache, err := bigcache.NewBigCache(config)
 	if err != nil {
 		return Store{}, err
@@ -95,7 +224,19 @@ func NewStore(options *Options) (Store, error) {
 	result := Store{
 		Db:    cache,
 		Codec: options.Codec,
+		mu:    &sync.Mutex{},
 	}
 
 	return result, nil
 }
+
+// init registers the store as "bigcache" with package stores.
+func init() {
+	stores.Register("bigcache", func(cfg map[string]interface{}) (gokv.Store, error) {
+		options := DefaultOptions
+		if err := stores.DecodeOptions(cfg, &options); err != nil {
+			return nil, err
+		}
+		return NewStore(&options)
+	})
+}
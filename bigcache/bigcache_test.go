@@ -1,10 +1,14 @@
 package bigcache
 
 import (
+	"databases/stores"
+	"databases/storetest"
 	"fmt"
 	"math/rand"
 	"testing"
 	"time"
+
+	"github.com/philippgille/gokv"
 )
 
 type NetworkStats struct {
@@ -38,6 +42,37 @@ var NS = NetworkStats{
 	},
 }
 
+func TestStore(t *testing.T) {
+	storetest.TestStore(t, func(t *testing.T) gokv.Store {
+		s, err := NewStore(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+// TestOpen checks that the options of the config take effect.
+func TestOpen(t *testing.T) {
+	value := make([]byte, 1<<20)
+	for _, size := range []int{0, 1} {
+		s, err := stores.Open("bigcache", map[string]interface{}{"hardmaxcachesize": size})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		// A limit of 1 MiB leaves each of the 1024 shards
+		// too little room to grow for the value.
+		err = s.Set("ses1", value)
+		if size == 0 && err != nil {
+			t.Errorf("Set without a limit failed: %v", err)
+		}
+		if size != 0 && err == nil {
+			t.Errorf("HardMaxCacheSize %d didn't limit the cache", size)
+		}
+	}
+}
+
 func BenchmarkSet(b *testing.B) {
 	s, err := NewStore(nil)
 	defer s.Close()
@@ -0,0 +1,224 @@
+// Command dbcompare runs the store comparison and writes the results
+// as Markdown (in the HTML table layout of the README), CSV or JSON.
+//
+// Usage:
+//
+//	dbcompare -backends memory,badgerdb -workloads set,get -value-sizes 256,4096 -format markdown
+//	dbcompare -backends memory,redis -redis-addr localhost:6379 -workloads set,get
+//	dbcompare -backends memory,pudge -codecs all -value-kinds stats,random -format csv
+//	dbcompare -backends bigcache,ristretto -codecs json,json+flate,json+gzip -value-kinds stats,compressible
+//
+// Value kinds that a codec can't encode, like stats values with the raw
+// codec, are skipped.
+package main
+
+import (
+	"databases/bench"
+	"databases/bench/backends"
+	"databases/codec"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/philippgille/gokv/encoding"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, "dbcompare:", err)
+		os.Exit(1)
+	}
+}
+
+// run runs the command with the arguments, writing the report to stdout
+// and the usage, progress and skipped cases to stderr.
+func run(args []string, stdout, stderr io.Writer) error {
+	var names []string
+	for _, b := range backends.All() {
+		names = append(names, b.Name)
+	}
+	var workloadNames []string
+	for _, w := range bench.Workloads() {
+		workloadNames = append(workloadNames, w.Name)
+	}
+
+	fs := flag.NewFlagSet("dbcompare", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	backendList := fs.String("backends", strings.Join(names, ","), "comma-separated list of backends; redis needs -redis-addr and mongodb -mongodb-uri")
+	redisAddr := fs.String("redis-addr", "", "address of the Redis server that the redis backend uses")
+	mongoURI := fs.String("mongodb-uri", "", "connection string of the MongoDB server that the mongodb backend uses")
+	workloadList := fs.String("workloads", strings.Join(workloadNames, ","), "comma-separated list of workloads")
+	valueKinds := fs.String("value-kinds", string(bench.DefaultOptions.ValueKind), "comma-separated list of value kinds: stats, compressible, random")
+	codecList := fs.String("codecs", "json", `comma-separated list of codecs, or "all": `+strings.Join(codec.Names(), ", ")+`; append +flate or +gzip to compress, like json+gzip`)
+	valueSizes := fs.String("value-sizes", strconv.Itoa(bench.DefaultOptions.ValueSize), `comma-separated list of value sizes in bytes, or "sweep" for 64 B to 4 MB`)
+	maxDataSize := fs.Int64("max-data-size", bench.DefaultOptions.MaxDataSize, "maximum total size of all values in bytes; fewer keys are used for large values")
+	workerCounts := fs.String("workers", "1", "comma-separated list of numbers of concurrent workers")
+	keys := fs.Int("keys", bench.DefaultOptions.Keys, "number of distinct keys")
+	duration := fs.Duration("duration", bench.DefaultOptions.Duration, "measurement duration per workload")
+	warmup := fs.Duration("warmup", bench.DefaultOptions.Warmup, "warm-up duration per workload")
+	seed := fs.Int64("seed", bench.DefaultOptions.Seed, "seed of the random key selection")
+	distribution := fs.String("distribution", "", "key distribution overriding the workloads' own: uniform, zipfian, hotspot, latest, sequential")
+	theta := fs.Float64("theta", bench.DefaultTheta, "skew of the zipfian and latest distributions")
+	format := fs.String("format", "markdown", "output format: "+strings.Join(bench.Formats, ", "))
+	out := fs.String("o", "", "output file (default stdout)")
+	histograms := fs.String("histograms", "", "file to write the full latency histograms to as CSV")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if !contains(bench.Formats, *format) {
+		return fmt.Errorf("unknown -format %q, want one of %s", *format, strings.Join(bench.Formats, ", "))
+	}
+	if *duration <= 0 {
+		return fmt.Errorf("invalid -duration %v", *duration)
+	}
+	backends.RedisAddress = *redisAddr
+	backends.MongoDBURI = *mongoURI
+	selected, err := backends.Lookup(split(*backendList)...)
+	if err != nil {
+		return err
+	}
+	var workloads []bench.Workload
+	for _, name := range split(*workloadList) {
+		w, err := bench.LookupWorkload(name)
+		if err != nil {
+			return err
+		}
+		workloads = append(workloads, w)
+	}
+	var dist bench.Distribution
+	if *distribution != "" {
+		if dist, err = bench.LookupDistribution(*distribution, *theta); err != nil {
+			return err
+		}
+	}
+	var kinds []bench.ValueKind
+	for _, name := range split(*valueKinds) {
+		k, err := bench.LookupValueKind(name)
+		if err != nil {
+			return err
+		}
+		kinds = append(kinds, k)
+	}
+	codecNames := split(*codecList)
+	if *codecList == "all" {
+		codecNames = codec.Names()
+	}
+	var codecs []encoding.Codec
+	for _, name := range codecNames {
+		c, err := codec.Lookup(name)
+		if err != nil {
+			return err
+		}
+		codecs = append(codecs, c)
+	}
+	sizes := bench.SweepSizes
+	if *valueSizes != "sweep" {
+		if sizes, err = parseInts(*valueSizes); err != nil {
+			return fmt.Errorf("invalid -value-sizes: %v", err)
+		}
+	}
+	counts, err := parseInts(*workerCounts)
+	if err != nil {
+		return fmt.Errorf("invalid -workers: %v", err)
+	}
+
+	var results []bench.Result
+	for _, kind := range kinds {
+		for i, c := range codecs {
+			if err := bench.CheckCodec(c, kind); err != nil {
+				fmt.Fprintf(stderr, "skipping %s values with the %s codec\n", kind, codecNames[i])
+				continue
+			}
+			for _, size := range sizes {
+				for _, n := range counts {
+					ops := bench.DefaultOptions
+					ops.Keys = *keys
+					ops.ValueKind = kind
+					ops.ValueSize = size
+					ops.MaxDataSize = *maxDataSize
+					ops.Duration = *duration
+					ops.Warmup = *warmup
+					ops.Seed = *seed
+					ops.Distribution = dist
+					ops.Workers = n
+					ops.Codec = c
+					for _, b := range selected {
+						for _, w := range workloads {
+							fmt.Fprintf(stderr, "running %s/%s with %d B %s values as %s and %d workers\n", b.Name, w.Name, size, kind, codecNames[i], n)
+							r, err := bench.RunOne(b, w, &ops)
+							if err != nil {
+								return err
+							}
+							results = append(results, r)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	if *histograms != "" {
+		if err := writeFile(*histograms, func(w io.Writer) error {
+			return bench.WriteHistograms(w, results)
+		}); err != nil {
+			return err
+		}
+	}
+	if *out == "" {
+		return bench.WriteReport(stdout, *format, results)
+	}
+	return writeFile(*out, func(w io.Writer) error {
+		return bench.WriteReport(w, *format, results)
+	})
+}
+
+// contains reports whether list contains s.
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// writeFile creates the file and writes it with fn.
+func writeFile(name string, fn func(io.Writer) error) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := fn(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// split splits a comma-separated list, ignoring empty elements.
+func split(list string) []string {
+	var result []string
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// parseInts parses a comma-separated list of integers.
+func parseInts(list string) ([]int, error) {
+	var result []int
+	for _, s := range split(list) {
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, i)
+	}
+	return result, nil
+}
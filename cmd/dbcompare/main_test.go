@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	args := []string{"-backends", "memory", "-workloads", "set", "-keys", "10",
+		"-duration", "10ms", "-warmup", "0", "-format", "csv"}
+	if err := run(args, &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout.String(), "memory") {
+		t.Errorf("the report doesn't have the memory backend:\n%s", stdout.String())
+	}
+	if !strings.Contains(stderr.String(), "running memory/set") {
+		t.Errorf("got progress %q", stderr.String())
+	}
+
+	stdout.Reset()
+	stderr.Reset()
+	args = []string{"-backends", "memory", "-workloads", "set", "-value-kinds", "stats",
+		"-codecs", "raw", "-duration", "10ms", "-format", "csv"}
+	if err := run(args, &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stderr.String(), "skipping stats values with the raw codec") {
+		t.Errorf("got %q, want the skipped codec", stderr.String())
+	}
+}
+
+// TestFlags checks that invalid flags fail before any benchmark runs.
+func TestFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{"-format", "xml"},
+		{"-duration", "0"},
+		{"-duration", "-1s"},
+		{"-backends", "unknown"},
+		{"-workers", "x"},
+		{"-nosuchflag"},
+	} {
+		var stdout, stderr bytes.Buffer
+		if err := run(append([]string{"-backends", "memory", "-workloads", "set"}, args...), &stdout, &stderr); err == nil {
+			t.Errorf("%v succeeded", args)
+		}
+		if strings.Contains(stderr.String(), "running") || stdout.Len() > 0 {
+			t.Errorf("%v ran the benchmarks", args)
+		}
+	}
+}
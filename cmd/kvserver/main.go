@@ -0,0 +1,222 @@
+// Command kvserver serves one of the stores over HTTP with the
+// JSON API of package rest, and over the Redis protocol of package resp.
+//
+// The backend and its options are read from a JSON config file, or a
+// YAML file with the same fields if its name ends in .yaml or .yml:
+//
+//	{
+//		"addr": ":8080",
+//		"resp_addr": ":6379",
+//		"backend": "badgerdb",
+//		"codec": "json",
+//		"options": {"Dir": "data"}
+//	}
+//
+// "backend" is the name of a store of package stores, and "options"
+// are the fields of its Options struct, which default to its
+// DefaultOptions, as described by stores.DecodeOptions. "codec" is
+// the name of a codec of package codec: json, gob, msgpack, raw or
+// binary, optionally compressed like "json+gzip".
+// An empty "addr" or "resp_addr" disables that protocol.
+//
+// Values are encrypted with AES-GCM before the backend stores them if
+// the config has an "encryption" section:
+//
+//	"encryption": {
+//		"key_id": 2,
+//		"keys": {"1": "env:KVSERVER_KEY_1", "2": "env:KVSERVER_KEY_2"}
+//	}
+//
+// Keys are base64 encoded, or read from the environment variable
+// after "env:". Values encrypted with any of the keys can be read;
+// new values are encrypted with the key of "key_id".
+//
+// Usage:
+//
+//	kvserver -config kvserver.json
+//	redis-cli -p 6379 set a 1
+package main
+
+import (
+	"context"
+	"databases/codec"
+	"databases/resp"
+	"databases/rest"
+	"databases/stores"
+	_ "databases/stores/all"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/philippgille/gokv"
+	"github.com/philippgille/gokv/encoding"
+)
+
+// Config is the content of the config file.
+type Config struct {
+	// Address to serve HTTP on.
+	Addr string `json:"addr" yaml:"addr"`
+	// Address to serve the Redis protocol on.
+	RESPAddr string `json:"resp_addr" yaml:"resp_addr"`
+	// Name of the backend, like "badgerdb".
+	Backend string `json:"backend" yaml:"backend"`
+	// Name of the codec.
+	Codec string `json:"codec" yaml:"codec"`
+	// Fields of the backend's Options, see stores.DecodeOptions.
+	Options map[string]interface{} `json:"options" yaml:"options"`
+	// Encryption of the values, if any.
+	Encryption *Encryption `json:"encryption" yaml:"encryption"`
+}
+
+// Encryption is the encryption section of the config file.
+type Encryption struct {
+	// ID of the key that values are encrypted with.
+	KeyID uint32 `json:"key_id" yaml:"key_id"`
+	// Keys by their IDs, base64 encoded or as "env:" and the name
+	// of the environment variable that holds them.
+	Keys map[string]string `json:"keys" yaml:"keys"`
+}
+
+// options returns the codec options of the encryption.
+func (e Encryption) options() (*codec.EncryptOptions, error) {
+	options := &codec.EncryptOptions{
+		Keys:  make(map[uint32][]byte, len(e.Keys)),
+		KeyID: e.KeyID,
+	}
+	for id, key := range e.Keys {
+		n, err := strconv.ParseUint(id, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key ID %q", id)
+		}
+		if strings.HasPrefix(key, "env:") {
+			key = os.Getenv(strings.TrimPrefix(key, "env:"))
+		}
+		data, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %v", id, err)
+		}
+		options.Keys[uint32(n)] = data
+	}
+	return options, nil
+}
+
+// DefaultConfig is a Config object with default values.
+var DefaultConfig = Config{
+	Addr:    ":8080",
+	Backend: "memory",
+	Codec:   "json",
+}
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "kvserver:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	fs := flag.NewFlagSet("kvserver", flag.ContinueOnError)
+	configFile := fs.String("config", "", "JSON or YAML config file (default: in-memory store on :8080)")
+	addr := fs.String("addr", "", "HTTP address, overriding the config file")
+	respAddr := fs.String("resp-addr", "", "Redis protocol address, overriding the config file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg := DefaultConfig
+	if *configFile != "" {
+		if err := stores.LoadFile(*configFile, &cfg); err != nil {
+			return err
+		}
+	}
+	// Flags that are set override the config file, even when empty.
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "addr":
+			cfg.Addr = *addr
+		case "resp-addr":
+			cfg.RESPAddr = *respAddr
+		}
+	})
+	if cfg.Addr == "" && cfg.RESPAddr == "" {
+		return fmt.Errorf("no address to serve on")
+	}
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	errs := make(chan error, 2)
+	srv := &http.Server{Addr: cfg.Addr, Handler: rest.NewHandler(store)}
+	if cfg.Addr != "" {
+		go func() {
+			log.Printf("serving %s over HTTP on %s", cfg.Backend, cfg.Addr)
+			errs <- srv.ListenAndServe()
+		}()
+	}
+	respSrv := resp.NewServer(store)
+	if cfg.RESPAddr != "" {
+		go func() {
+			log.Printf("serving %s over RESP on %s", cfg.Backend, cfg.RESPAddr)
+			errs <- respSrv.ListenAndServe(cfg.RESPAddr)
+		}()
+	}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err := <-errs:
+		return err
+	case <-stop:
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	respSrv.Close()
+	return srv.Shutdown(ctx)
+}
+
+// openStore opens the backend of cfg with its options.
+func openStore(cfg Config) (gokv.Store, error) {
+	c, err := lookupCodec(cfg.Codec, cfg.Encryption)
+	if err != nil {
+		return nil, err
+	}
+	options := map[string]interface{}{"codec": c}
+	for name, value := range cfg.Options {
+		if strings.EqualFold(name, "codec") {
+			return nil, fmt.Errorf(`set the codec with "codec" instead of in the options`)
+		}
+		options[name] = value
+	}
+	return stores.Open(cfg.Backend, options)
+}
+
+// lookupCodec returns the codec registered with the name, or JSON,
+// encrypted if the config has an encryption section.
+func lookupCodec(name string, encryption *Encryption) (encoding.Codec, error) {
+	if name == "" {
+		name = "json"
+	}
+	c, err := codec.Lookup(name)
+	if err != nil || encryption == nil {
+		return c, err
+	}
+	options, err := encryption.options()
+	if err != nil {
+		return nil, fmt.Errorf("encryption: %v", err)
+	}
+	enc, err := codec.NewEncrypted(c, options)
+	if err != nil {
+		return nil, fmt.Errorf("encryption: %v", err)
+	}
+	return enc, nil
+}
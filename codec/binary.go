@@ -0,0 +1,402 @@
+package codec
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+)
+
+// BinaryCodec encodes values in a compact binary format without field
+// names, similar to protocol buffers without field numbers:
+//
+//   - integers are (zigzag) varints, floats are little-endian IEEE 754
+//   - strings, byte slices and types that implement both
+//     encoding.BinaryMarshaler and encoding.BinaryUnmarshaler,
+//     like time.Time, are length-prefixed bytes
+//   - slices and maps are a varint length followed by their elements;
+//     map entries are sorted by their encoded keys, and empty slices
+//     and maps are decoded as nil
+//   - structs are their exported fields in order
+//   - pointers are a presence byte followed by the value, except for
+//     the value passed to Marshal and Unmarshal, which like with JSON
+//     is encoded the same as the value it points to
+//
+// Because the encoding has no field names, values must be decoded into
+// the type they were encoded from. Interfaces, channels, functions and
+// complex numbers can't be encoded.
+type BinaryCodec struct{}
+
+var (
+	binaryMarshalerType   = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+
+	errTruncated = errors.New("codec: binary data is truncated")
+)
+
+// Marshal encodes v.
+func (BinaryCodec) Marshal(v interface{}) ([]byte, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() || rv.Kind() == reflect.Ptr {
+		return nil, errors.New("codec: binary can't encode nil")
+	}
+	var e binaryEncoder
+	if err := e.value(rv); err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+// Unmarshal decodes data into v, which must be a non-nil pointer.
+func (BinaryCodec) Unmarshal(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("codec: binary can't decode into %T", v)
+	}
+	rv = rv.Elem()
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		rv = rv.Elem()
+	}
+	d := binaryDecoder{data: data}
+	if err := d.value(rv); err != nil {
+		return err
+	}
+	if len(d.data) > 0 {
+		return fmt.Errorf("codec: %d bytes of binary data left after decoding %T", len(d.data), v)
+	}
+	return nil
+}
+
+// isBinaryMarshaler reports whether values of t are encoded with their
+// MarshalBinary and UnmarshalBinary methods.
+func isBinaryMarshaler(t reflect.Type) bool {
+	pt := reflect.PtrTo(t)
+	return pt.Implements(binaryMarshalerType) && pt.Implements(binaryUnmarshalerType)
+}
+
+type binaryEncoder struct {
+	buf     []byte
+	scratch [binary.MaxVarintLen64]byte
+}
+
+func (e *binaryEncoder) uvarint(x uint64) {
+	n := binary.PutUvarint(e.scratch[:], x)
+	e.buf = append(e.buf, e.scratch[:n]...)
+}
+
+func (e *binaryEncoder) varint(x int64) {
+	n := binary.PutVarint(e.scratch[:], x)
+	e.buf = append(e.buf, e.scratch[:n]...)
+}
+
+func (e *binaryEncoder) bytes(b []byte) {
+	e.uvarint(uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *binaryEncoder) value(v reflect.Value) error {
+	t := v.Type()
+	if t.Kind() != reflect.Ptr && isBinaryMarshaler(t) {
+		if !v.CanAddr() {
+			addressable := reflect.New(t).Elem()
+			addressable.Set(v)
+			v = addressable
+		}
+		data, err := v.Addr().Interface().(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			return err
+		}
+		e.bytes(data)
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			e.buf = append(e.buf, 1)
+		} else {
+			e.buf = append(e.buf, 0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.varint(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.uvarint(v.Uint())
+	case reflect.Float32:
+		e.buf = append(e.buf, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(e.buf[len(e.buf)-4:], math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		e.buf = append(e.buf, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint64(e.buf[len(e.buf)-8:], math.Float64bits(v.Float()))
+	case reflect.String:
+		e.uvarint(uint64(v.Len()))
+		e.buf = append(e.buf, v.String()...)
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			e.bytes(v.Bytes())
+			return nil
+		}
+		e.uvarint(uint64(v.Len()))
+		return e.elements(v)
+	case reflect.Array:
+		return e.elements(v)
+	case reflect.Map:
+		return e.mapValue(v)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).PkgPath != "" {
+				continue
+			}
+			if err := e.value(v.Field(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Ptr:
+		if v.IsNil() {
+			e.buf = append(e.buf, 0)
+			return nil
+		}
+		e.buf = append(e.buf, 1)
+		return e.value(v.Elem())
+	default:
+		return fmt.Errorf("codec: binary can't encode %s", t)
+	}
+	return nil
+}
+
+func (e *binaryEncoder) elements(v reflect.Value) error {
+	for i := 0; i < v.Len(); i++ {
+		if err := e.value(v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mapValue encodes the entries of a map sorted by their encoded keys,
+// so that equal maps have equal encodings.
+func (e *binaryEncoder) mapValue(v reflect.Value) error {
+	type entry struct {
+		key   []byte
+		value reflect.Value
+	}
+	entries := make([]entry, 0, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		var ke binaryEncoder
+		if err := ke.value(iter.Key()); err != nil {
+			return err
+		}
+		entries = append(entries, entry{key: ke.buf, value: iter.Value()})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].key, entries[j].key) < 0
+	})
+
+	e.uvarint(uint64(len(entries)))
+	for _, en := range entries {
+		e.buf = append(e.buf, en.key...)
+		if err := e.value(en.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type binaryDecoder struct {
+	data []byte
+}
+
+func (d *binaryDecoder) uvarint() (uint64, error) {
+	x, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	d.data = d.data[n:]
+	return x, nil
+}
+
+func (d *binaryDecoder) varint() (int64, error) {
+	x, n := binary.Varint(d.data)
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	d.data = d.data[n:]
+	return x, nil
+}
+
+// length reads a length prefix. As every element takes at least a byte,
+// lengths beyond the remaining data are rejected before allocating.
+func (d *binaryDecoder) length() (int, error) {
+	n, err := d.uvarint()
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(len(d.data)) {
+		return 0, errTruncated
+	}
+	return int(n), nil
+}
+
+func (d *binaryDecoder) next(n int) ([]byte, error) {
+	if n > len(d.data) {
+		return nil, errTruncated
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b, nil
+}
+
+func (d *binaryDecoder) bytes() ([]byte, error) {
+	n, err := d.length()
+	if err != nil {
+		return nil, err
+	}
+	return d.next(n)
+}
+
+func (d *binaryDecoder) value(v reflect.Value) error {
+	t := v.Type()
+	if t.Kind() != reflect.Ptr && isBinaryMarshaler(t) {
+		data, err := d.bytes()
+		if err != nil {
+			return err
+		}
+		return v.Addr().Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary(append([]byte(nil), data...))
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		b, err := d.next(1)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b[0] != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		x, err := d.varint()
+		if err != nil {
+			return err
+		}
+		if v.OverflowInt(x) {
+			return fmt.Errorf("codec: %d overflows %s", x, t)
+		}
+		v.SetInt(x)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		x, err := d.uvarint()
+		if err != nil {
+			return err
+		}
+		if v.OverflowUint(x) {
+			return fmt.Errorf("codec: %d overflows %s", x, t)
+		}
+		v.SetUint(x)
+	case reflect.Float32:
+		b, err := d.next(4)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(b))))
+	case reflect.Float64:
+		b, err := d.next(8)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(math.Float64frombits(binary.LittleEndian.Uint64(b)))
+	case reflect.String:
+		b, err := d.bytes()
+		if err != nil {
+			return err
+		}
+		v.SetString(string(b))
+	case reflect.Slice:
+		n, err := d.length()
+		if err != nil {
+			return err
+		}
+		if t.Elem().Kind() == reflect.Uint8 {
+			b, err := d.next(n)
+			if err != nil {
+				return err
+			}
+			v.SetBytes(append([]byte(nil), b...))
+			return nil
+		}
+		if n == 0 {
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+		s := reflect.MakeSlice(t, n, n)
+		if err := d.elements(s); err != nil {
+			return err
+		}
+		v.Set(s)
+	case reflect.Array:
+		return d.elements(v)
+	case reflect.Map:
+		n, err := d.length()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+		m := reflect.MakeMapWithSize(t, n)
+		for i := 0; i < n; i++ {
+			key := reflect.New(t.Key()).Elem()
+			if err := d.value(key); err != nil {
+				return err
+			}
+			elem := reflect.New(t.Elem()).Elem()
+			if err := d.value(elem); err != nil {
+				return err
+			}
+			m.SetMapIndex(key, elem)
+		}
+		v.Set(m)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).PkgPath != "" {
+				continue
+			}
+			if err := d.value(v.Field(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Ptr:
+		b, err := d.next(1)
+		if err != nil {
+			return err
+		}
+		if b[0] == 0 {
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+		p := reflect.New(t.Elem())
+		if err := d.value(p.Elem()); err != nil {
+			return err
+		}
+		v.Set(p)
+	default:
+		return fmt.Errorf("codec: binary can't decode into %s", t)
+	}
+	return nil
+}
+
+func (d *binaryDecoder) elements(v reflect.Value) error {
+	for i := 0; i < v.Len(); i++ {
+		if err := d.value(v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
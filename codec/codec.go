@@ -0,0 +1,180 @@
+// Package codec is a registry of the encoding.Codec implementations that
+// the Options of the stores can be configured with by name, like from a
+// config file. Besides gokv's JSON and gob codecs it provides MessagePack,
+// a raw []byte passthrough and a compact binary codec, and NewCompressed
+// wraps any of them to compress what they encode.
+package codec
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	gokvencoding "github.com/philippgille/gokv/encoding"
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+// The codecs of the registry.
+var (
+	// JSON encodes values as JSON.
+	JSON gokvencoding.Codec = gokvencoding.JSON
+	// Gob encodes values with encoding/gob.
+	Gob gokvencoding.Codec = gokvencoding.Gob
+	// MsgPack encodes values as MessagePack.
+	MsgPack gokvencoding.Codec = msgpackCodec{}
+	// Raw stores []byte and string values, and those of types based on
+	// them like json.RawMessage, as they are, and values that implement
+	// encoding.BinaryMarshaler as the result of MarshalBinary.
+	// It can't encode other values.
+	Raw gokvencoding.Codec = rawCodec{}
+	// Binary encodes values in a compact binary format, see BinaryCodec.
+	Binary gokvencoding.Codec = BinaryCodec{}
+)
+
+var (
+	mu       sync.RWMutex
+	registry = map[string]gokvencoding.Codec{
+		"json":    JSON,
+		"gob":     Gob,
+		"msgpack": MsgPack,
+		"raw":     Raw,
+		"binary":  Binary,
+	}
+)
+
+// Register makes a codec available by the given name.
+// It panics if the name is empty, contains a "+" or is already registered,
+// or if c is nil.
+func Register(name string, c gokvencoding.Codec) {
+	mu.Lock()
+	defer mu.Unlock()
+	if name == "" || strings.Contains(name, "+") || c == nil {
+		panic("codec: Register with an invalid name or a nil codec")
+	}
+	if _, dup := registry[name]; dup {
+		panic("codec: Register called twice for codec " + name)
+	}
+	registry[name] = c
+}
+
+// Lookup returns the codec registered with the given name.
+// A name like "json+gzip" is the registered codec before the "+"
+// compressed with the algorithm after it and DefaultCompressOptions.
+func Lookup(name string) (gokvencoding.Codec, error) {
+	mu.RLock()
+	c, ok := registry[name]
+	mu.RUnlock()
+	if !ok {
+		return lookupCompressed(name)
+	}
+	return c, nil
+}
+
+// Names returns the names of the registered codecs in sorted order.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Name returns the name c is registered with, or "" if it isn't.
+// Compressing codecs of registered codecs are named like "json+gzip".
+func Name(c gokvencoding.Codec) string {
+	if cc, ok := c.(compressed); ok {
+		if name := Name(cc.codec); name != "" {
+			return name + "+" + cc.algorithm
+		}
+		return ""
+	}
+	if c == nil || !reflect.TypeOf(c).Comparable() {
+		return ""
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	for name, r := range registry {
+		if reflect.TypeOf(r) == reflect.TypeOf(c) && r == c {
+			return name
+		}
+	}
+	return ""
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case []byte:
+		// Copy, so that the caller can reuse its slice.
+		return clone(v), nil
+	case *[]byte:
+		return clone(*v), nil
+	case string:
+		return []byte(v), nil
+	case *string:
+		return []byte(*v), nil
+	case encoding.BinaryMarshaler:
+		data, err := v.MarshalBinary()
+		if err == nil && data == nil {
+			data = []byte{}
+		}
+		return data, err
+	}
+	// Named types like json.RawMessage.
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	switch {
+	case rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8:
+		return clone(rv.Bytes()), nil
+	case rv.Kind() == reflect.String:
+		return []byte(rv.String()), nil
+	}
+	return nil, fmt.Errorf("codec: raw can't encode %T", v)
+}
+
+// clone copies b. The copy of an empty slice isn't nil, as stores
+// take nil data for a missing value.
+func clone(b []byte) []byte {
+	return append(make([]byte, 0, len(b)), b...)
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	switch v := v.(type) {
+	case *[]byte:
+		*v = clone(data)
+		return nil
+	case *string:
+		*v = string(data)
+		return nil
+	case encoding.BinaryUnmarshaler:
+		return v.UnmarshalBinary(data)
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		switch e := rv.Elem(); {
+		case e.Kind() == reflect.Slice && e.Type().Elem().Kind() == reflect.Uint8:
+			e.SetBytes(clone(data))
+			return nil
+		case e.Kind() == reflect.String:
+			e.SetString(string(data))
+			return nil
+		}
+	}
+	return fmt.Errorf("codec: raw can't decode into %T", v)
+}
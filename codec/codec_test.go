@@ -0,0 +1,401 @@
+package codec_test
+
+import (
+	"bytes"
+	"databases/codec"
+	"encoding/json"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	gokvencoding "github.com/philippgille/gokv/encoding"
+)
+
+type record struct {
+	Name     string
+	Count    int64
+	Small    int8
+	Unsigned uint32
+	Ratio    float64
+	Half     float32
+	OK       bool
+	Updated  time.Time
+	Data     []byte
+	Tags     []string
+	Labels   map[string]int
+	Next     *record
+	Fixed    [2]uint16
+	hidden   int
+}
+
+func newRecord() record {
+	return record{
+		Name:     "sen1",
+		Count:    -1234567,
+		Small:    -8,
+		Unsigned: 1 << 31,
+		Ratio:    0.25,
+		Half:     1.5,
+		OK:       true,
+		Updated:  time.Date(2020, 8, 26, 1, 2, 3, 4, time.UTC),
+		Data:     []byte{0, 1, 255},
+		Tags:     []string{"a", "", "c"},
+		Labels:   map[string]int{"x": 1, "y": -2},
+		Next:     &record{Name: "next", Updated: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+		Fixed:    [2]uint16{7, 65535},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, name := range []string{"json", "gob", "msgpack", "binary"} {
+		t.Run(name, func(t *testing.T) {
+			c, err := codec.Lookup(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := newRecord()
+			data, err := c.Marshal(want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got record
+			if err := c.Unmarshal(data, &got); err != nil {
+				t.Fatal(err)
+			}
+			// Codecs may decode times in another location.
+			for g, w := &got, &want; g != nil && w != nil; g, w = g.Next, w.Next {
+				if !g.Updated.Equal(w.Updated) {
+					t.Errorf("got time %v, want %v", g.Updated, w.Updated)
+				}
+				g.Updated = w.Updated
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestBinary(t *testing.T) {
+	c := codec.Binary
+	small, err := c.Marshal(int64(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(small) != 1 {
+		t.Errorf("got %d bytes for a small int64, want 1", len(small))
+	}
+
+	// Maps are encoded in a deterministic order.
+	m := map[string]int{}
+	for i, k := range []string{"d", "b", "a", "c", "e"} {
+		m[k] = i
+	}
+	first, _ := c.Marshal(m)
+	for i := 0; i < 10; i++ {
+		data, _ := c.Marshal(m)
+		if !bytes.Equal(data, first) {
+			t.Fatal("encodings of the same map differ")
+		}
+	}
+
+	// Like with JSON, the value passed to Marshal and Unmarshal may be
+	// a pointer or not.
+	in := newRecord()
+	byValue, _ := c.Marshal(in)
+	byPointer, err := c.Marshal(&in)
+	if err != nil || !bytes.Equal(byValue, byPointer) {
+		t.Errorf("encodings of a value and its pointer differ: %v", err)
+	}
+	var p *record
+	if err := c.Unmarshal(byValue, &p); err != nil || p == nil || p.Name != in.Name {
+		t.Errorf("got %v, %v decoding into a pointer", p, err)
+	}
+	if _, err := c.Marshal((*record)(nil)); err == nil {
+		t.Error("encoding a nil pointer succeeded")
+	}
+
+	full, _ := c.Marshal(newRecord())
+	var r record
+	for i := 0; i < len(full); i++ {
+		if err := c.Unmarshal(full[:i], &r); err == nil {
+			t.Fatalf("decoding %d of %d bytes succeeded", i, len(full))
+		}
+	}
+	if err := c.Unmarshal(append(full, 0), &r); err == nil {
+		t.Error("decoding with trailing data succeeded")
+	}
+	var i8 int8
+	big, _ := c.Marshal(1000)
+	if err := c.Unmarshal(big, &i8); err == nil {
+		t.Error("decoding an overflowing int succeeded")
+	}
+	if _, err := c.Marshal(map[string]interface{}{"a": 1}); err == nil {
+		t.Error("encoding an interface succeeded")
+	}
+	if err := c.Unmarshal(small, r); err == nil {
+		t.Error("decoding into a non-pointer succeeded")
+	}
+}
+
+type payload struct {
+	data []byte
+}
+
+func (p payload) MarshalBinary() ([]byte, error) {
+	return p.data, nil
+}
+
+func (p *payload) UnmarshalBinary(data []byte) error {
+	p.data = data
+	return nil
+}
+
+func TestRaw(t *testing.T) {
+	c := codec.Raw
+	in := []byte("value")
+	data, err := c.Marshal(in)
+	if err != nil || string(data) != "value" {
+		t.Fatalf("got %q, %v", data, err)
+	}
+	in[0] = 'V'
+	if string(data) != "value" {
+		t.Error("the encoding shares memory with the value")
+	}
+	var b []byte
+	var s string
+	var p payload
+	if err := c.Unmarshal(data, &b); err != nil || string(b) != "value" {
+		t.Errorf("got %q, %v", b, err)
+	}
+	if err := c.Unmarshal(data, &s); err != nil || s != "value" {
+		t.Errorf("got %q, %v", s, err)
+	}
+	if data, err := c.Marshal("text"); err != nil || string(data) != "text" {
+		t.Errorf("got %q, %v", data, err)
+	}
+	if data, err := c.Marshal(payload{[]byte("bin")}); err != nil || string(data) != "bin" {
+		t.Errorf("got %q, %v", data, err)
+	}
+	if err := c.Unmarshal(data, &p); err != nil || string(p.data) != "value" {
+		t.Errorf("got %q, %v", p.data, err)
+	}
+	raw := json.RawMessage(`{"n":1}`)
+	if data, err := c.Marshal(raw); err != nil || string(data) != `{"n":1}` {
+		t.Errorf("got %q, %v for a json.RawMessage", data, err)
+	}
+	var got json.RawMessage
+	if err := c.Unmarshal([]byte(`{"n":1}`), &got); err != nil || string(got) != `{"n":1}` {
+		t.Errorf("got %q, %v for a json.RawMessage", got, err)
+	}
+	// Stores take nil data for a missing value.
+	if data, err := c.Marshal([]byte{}); err != nil || data == nil {
+		t.Errorf("got %#v, %v for an empty value", data, err)
+	}
+	if _, err := c.Marshal(newRecord()); err == nil {
+		t.Error("encoding a struct succeeded")
+	}
+	if err := c.Unmarshal(data, new(int)); err == nil {
+		t.Error("decoding into an int succeeded")
+	}
+}
+
+type upper struct{}
+
+func (upper) Marshal(v interface{}) ([]byte, error)      { return nil, nil }
+func (upper) Unmarshal(data []byte, v interface{}) error { return nil }
+
+func TestRegistry(t *testing.T) {
+	for _, name := range codec.Names() {
+		c, err := codec.Lookup(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := codec.Name(c); got != name {
+			t.Errorf("Name of %s got %q", name, got)
+		}
+	}
+	if codec.Name(gokvencoding.JSON) != "json" {
+		t.Error("gokv's JSON codec isn't named json")
+	}
+	if _, err := codec.Lookup("unknown"); err == nil {
+		t.Error("Lookup of an unknown codec succeeded")
+	}
+
+	codec.Register("upper", upper{})
+	if c, err := codec.Lookup("upper"); err != nil || c != (upper{}) {
+		t.Errorf("got %v, %v", c, err)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a name twice didn't panic")
+		}
+	}()
+	codec.Register("json", upper{})
+}
+
+func TestCompressed(t *testing.T) {
+	for _, alg := range codec.Algorithms {
+		t.Run(alg, func(t *testing.T) {
+			options := codec.DefaultCompressOptions
+			options.Algorithm = alg
+			c, err := codec.NewCompressed(codec.JSON, &options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := codec.Name(c); got != "json+"+alg {
+				t.Errorf("got name %q, want json+%s", got, alg)
+			}
+
+			// Repetitive values shrink.
+			want := make([]string, 100)
+			for i := range want {
+				want[i] = "eth0"
+			}
+			data, err := c.Marshal(want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			plain, _ := codec.JSON.Marshal(want)
+			if len(data) >= len(plain)/4 {
+				t.Errorf("got %d bytes for %d bytes of JSON", len(data), len(plain))
+			}
+			var got []string
+			if err := c.Unmarshal(data, &got); err != nil || !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, %v", got, err)
+			}
+
+			// Values below the threshold and random data are stored raw.
+			random := make([]byte, 1024)
+			rand.New(rand.NewSource(1)).Read(random)
+			for _, v := range []interface{}{"small", random} {
+				data, err := c.Marshal(v)
+				if err != nil {
+					t.Fatal(err)
+				}
+				plain, _ := codec.JSON.Marshal(v)
+				if len(data) != 1+len(plain) {
+					t.Errorf("got %d bytes for %d bytes of JSON", len(data), len(plain))
+				}
+			}
+
+			// Corrupt data is an error.
+			data[len(data)/2] ^= 0xff
+			for _, corrupt := range [][]byte{nil, {9}, data} {
+				if err := c.Unmarshal(corrupt, &got); err == nil {
+					t.Errorf("decoding %v succeeded", corrupt)
+				}
+			}
+		})
+	}
+
+	// Data can be decoded with other options than it was written with.
+	gz, err := codec.Lookup("binary+gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fl, err := codec.NewCompressed(codec.Binary, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := gz.Marshal(newRecord())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r record
+	if err := fl.Unmarshal(data, &r); err != nil || r.Name != "sen1" {
+		t.Errorf("got %+v, %v", r, err)
+	}
+
+	if _, err := codec.Lookup("json+zip"); err == nil {
+		t.Error("Lookup of an unknown algorithm succeeded")
+	}
+	if _, err := codec.NewCompressed(codec.JSON, &codec.CompressOptions{Algorithm: codec.Flate, Level: 10}); err == nil {
+		t.Error("NewCompressed with an invalid level succeeded")
+	}
+}
+
+func TestEncrypted(t *testing.T) {
+	key1, key2 := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 16)
+	old, err := codec.NewEncrypted(codec.JSON, &codec.EncryptOptions{
+		Keys:  map[uint32][]byte{1: key1},
+		KeyID: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := codec.NewEncrypted(codec.JSON, &codec.EncryptOptions{
+		Keys:  map[uint32][]byte{1: key1, 2: key2},
+		KeyID: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := newRecord()
+	data, err := old.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("sen1")) {
+		t.Error("the encrypted data contains the plaintext")
+	}
+	again, _ := old.Marshal(want)
+	if bytes.Equal(data, again) {
+		t.Error("encryptions of the same value are equal")
+	}
+	plain1, err1 := old.Plain(data)
+	plain2, err2 := old.Plain(again)
+	if err1 != nil || err2 != nil || !bytes.Equal(plain1, plain2) {
+		t.Errorf("plain encodings of the same value differ: %v, %v", err1, err2)
+	}
+
+	// The new codec decrypts data of the old key and re-encrypts it.
+	var got record
+	if err := c.Unmarshal(data, &got); err != nil || got.Name != want.Name {
+		t.Fatalf("got %+v, %v", got, err)
+	}
+	if id, err := codec.KeyID(data); err != nil || id != 1 {
+		t.Errorf("got key ID %d, %v, want 1", id, err)
+	}
+	rotated, err := c.Reencrypt(data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := codec.KeyID(rotated); id != 2 {
+		t.Errorf("got key ID %d after Reencrypt, want 2", id)
+	}
+	if err := old.Unmarshal(rotated, &got); err != codec.ErrUnknownKey {
+		t.Errorf("got %v decrypting with a codec without the key, want ErrUnknownKey", err)
+	}
+
+	// Tampered data, additional data and headers are detected.
+	sealed, err := c.Seal([]byte("value"), []byte("k1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Open(sealed, []byte("k2")); err == nil {
+		t.Error("opening with other additional data succeeded")
+	}
+	for i := range sealed {
+		tampered := append([]byte(nil), sealed...)
+		tampered[i] ^= 1
+		if _, err := c.Open(tampered, []byte("k1")); err == nil {
+			t.Fatalf("opening with byte %d changed succeeded", i)
+		}
+	}
+	if _, err := c.Open(sealed[:20], []byte("k1")); err == nil {
+		t.Error("opening truncated data succeeded")
+	}
+
+	for _, options := range []*codec.EncryptOptions{
+		nil,
+		{Keys: map[uint32][]byte{1: key1}, KeyID: 2},
+		{Keys: map[uint32][]byte{1: key1[:10]}, KeyID: 1},
+	} {
+		if _, err := codec.NewEncrypted(codec.JSON, options); err == nil {
+			t.Errorf("NewEncrypted with %+v succeeded", options)
+		}
+	}
+}
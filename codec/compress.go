@@ -0,0 +1,201 @@
+package codec
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	gokvencoding "github.com/philippgille/gokv/encoding"
+)
+
+// The compression algorithms.
+const (
+	// Flate is raw DEFLATE, without headers or checksums.
+	Flate = "flate"
+	// Gzip is DEFLATE with the gzip header and CRC-32 checksum.
+	Gzip = "gzip"
+)
+
+// Algorithms are the names of the compression algorithms.
+var Algorithms = []string{Flate, Gzip}
+
+// The first byte of a compressed codec's data tells how the rest is stored.
+const (
+	storedRaw byte = iota
+	storedFlate
+	storedGzip
+)
+
+// CompressOptions are the options of a compressing codec.
+type CompressOptions struct {
+	// Compression algorithm, Flate or Gzip.
+	Algorithm string
+	// Compression level from flate.BestSpeed to flate.BestCompression,
+	// or flate.DefaultCompression.
+	Level int
+	// Encodings shorter than Threshold bytes are stored uncompressed,
+	// as compressing them costs more time than it saves space.
+	Threshold int
+}
+
+// DefaultCompressOptions is a CompressOptions object with default values.
+var DefaultCompressOptions = CompressOptions{
+	Algorithm: Flate,
+	Level:     flate.DefaultCompression,
+	Threshold: 128,
+}
+
+// compressed compresses the encodings of another codec. Its data is a
+// byte that tells how the encoding is stored, followed by the encoding
+// itself or its compression. Encodings below the threshold and those that
+// compression doesn't shrink are stored raw. Data can be decoded no matter
+// which options it was written with.
+type compressed struct {
+	codec     gokvencoding.Codec
+	algorithm string
+	level     int
+	threshold int
+	// writers are reusable compressors, as creating one allocates
+	// several hundred kilobytes.
+	writers *sync.Pool
+}
+
+// NewCompressed returns a codec that compresses the encodings of c.
+// It can be used as the Codec of any store's Options.
+func NewCompressed(c gokvencoding.Codec, options *CompressOptions) (gokvencoding.Codec, error) {
+	if options == nil {
+		options = &DefaultCompressOptions
+	}
+	if c == nil {
+		return nil, errors.New("codec: NewCompressed with a nil codec")
+	}
+	if options.Algorithm != Flate && options.Algorithm != Gzip {
+		return nil, fmt.Errorf("codec: unknown compression algorithm %q", options.Algorithm)
+	}
+	if options.Level < flate.HuffmanOnly || options.Level > flate.BestCompression {
+		return nil, fmt.Errorf("codec: invalid compression level %d", options.Level)
+	}
+	cc := compressed{
+		codec:     c,
+		algorithm: options.Algorithm,
+		level:     options.Level,
+		threshold: options.Threshold,
+		writers:   new(sync.Pool),
+	}
+	return cc, nil
+}
+
+// compressor is the common interface of flate and gzip writers.
+type compressor interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+func (c compressed) newWriter(w io.Writer) compressor {
+	if zw, ok := c.writers.Get().(compressor); ok {
+		zw.Reset(w)
+		return zw
+	}
+	// The level was checked by NewCompressed, so there are no errors.
+	if c.algorithm == Gzip {
+		zw, _ := gzip.NewWriterLevel(w, c.level)
+		return zw
+	}
+	zw, _ := flate.NewWriter(w, c.level)
+	return zw
+}
+
+func (c compressed) Marshal(v interface{}) ([]byte, error) {
+	data, err := c.codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) >= c.threshold {
+		header := storedFlate
+		if c.algorithm == Gzip {
+			header = storedGzip
+		}
+		buf := bytes.NewBuffer(make([]byte, 0, len(data)/2))
+		buf.WriteByte(header)
+		zw := c.newWriter(buf)
+		_, err := zw.Write(data)
+		if err == nil {
+			err = zw.Close()
+		}
+		c.writers.Put(zw)
+		if err != nil {
+			return nil, err
+		}
+		if buf.Len() < 1+len(data) {
+			return buf.Bytes(), nil
+		}
+	}
+	return append([]byte{storedRaw}, data...), nil
+}
+
+// Decompressors are reused like compressors, but don't depend on the
+// options of a codec, so they are shared.
+var flateReaders, gzipReaders sync.Pool
+
+func (c compressed) Unmarshal(data []byte, v interface{}) error {
+	if len(data) == 0 {
+		return errors.New("codec: compressed data is empty")
+	}
+	src := bytes.NewReader(data[1:])
+	var plain []byte
+	var err error
+	switch data[0] {
+	case storedRaw:
+		return c.codec.Unmarshal(data[1:], v)
+	case storedFlate:
+		zr, ok := flateReaders.Get().(io.ReadCloser)
+		if ok {
+			err = zr.(flate.Resetter).Reset(src, nil)
+		} else {
+			zr = flate.NewReader(src)
+		}
+		if err == nil {
+			plain, err = ioutil.ReadAll(zr)
+		}
+		flateReaders.Put(zr)
+	case storedGzip:
+		zr, ok := gzipReaders.Get().(*gzip.Reader)
+		if ok {
+			err = zr.Reset(src)
+		} else {
+			zr, err = gzip.NewReader(src)
+		}
+		if err == nil {
+			plain, err = ioutil.ReadAll(zr)
+			gzipReaders.Put(zr)
+		}
+	default:
+		return fmt.Errorf("codec: unknown compression %d", data[0])
+	}
+	if err != nil {
+		return err
+	}
+	return c.codec.Unmarshal(plain, v)
+}
+
+// lookupCompressed returns the codec registered as the part of name before
+// "+" compressed with the algorithm after it, like "json+gzip".
+func lookupCompressed(name string) (gokvencoding.Codec, error) {
+	i := strings.LastIndex(name, "+")
+	if i < 0 {
+		return nil, fmt.Errorf("codec: unknown codec %q", name)
+	}
+	c, err := Lookup(name[:i])
+	if err != nil {
+		return nil, err
+	}
+	options := DefaultCompressOptions
+	options.Algorithm = name[i+1:]
+	return NewCompressed(c, &options)
+}
@@ -0,0 +1,182 @@
+package codec
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	gokvencoding "github.com/philippgille/gokv/encoding"
+)
+
+// encryptedVersion is the first byte of encrypted data.
+const encryptedVersion byte = 1
+
+// The header of encrypted data is the version and the key ID.
+const encryptedHeaderSize = 1 + 4
+
+// ErrUnknownKey is returned when data was encrypted with a key that
+// isn't in the options of the codec that decrypts it.
+var ErrUnknownKey = errors.New("codec: data is encrypted with an unknown key")
+
+// EncryptOptions are the options of an encrypting codec.
+type EncryptOptions struct {
+	// Keys by their IDs. Every key is 16, 24 or 32 bytes long
+	// to select AES-128, AES-192 or AES-256.
+	Keys map[uint32][]byte
+	// ID of the key that values are encrypted with. Data encrypted
+	// with the other keys can still be decrypted, so keys are rotated
+	// by adding a key, making it the current one and re-encrypting
+	// the stored values before the old key is removed.
+	KeyID uint32
+}
+
+// Encrypted is a codec that encrypts the encodings of another codec
+// with AES-GCM. Its data is a version byte, the big-endian ID of the key
+// it was encrypted with, a random nonce and the sealed encoding.
+// The header is authenticated along with the encoding.
+type Encrypted struct {
+	codec gokvencoding.Codec
+	keyID uint32
+	aeads map[uint32]cipher.AEAD
+}
+
+// NewEncrypted returns a codec that encrypts the encodings of c.
+// It can be used as the Codec of any store's Options.
+func NewEncrypted(c gokvencoding.Codec, options *EncryptOptions) (*Encrypted, error) {
+	if c == nil {
+		return nil, errors.New("codec: NewEncrypted with a nil codec")
+	}
+	if options == nil || len(options.Keys) == 0 {
+		return nil, errors.New("codec: NewEncrypted without keys")
+	}
+	if _, ok := options.Keys[options.KeyID]; !ok {
+		return nil, fmt.Errorf("codec: no key with the current key ID %d", options.KeyID)
+	}
+	e := &Encrypted{
+		codec: c,
+		keyID: options.KeyID,
+		aeads: make(map[uint32]cipher.AEAD, len(options.Keys)),
+	}
+	for id, key := range options.Keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("codec: key %d: %v", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("codec: key %d: %v", id, err)
+		}
+		e.aeads[id] = aead
+	}
+	return e, nil
+}
+
+// Marshal encodes v and encrypts the encoding with the current key.
+func (e *Encrypted) Marshal(v interface{}) ([]byte, error) {
+	data, err := e.codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return e.Seal(data, nil)
+}
+
+// Unmarshal decrypts data and decodes it into v.
+func (e *Encrypted) Unmarshal(data []byte, v interface{}) error {
+	plain, err := e.Open(data, nil)
+	if err != nil {
+		return err
+	}
+	return e.codec.Unmarshal(plain, v)
+}
+
+// Plain returns the decrypted encoding in data, which unlike data is
+// the same for equal values. It implements kv.PlainCodec, so that
+// compare-and-swap works with encrypted values.
+func (e *Encrypted) Plain(data []byte) ([]byte, error) {
+	return e.Open(data, nil)
+}
+
+// Seal encrypts plain with the current key. The additional data ad,
+// like the key the data is stored for, isn't part of the result, but
+// the same must be passed to Open to decrypt it.
+func (e *Encrypted) Seal(plain, ad []byte) ([]byte, error) {
+	aead := e.aeads[e.keyID]
+	size := encryptedHeaderSize + aead.NonceSize() + len(plain) + aead.Overhead()
+	data := make([]byte, encryptedHeaderSize+aead.NonceSize(), size)
+	data[0] = encryptedVersion
+	binary.BigEndian.PutUint32(data[1:encryptedHeaderSize], e.keyID)
+	nonce := data[encryptedHeaderSize:]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(data, nonce, plain, additionalData(data[:encryptedHeaderSize], ad)), nil
+}
+
+// Open decrypts data that Seal encrypted with the same additional data.
+func (e *Encrypted) Open(data, ad []byte) ([]byte, error) {
+	id, err := KeyID(data)
+	if err != nil {
+		return nil, err
+	}
+	aead, ok := e.aeads[id]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if len(data) < encryptedHeaderSize+aead.NonceSize()+aead.Overhead() {
+		return nil, errors.New("codec: encrypted data is truncated")
+	}
+	nonce := data[encryptedHeaderSize : encryptedHeaderSize+aead.NonceSize()]
+	sealed := data[encryptedHeaderSize+aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, sealed, additionalData(data[:encryptedHeaderSize], ad))
+	if err != nil {
+		return nil, errors.New("codec: encrypted data can't be authenticated")
+	}
+	return plain, nil
+}
+
+// Reencrypt decrypts data and encrypts it again with the current key
+// without decoding it. It returns data itself if it already is encrypted
+// with the current key.
+func (e *Encrypted) Reencrypt(data, ad []byte) ([]byte, error) {
+	id, err := KeyID(data)
+	if err != nil {
+		return nil, err
+	}
+	if id == e.keyID {
+		return data, nil
+	}
+	plain, err := e.Open(data, ad)
+	if err != nil {
+		return nil, err
+	}
+	return e.Seal(plain, ad)
+}
+
+// CurrentKeyID returns the ID of the key that values are encrypted with.
+func (e *Encrypted) CurrentKeyID() uint32 {
+	return e.keyID
+}
+
+// KeyID returns the ID of the key that data was encrypted with.
+func KeyID(data []byte) (uint32, error) {
+	if len(data) < encryptedHeaderSize {
+		return 0, errors.New("codec: encrypted data is truncated")
+	}
+	if data[0] != encryptedVersion {
+		return 0, fmt.Errorf("codec: unknown encryption version %d", data[0])
+	}
+	return binary.BigEndian.Uint32(data[1:encryptedHeaderSize]), nil
+}
+
+// additionalData returns the data that is authenticated along with the
+// encoding: the header and the caller's additional data.
+func additionalData(header, ad []byte) []byte {
+	if len(ad) == 0 {
+		return header
+	}
+	return append(append(make([]byte, 0, len(header)+len(ad)), header...), ad...)
+}
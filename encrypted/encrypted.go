@@ -0,0 +1,280 @@
+// Package encrypted encrypts the values of any gokv.Store with AES-GCM,
+// so that persistent stores don't write them to disk in plaintext.
+//
+// Every value is encoded with the codec of the encrypted.Store, sealed with
+// the current key and stored as []byte in the underlying store, which
+// should use codec.Raw to store it as it is. The key a value is stored
+// for is authenticated along with it, so values can't be swapped between
+// keys. Keys aren't encrypted.
+//
+// Keys are rotated by adding a new key with a new ID, making it the current
+// one and calling Rotate, which re-encrypts the values sealed with the old
+// keys. The old keys can be removed afterwards.
+package encrypted
+
+import (
+	"databases/codec"
+	"databases/kv"
+	"time"
+
+	"github.com/philippgille/gokv"
+	"github.com/philippgille/gokv/encoding"
+	"github.com/philippgille/gokv/util"
+)
+
+// Store is a gokv.Store that encrypts the values of another store.
+type Store struct {
+	Store gokv.Store
+	Codec encoding.Codec
+	// Cipher seals the encoded values. Its codec isn't used.
+	Cipher *codec.Encrypted
+}
+
+// seal encodes and encrypts v for k.
+func (s Store) seal(k string, v interface{}) ([]byte, error) {
+	if err := util.CheckKeyAndValue(k, v); err != nil {
+		return nil, err
+	}
+	data, err := s.Codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return s.Cipher.Seal(data, []byte(k))
+}
+
+// open decrypts the sealed value of k and decodes it into v.
+func (s Store) open(k string, sealed []byte, v interface{}) error {
+	data, err := s.Cipher.Open(sealed, []byte(k))
+	if err != nil {
+		return err
+	}
+	return s.Codec.Unmarshal(data, v)
+}
+
+// Set stores the given value for the given key.
+func (s Store) Set(k string, v interface{}) error {
+	sealed, err := s.seal(k, v)
+	if err != nil {
+		return err
+	}
+	return s.Store.Set(k, sealed)
+}
+
+// SetWithTTL stores the given value for the given key.
+// The value expires after ttl. It returns kv.ErrNotSupported
+// if the underlying store doesn't implement kv.TTLStore.
+func (s Store) SetWithTTL(k string, v interface{}, ttl time.Duration) error {
+	ts, ok := s.Store.(kv.TTLStore)
+	if !ok {
+		return kv.ErrNotSupported
+	}
+	sealed, err := s.seal(k, v)
+	if err != nil {
+		return err
+	}
+	return ts.SetWithTTL(k, sealed, ttl)
+}
+
+// Get retrieves the stored value for the given key.
+func (s Store) Get(k string, v interface{}) (found bool, err error) {
+	if err := util.CheckKeyAndValue(k, v); err != nil {
+		return false, err
+	}
+	var sealed []byte
+	found, err = s.Store.Get(k, &sealed)
+	if err != nil || !found {
+		return false, err
+	}
+	return true, s.open(k, sealed, v)
+}
+
+// Delete deletes the stored value for the given key.
+func (s Store) Delete(k string) error {
+	return s.Store.Delete(k)
+}
+
+// SetMany stores the given values for their keys,
+// in a batch if the underlying store supports it.
+func (s Store) SetMany(values map[string]interface{}) error {
+	sealed := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		data, err := s.seal(k, v)
+		if err != nil {
+			return err
+		}
+		sealed[k] = data
+	}
+	return kv.WithBatch(s.Store).SetMany(sealed)
+}
+
+// GetMany retrieves the stored values for the given keys,
+// in a batch if the underlying store supports it.
+func (s Store) GetMany(keys []string, vs []interface{}) (found []bool, err error) {
+	if len(keys) != len(vs) {
+		return nil, kv.ErrLengthMismatch
+	}
+	for i, k := range keys {
+		if err := util.CheckKeyAndValue(k, vs[i]); err != nil {
+			return nil, err
+		}
+	}
+	sealed := make([][]byte, len(keys))
+	dst := make([]interface{}, len(keys))
+	for i := range sealed {
+		dst[i] = &sealed[i]
+	}
+	found, err = kv.WithBatch(s.Store).GetMany(keys, dst)
+	if err != nil {
+		return nil, err
+	}
+	for i, k := range keys {
+		if !found[i] {
+			continue
+		}
+		if err := s.open(k, sealed[i], vs[i]); err != nil {
+			return nil, err
+		}
+	}
+	return found, nil
+}
+
+// DeleteMany deletes the stored values for the given keys,
+// in a batch if the underlying store supports it.
+func (s Store) DeleteMany(keys []string) error {
+	return kv.WithBatch(s.Store).DeleteMany(keys)
+}
+
+// Scan returns an iterator over the keys of the underlying store, or
+// kv.ErrNotSupported if it doesn't implement kv.Scanner.
+func (s Store) Scan(prefix, start, end string) (kv.Iterator, error) {
+	sc, ok := s.Store.(kv.Scanner)
+	if !ok {
+		return nil, kv.ErrNotSupported
+	}
+	it, err := sc.Scan(prefix, start, end)
+	if err != nil {
+		return nil, err
+	}
+	return iterator{Iterator: it, store: s}, nil
+}
+
+// iterator decrypts the values of an iterator of the underlying store.
+type iterator struct {
+	kv.Iterator
+	store Store
+}
+
+func (it iterator) Value(v interface{}) error {
+	var sealed []byte
+	if err := it.Iterator.Value(&sealed); err != nil {
+		return err
+	}
+	return it.store.open(it.Key(), sealed, v)
+}
+
+// Rotate re-encrypts every value that isn't sealed with the current key
+// and returns the number of values it rewrote. It returns
+// kv.ErrNotSupported if the underlying store doesn't implement kv.Scanner.
+//
+// If the underlying store implements kv.AtomicStore, values that are
+// changed while Rotate runs are left alone. Otherwise Rotate must not run
+// concurrently with writes. Rewritten values lose their TTL.
+func (s Store) Rotate() (n int, err error) {
+	sc, ok := s.Store.(kv.Scanner)
+	if !ok {
+		return 0, kv.ErrNotSupported
+	}
+	// The values are rewritten after the scan, as not every store can
+	// be written to while it's iterated.
+	var stale []kv.Pair
+	it, err := sc.Scan("", "", "")
+	if err != nil {
+		return 0, err
+	}
+	for it.Next() {
+		var sealed []byte
+		if err := it.Value(&sealed); err != nil {
+			it.Close()
+			return 0, err
+		}
+		id, err := codec.KeyID(sealed)
+		if err != nil {
+			it.Close()
+			return 0, err
+		}
+		if id != s.Cipher.CurrentKeyID() {
+			stale = append(stale, kv.Pair{Key: it.Key(), Value: sealed})
+		}
+	}
+	if err := it.Err(); err != nil {
+		it.Close()
+		return 0, err
+	}
+	if err := it.Close(); err != nil {
+		return 0, err
+	}
+
+	as, atomic := s.Store.(kv.AtomicStore)
+	for _, p := range stale {
+		sealed, err := s.Cipher.Reencrypt(p.Value, []byte(p.Key))
+		if err != nil {
+			return n, err
+		}
+		if atomic {
+			swapped, err := as.CompareAndSwap(p.Key, p.Value, sealed)
+			if err != nil {
+				return n, err
+			}
+			if !swapped {
+				continue
+			}
+		} else if err := s.Store.Set(p.Key, sealed); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Close closes the underlying store.
+func (s Store) Close() error {
+	return s.Store.Close()
+}
+
+// Options are the options for the encrypted store.
+type Options struct {
+	// Keys by their IDs. Every key is 16, 24 or 32 bytes long
+	// to select AES-128, AES-192 or AES-256. There are no default keys.
+	Keys map[uint32][]byte
+	// ID of the key that values are encrypted with.
+	KeyID uint32
+	// Encoding format of the values before they are encrypted.
+	Codec encoding.Codec
+}
+
+// DefaultOptions is an Options object with default values.
+// Its Keys must be set before it's used.
+var DefaultOptions = Options{
+	Codec: encoding.JSON,
+}
+
+// NewStore creates a store that encrypts the values of store.
+func NewStore(store gokv.Store, options *Options) (Store, error) {
+	if options == nil {
+		options = &DefaultOptions
+	}
+	cipher, err := codec.NewEncrypted(codec.Raw, &codec.EncryptOptions{
+		Keys:  options.Keys,
+		KeyID: options.KeyID,
+	})
+	if err != nil {
+		return Store{}, err
+	}
+	result := Store{
+		Store:  store,
+		Codec:  options.Codec,
+		Cipher: cipher,
+	}
+
+	return result, nil
+}
@@ -0,0 +1,159 @@
+package encrypted_test
+
+import (
+	"bytes"
+	"databases/codec"
+	"databases/encrypted"
+	"databases/memory"
+	"databases/pudge"
+	"databases/storetest"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/philippgille/gokv"
+)
+
+var (
+	key1 = bytes.Repeat([]byte{1}, 32)
+	key2 = bytes.Repeat([]byte{2}, 32)
+)
+
+// newMemoryStore returns a memory store that stores []byte as they are.
+func newMemoryStore(t *testing.T) memory.Store {
+	store, err := memory.NewStore(&memory.Options{Codec: codec.Raw})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func newStore(t *testing.T, store gokv.Store, keys map[uint32][]byte, id uint32) encrypted.Store {
+	ops := encrypted.DefaultOptions
+	ops.Keys = keys
+	ops.KeyID = id
+	s, err := encrypted.NewStore(store, &ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestStore(t *testing.T) {
+	storetest.TestStore(t, func(t *testing.T) gokv.Store {
+		return newStore(t, newMemoryStore(t), map[uint32][]byte{1: key1}, 1)
+	})
+}
+
+// TestNoPlaintext checks that values don't reach the files of a
+// persistent store in plaintext.
+func TestNoPlaintext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "encrypted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ops := pudge.DefaultOptions
+	ops.File = path.Join(dir, "db")
+	ops.Codec = codec.Raw
+	store, err := pudge.NewStore(&ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newStore(t, store, map[uint32][]byte{1: key1}, 1)
+	want := storetest.Value{SensorID: "secret-sensor", TxBytes: 1}
+	if err := s.Set("sen1", want); err != nil {
+		t.Fatal(err)
+	}
+	var got storetest.Value
+	if found, err := s.Get("sen1", &got); err != nil || !found || got != want {
+		t.Errorf("got %+v, %v, %v", got, found, err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var files int
+	err = filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		files++
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		if bytes.Contains(data, []byte("secret-sensor")) {
+			t.Errorf("%s contains the plaintext", name)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files == 0 {
+		t.Error("the store wrote no files")
+	}
+}
+
+// TestSwappedValues checks that a value can't be moved to another key
+// in the underlying store.
+func TestSwappedValues(t *testing.T) {
+	store := newMemoryStore(t)
+	s := newStore(t, store, map[uint32][]byte{1: key1}, 1)
+	defer s.Close()
+	if err := s.Set("sen1", 1); err != nil {
+		t.Fatal(err)
+	}
+	var sealed []byte
+	if _, err := store.Get("sen1", &sealed); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("sen2", sealed); err != nil {
+		t.Fatal(err)
+	}
+	var v int
+	if _, err := s.Get("sen2", &v); err == nil {
+		t.Error("getting a value moved from another key succeeded")
+	}
+}
+
+func TestRotate(t *testing.T) {
+	store := newMemoryStore(t)
+	old := newStore(t, store, map[uint32][]byte{1: key1}, 1)
+	for _, k := range []string{"sen1", "sen2", "sen3"} {
+		if err := old.Set(k, k); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := newStore(t, store, map[uint32][]byte{1: key1, 2: key2}, 2)
+	if err := s.Set("sen4", "sen4"); err != nil {
+		t.Fatal(err)
+	}
+	n, err := s.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("rotated %d values, want 3", n)
+	}
+	if n, err := s.Rotate(); err != nil || n != 0 {
+		t.Errorf("second rotation rotated %d values, %v", n, err)
+	}
+
+	// The values are readable without the old key.
+	current := newStore(t, store, map[uint32][]byte{2: key2}, 2)
+	for _, k := range []string{"sen1", "sen2", "sen3", "sen4"} {
+		var v string
+		if found, err := current.Get(k, &v); err != nil || !found || v != k {
+			t.Errorf("Get(%q) got %q, %v, %v", k, v, found, err)
+		}
+	}
+	var v string
+	if _, err := old.Get("sen1", &v); err != codec.ErrUnknownKey {
+		t.Errorf("got %v with the old key, want ErrUnknownKey", err)
+	}
+}
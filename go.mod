@@ -18,6 +18,8 @@ require (
 	github.com/philippgille/gokv/redis v0.6.0
 	github.com/philippgille/gokv/util v0.0.0-20191011213304-eb77f15b9c61
 	github.com/recoilme/pudge v1.0.3
+	github.com/vmihailenco/msgpack/v4 v4.3.12
 	github.com/xujiajun/nutsdb v0.5.0
 	go.mongodb.org/mongo-driver v1.4.0
+	gopkg.in/yaml.v3 v3.0.1
 )
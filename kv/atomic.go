@@ -0,0 +1,113 @@
+package kv
+
+import (
+	"bytes"
+
+	"github.com/philippgille/gokv"
+	"github.com/philippgille/gokv/encoding"
+	"github.com/philippgille/gokv/util"
+)
+
+// AtomicStore is a gokv.Store with atomic read-modify-write operations.
+// Values are compared in their encoded form (see PlainCodec), so old
+// must encode exactly like the stored value, which it does if it's equal
+// to the value that was set. Values written by these operations don't expire.
+type AtomicStore interface {
+	gokv.Store
+	// CompareAndSwap stores new for k if the stored value equals old
+	// and reports whether it did.
+	CompareAndSwap(k string, old, new interface{}) (swapped bool, err error)
+	// SetIfAbsent stores v for k if no value is stored for it
+	// and reports whether it did.
+	SetIfAbsent(k string, v interface{}) (set bool, err error)
+	// Increment adds delta to the int64 stored for k, or to 0 if none is,
+	// and returns the result.
+	Increment(k string, delta int64) (int64, error)
+}
+
+// PlainCodec is a codec whose encodings of equal values differ, like an
+// encrypting one. Plain returns the deterministic encoding inside data,
+// which CompareAndSwap compares instead.
+type PlainCodec interface {
+	encoding.Codec
+	Plain(data []byte) ([]byte, error)
+}
+
+// ModifyFunc atomically replaces the encoded value of k with the one
+// computed by fn from the current value, if fn returns write == true.
+// Stores implement it with their own locks or transactions and pass
+// it to CompareAndSwap, SetIfAbsent and Increment.
+type ModifyFunc func(k string, fn func(data []byte, found bool) (newData []byte, write bool, err error)) error
+
+// CompareAndSwap implements AtomicStore.CompareAndSwap with modify.
+func CompareAndSwap(modify ModifyFunc, codec encoding.Codec, k string, old, new interface{}) (swapped bool, err error) {
+	if err := util.CheckKeyAndValue(k, old); err != nil {
+		return false, err
+	}
+	if err := util.CheckKeyAndValue(k, new); err != nil {
+		return false, err
+	}
+	oldData, err := codec.Marshal(old)
+	if err != nil {
+		return false, err
+	}
+	newData, err := codec.Marshal(new)
+	if err != nil {
+		return false, err
+	}
+	pc, plain := codec.(PlainCodec)
+	if plain {
+		if oldData, err = pc.Plain(oldData); err != nil {
+			return false, err
+		}
+	}
+	err = modify(k, func(data []byte, found bool) ([]byte, bool, error) {
+		if found && plain {
+			var err error
+			if data, err = pc.Plain(data); err != nil {
+				return nil, false, err
+			}
+		}
+		swapped = found && bytes.Equal(data, oldData)
+		return newData, swapped, nil
+	})
+	return swapped, err
+}
+
+// SetIfAbsent implements AtomicStore.SetIfAbsent with modify.
+func SetIfAbsent(modify ModifyFunc, codec encoding.Codec, k string, v interface{}) (set bool, err error) {
+	if err := util.CheckKeyAndValue(k, v); err != nil {
+		return false, err
+	}
+	newData, err := codec.Marshal(v)
+	if err != nil {
+		return false, err
+	}
+	err = modify(k, func(data []byte, found bool) ([]byte, bool, error) {
+		set = !found
+		return newData, set, nil
+	})
+	return set, err
+}
+
+// Increment implements AtomicStore.Increment with modify.
+func Increment(modify ModifyFunc, codec encoding.Codec, k string, delta int64) (n int64, err error) {
+	if err := util.CheckKey(k); err != nil {
+		return 0, err
+	}
+	err = modify(k, func(data []byte, found bool) ([]byte, bool, error) {
+		n = 0
+		if found {
+			if err := codec.Unmarshal(data, &n); err != nil {
+				return nil, false, err
+			}
+		}
+		n += delta
+		newData, err := codec.Marshal(n)
+		return newData, true, err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
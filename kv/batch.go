@@ -0,0 +1,89 @@
+package kv
+
+import (
+	"errors"
+
+	"github.com/philippgille/gokv"
+)
+
+// ErrLengthMismatch is returned by GetMany when the number of keys
+// and the number of values to decode into differ.
+var ErrLengthMismatch = errors.New("kv: number of keys and values differ")
+
+// BatchStore is a gokv.Store that can read and write many keys at once,
+// in a single batch or transaction where the engine supports it.
+type BatchStore interface {
+	gokv.Store
+	// SetMany stores the given values for their keys.
+	SetMany(values map[string]interface{}) error
+	// GetMany retrieves the stored values for the given keys into vs,
+	// which must hold a pointer for every key. found reports for every
+	// key whether a value was found.
+	GetMany(keys []string, vs []interface{}) (found []bool, err error)
+	// DeleteMany deletes the stored values for the given keys.
+	DeleteMany(keys []string) error
+}
+
+// WithBatch returns the store as a BatchStore.
+// Stores that don't implement BatchStore are wrapped, and the wrapper
+// reads and writes the keys one by one.
+func WithBatch(s gokv.Store) BatchStore {
+	if bs, ok := s.(BatchStore); ok {
+		return bs
+	}
+	return batchStore{s}
+}
+
+type batchStore struct {
+	gokv.Store
+}
+
+func (s batchStore) SetMany(values map[string]interface{}) error {
+	return SetEach(s.Store, values)
+}
+
+func (s batchStore) GetMany(keys []string, vs []interface{}) ([]bool, error) {
+	return GetEach(s.Store, keys, vs)
+}
+
+func (s batchStore) DeleteMany(keys []string) error {
+	return DeleteEach(s.Store, keys)
+}
+
+// SetEach stores the given values one by one.
+// It implements SetMany for stores without batches.
+func SetEach(s gokv.Store, values map[string]interface{}) error {
+	for k, v := range values {
+		if err := s.Set(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetEach retrieves the values for the given keys one by one.
+// It implements GetMany for stores without batches.
+func GetEach(s gokv.Store, keys []string, vs []interface{}) ([]bool, error) {
+	if len(keys) != len(vs) {
+		return nil, ErrLengthMismatch
+	}
+	found := make([]bool, len(keys))
+	for i, k := range keys {
+		var err error
+		if found[i], err = s.Get(k, vs[i]); err != nil {
+			return nil, err
+		}
+	}
+	return found, nil
+}
+
+// DeleteEach deletes the values for the given keys one by one.
+// It implements DeleteMany for stores without batches.
+func DeleteEach(s gokv.Store, keys []string) error {
+	for _, k := range keys {
+		if err := s.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
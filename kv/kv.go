@@ -0,0 +1,58 @@
+// Package kv defines interfaces that extend gokv.Store with the
+// capabilities some of the stores in this repository offer,
+// and adapters that add them to any gokv.Store.
+package kv
+
+import (
+	"context"
+
+	"github.com/philippgille/gokv"
+)
+
+// ContextStore is a gokv.Store whose operations can be cancelled
+// and given deadlines with a context.
+type ContextStore interface {
+	gokv.Store
+	// SetContext stores the given value for the given key.
+	SetContext(ctx context.Context, k string, v interface{}) error
+	// GetContext retrieves the stored value for the given key.
+	GetContext(ctx context.Context, k string, v interface{}) (found bool, err error)
+	// DeleteContext deletes the stored value for the given key.
+	DeleteContext(ctx context.Context, k string) error
+}
+
+// WithContext returns the store as a ContextStore.
+// Stores that don't implement ContextStore are wrapped, and the wrapper
+// only honors cancellation before an operation starts, because a plain
+// gokv.Store operation can't be interrupted.
+func WithContext(s gokv.Store) ContextStore {
+	if cs, ok := s.(ContextStore); ok {
+		return cs
+	}
+	return contextStore{s}
+}
+
+type contextStore struct {
+	gokv.Store
+}
+
+func (s contextStore) SetContext(ctx context.Context, k string, v interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.Set(k, v)
+}
+
+func (s contextStore) GetContext(ctx context.Context, k string, v interface{}) (found bool, err error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	return s.Get(k, v)
+}
+
+func (s contextStore) DeleteContext(ctx context.Context, k string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.Delete(k)
+}
@@ -0,0 +1,105 @@
+package kv_test
+
+import (
+	"context"
+	"databases/kv"
+	"databases/memory"
+	"databases/storetest"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/philippgille/gokv"
+)
+
+func newMemoryStore(t *testing.T) memory.Store {
+	store, err := memory.NewStore(&memory.DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// plainStore hides the ContextStore methods of the store it wraps.
+type plainStore struct {
+	gokv.Store
+}
+
+func TestWithContext(t *testing.T) {
+	storetest.TestStore(t, func(t *testing.T) gokv.Store {
+		return kv.WithContext(plainStore{newMemoryStore(t)})
+	})
+}
+
+func TestWithBatch(t *testing.T) {
+	storetest.TestStore(t, func(t *testing.T) gokv.Store {
+		return kv.WithBatch(plainStore{newMemoryStore(t)})
+	})
+}
+
+func TestWithContextPassesThrough(t *testing.T) {
+	store := newMemoryStore(t)
+	defer store.Close()
+
+	if _, ok := kv.WithContext(store).(memory.Store); !ok {
+		t.Error("WithContext wrapped a store that already implements ContextStore")
+	}
+}
+
+func TestWithContextCancelled(t *testing.T) {
+	store := kv.WithContext(plainStore{newMemoryStore(t)})
+	defer store.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := store.SetContext(ctx, "sen1", "v"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("SetContext returned %v, want %v", err, context.Canceled)
+	}
+	found, err := store.Get("sen1", new(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found {
+		t.Error("SetContext with a cancelled context stored the value")
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	now := time.Now()
+	for _, deadline := range []time.Time{{}, now.Add(time.Minute), now.Add(-time.Minute)} {
+		data := kv.WithExpiry([]byte("value"), deadline)
+		got, value, err := kv.SplitExpiry(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equal(deadline) {
+			t.Errorf("SplitExpiry returned deadline %v, want %v", got, deadline)
+		}
+		if string(value) != "value" {
+			t.Errorf("SplitExpiry returned value %q, want %q", value, "value")
+		}
+		if want := deadline.Before(now) && !deadline.IsZero(); kv.Expired(got, now) != want {
+			t.Errorf("Expired(%v) = %v, want %v", got, !want, want)
+		}
+	}
+
+	// Values without a deadline are stored as they are, as before stores
+	// had TTLs, unless they could be mistaken for a header.
+	for _, value := range []string{"", "value", "\xffkv\x01", "\xffkv\x01value"} {
+		data := kv.WithExpiry([]byte(value), time.Time{})
+		if (len(data) == len(value)) != !strings.HasPrefix(value, "\xffkv\x01") {
+			t.Errorf("WithExpiry(%q) without a deadline returned %q", value, data)
+		}
+		got, v, err := kv.SplitExpiry(data)
+		if err != nil || !got.IsZero() || string(v) != value {
+			t.Errorf("SplitExpiry(%q) returned %v, %q, %v", data, got, v, err)
+		}
+	}
+	if _, _, err := kv.SplitExpiry([]byte("\xffkv\x01short")); err != kv.ErrExpiryHeader {
+		t.Errorf("SplitExpiry of a truncated header returned %v, want %v", err, kv.ErrExpiryHeader)
+	}
+	if !kv.Deadline(0).IsZero() {
+		t.Error("Deadline(0) isn't the zero time")
+	}
+}
@@ -0,0 +1,126 @@
+package kv
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/philippgille/gokv"
+	"github.com/philippgille/gokv/encoding"
+)
+
+// ErrNotSupported is returned by stores that can't provide an operation,
+// like a scan of a cache that doesn't keep its keys in any order.
+var ErrNotSupported = errors.New("kv: operation not supported by this store")
+
+// Iterator iterates over key-value pairs in ascending key order.
+//
+//	it, err := store.Scan("sen", "", "")
+//	if err != nil {
+//		return err
+//	}
+//	defer it.Close()
+//	for it.Next() {
+//		var v Value
+//		if err := it.Value(&v); err != nil {
+//			return err
+//		}
+//		fmt.Println(it.Key(), v)
+//	}
+//	return it.Err()
+type Iterator interface {
+	// Next advances to the next pair and reports whether there is one.
+	Next() bool
+	// Key returns the key of the current pair.
+	Key() string
+	// Value decodes the value of the current pair into v.
+	Value(v interface{}) error
+	// Err returns the error that ended the iteration, if any.
+	Err() error
+	// Close releases the resources of the iterator.
+	Close() error
+}
+
+// Scanner is a gokv.Store that can iterate over its keys in order.
+type Scanner interface {
+	gokv.Store
+	// Scan returns an iterator over the keys that start with prefix
+	// and lie within [start, end). An empty start or end leaves
+	// the range unbounded on that side.
+	// Stores that can't iterate return ErrNotSupported.
+	Scan(prefix, start, end string) (Iterator, error)
+}
+
+// Bounds returns the smallest range [lo, hi) that contains every key
+// matching the prefix, start and end of a scan. An empty hi means the
+// range is unbounded above.
+func Bounds(prefix, start, end string) (lo, hi string) {
+	lo, hi = start, end
+	if prefix > lo {
+		lo = prefix
+	}
+	if after := prefixEnd(prefix); after != "" && (hi == "" || after < hi) {
+		hi = after
+	}
+	return lo, hi
+}
+
+// prefixEnd returns the first key after all keys that start with prefix,
+// or "" if there is none.
+func prefixEnd(prefix string) string {
+	b := []byte(prefix)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < 0xff {
+			b[i]++
+			return string(b[:i+1])
+		}
+	}
+	return ""
+}
+
+// InRange reports whether k matches the prefix, start and end of a scan.
+func InRange(k, prefix, start, end string) bool {
+	return strings.HasPrefix(k, prefix) && k >= start && (end == "" || k < end)
+}
+
+// Pair is a key and its encoded value.
+type Pair struct {
+	Key   string
+	Value []byte
+}
+
+// NewSliceIterator returns an Iterator over pairs, which must be sorted
+// by key, that decodes the values with codec. It's for stores that have
+// to collect the result of a scan up front.
+func NewSliceIterator(pairs []Pair, codec encoding.Codec) Iterator {
+	return &sliceIterator{pairs: pairs, codec: codec, i: -1}
+}
+
+type sliceIterator struct {
+	pairs []Pair
+	codec encoding.Codec
+	i     int
+}
+
+func (it *sliceIterator) Next() bool {
+	if it.i < len(it.pairs) {
+		it.i++
+	}
+	return it.i < len(it.pairs)
+}
+
+func (it *sliceIterator) Key() string {
+	return it.pairs[it.i].Key
+}
+
+func (it *sliceIterator) Value(v interface{}) error {
+	return it.codec.Unmarshal(it.pairs[it.i].Value, v)
+}
+
+func (it *sliceIterator) Err() error {
+	return nil
+}
+
+func (it *sliceIterator) Close() error {
+	it.pairs = nil
+	return nil
+}
@@ -0,0 +1,80 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/philippgille/gokv"
+)
+
+// TTLStore is a gokv.Store whose values can expire.
+type TTLStore interface {
+	gokv.Store
+	// SetWithTTL stores the given value for the given key.
+	// The value expires after ttl; a ttl <= 0 means it never expires.
+	// A later Set of the same key removes the expiry.
+	SetWithTTL(k string, v interface{}, ttl time.Duration) error
+}
+
+// ErrExpiryHeader is returned when a value starts like
+// the header written by WithExpiry, but is too short for it.
+var ErrExpiryHeader = errors.New("kv: value has a truncated expiry header")
+
+// expiryMagic starts the header written by WithExpiry. Its last byte is
+// the version of the header. Values without a deadline that start with
+// it get a header without one, so they aren't misread.
+const expiryMagic = "\xffkv\x01"
+
+// expiryLen is the length of the header written by WithExpiry.
+const expiryLen = len(expiryMagic) + 8
+
+// Deadline returns the time at which a value stored with the given ttl
+// expires, or the zero time if ttl <= 0.
+func Deadline(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+// Expired reports whether a value with the given deadline has expired at now.
+// The zero deadline never expires.
+func Expired(deadline, now time.Time) bool {
+	return !deadline.IsZero() && !now.Before(deadline)
+}
+
+// WithExpiry prefixes data with the deadline after which it expires.
+// It's the emulated expiry of stores without native TTL support.
+// Only values with a deadline get the header, so values without one are
+// stored as they are, as before stores had TTLs, unless they start like
+// the header themselves.
+func WithExpiry(data []byte, deadline time.Time) []byte {
+	if deadline.IsZero() && !bytes.HasPrefix(data, []byte(expiryMagic)) {
+		return data
+	}
+	b := make([]byte, expiryLen+len(data))
+	copy(b, expiryMagic)
+	if !deadline.IsZero() {
+		binary.BigEndian.PutUint64(b[len(expiryMagic):], uint64(deadline.UnixNano()))
+	}
+	copy(b[expiryLen:], data)
+	return b
+}
+
+// SplitExpiry splits data written by WithExpiry into its deadline
+// and the value. The value shares its memory with data.
+// Values without the header never expire.
+func SplitExpiry(data []byte) (deadline time.Time, value []byte, err error) {
+	if !bytes.HasPrefix(data, []byte(expiryMagic)) {
+		return time.Time{}, data, nil
+	}
+	if len(data) < expiryLen {
+		return time.Time{}, nil, ErrExpiryHeader
+	}
+	if ns := binary.BigEndian.Uint64(data[len(expiryMagic):]); ns != 0 {
+		deadline = time.Unix(0, int64(ns))
+	}
+	return deadline, data[expiryLen:], nil
+}
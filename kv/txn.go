@@ -0,0 +1,145 @@
+package kv
+
+import (
+	"errors"
+	"time"
+
+	"github.com/philippgille/gokv"
+	"github.com/philippgille/gokv/encoding"
+	"github.com/philippgille/gokv/util"
+)
+
+var (
+	// ErrConflict is returned by Update when the transaction conflicted
+	// with a concurrent write and was rolled back. The caller may retry it.
+	ErrConflict = errors.New("kv: transaction conflict")
+	// ErrReadOnly is returned by writes in a View transaction.
+	ErrReadOnly = errors.New("kv: transaction is read-only")
+)
+
+// Txn reads and writes the keys of a store within a transaction,
+// encoding the values with the store's Codec.
+type Txn interface {
+	// Get retrieves the value for the given key, including
+	// the writes of the transaction itself.
+	Get(k string, v interface{}) (found bool, err error)
+	// Set stores the given value for the given key.
+	Set(k string, v interface{}) error
+	// Delete deletes the value for the given key.
+	Delete(k string) error
+}
+
+// TTLTxn is a Txn of a TTLStore, whose writes can expire.
+type TTLTxn interface {
+	Txn
+	// SetWithTTL stores the given value for the given key.
+	// The value expires after ttl; a ttl <= 0 means it never expires.
+	SetWithTTL(k string, v interface{}, ttl time.Duration) error
+}
+
+// Transactional is a gokv.Store that can run multi-key transactions.
+type Transactional interface {
+	gokv.Store
+	// Update runs fn in a read-write transaction. The transaction is
+	// committed if fn returns nil and rolled back if it returns an error.
+	Update(fn func(tx Txn) error) error
+	// View runs fn in a read-only transaction.
+	View(fn func(tx Txn) error) error
+}
+
+// BufferedTxn is a TTLTxn that buffers its writes until they are committed.
+// It's for stores without native transactions, which read the keys
+// that aren't in the buffer themselves and apply the buffer, with the
+// Deadline of every value, at commit.
+type BufferedTxn struct {
+	codec    encoding.Codec
+	read     func(k string) (data []byte, found bool, err error)
+	readOnly bool
+	// writes holds the encoded values, or nil for deleted keys.
+	writes map[string][]byte
+	// deadlines holds the deadlines of the values set with a TTL.
+	deadlines map[string]time.Time
+}
+
+// NewBufferedTxn creates a BufferedTxn that reads the keys that aren't
+// in its buffer with read. The writes of a read-only BufferedTxn fail
+// with ErrReadOnly.
+func NewBufferedTxn(codec encoding.Codec, read func(k string) (data []byte, found bool, err error), readOnly bool) *BufferedTxn {
+	return &BufferedTxn{
+		codec:     codec,
+		read:      read,
+		readOnly:  readOnly,
+		writes:    make(map[string][]byte),
+		deadlines: make(map[string]time.Time),
+	}
+}
+
+// Get retrieves the value for the given key.
+func (t *BufferedTxn) Get(k string, v interface{}) (found bool, err error) {
+	if err := util.CheckKeyAndValue(k, v); err != nil {
+		return false, err
+	}
+	data, ok := t.writes[k]
+	if !ok {
+		if data, ok, err = t.read(k); err != nil || !ok {
+			return false, err
+		}
+	} else if data == nil {
+		return false, nil
+	}
+	return true, t.codec.Unmarshal(data, v)
+}
+
+// Set stores the given value for the given key in the buffer.
+func (t *BufferedTxn) Set(k string, v interface{}) error {
+	if err := util.CheckKeyAndValue(k, v); err != nil {
+		return err
+	}
+	if t.readOnly {
+		return ErrReadOnly
+	}
+	data, err := t.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	t.writes[k] = data
+	delete(t.deadlines, k)
+	return nil
+}
+
+// SetWithTTL stores the given value for the given key in the buffer.
+// The value expires after ttl; a ttl <= 0 means it never expires.
+func (t *BufferedTxn) SetWithTTL(k string, v interface{}, ttl time.Duration) error {
+	if err := t.Set(k, v); err != nil {
+		return err
+	}
+	if ttl > 0 {
+		t.deadlines[k] = Deadline(ttl)
+	}
+	return nil
+}
+
+// Delete records the deletion of the given key in the buffer.
+func (t *BufferedTxn) Delete(k string) error {
+	if err := util.CheckKey(k); err != nil {
+		return err
+	}
+	if t.readOnly {
+		return ErrReadOnly
+	}
+	t.writes[k] = nil
+	delete(t.deadlines, k)
+	return nil
+}
+
+// Writes returns the buffered writes: the encoded value of every key
+// that was set and nil for every key that was deleted.
+func (t *BufferedTxn) Writes() map[string][]byte {
+	return t.writes
+}
+
+// Deadline returns the time at which the buffered value of k expires,
+// or the zero time if it doesn't.
+func (t *BufferedTxn) Deadline(k string) time.Time {
+	return t.deadlines[k]
+}
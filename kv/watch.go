@@ -0,0 +1,215 @@
+package kv
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/philippgille/gokv"
+)
+
+// EventType is the kind of change an Event reports.
+type EventType int
+
+// The event types.
+const (
+	// EventSet reports that a value was set.
+	EventSet EventType = iota + 1
+	// EventDelete reports that a value was deleted.
+	EventDelete
+	// EventExpire reports that a value expired.
+	EventExpire
+)
+
+var eventTypeNames = map[EventType]string{
+	EventSet:    "set",
+	EventDelete: "delete",
+	EventExpire: "expire",
+}
+
+func (t EventType) String() string {
+	if name, ok := eventTypeNames[t]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Event is a change of the value of a key.
+type Event struct {
+	Type EventType
+	Key  string
+	// Value is the new value, encoded with the store's Codec,
+	// for EventSet and nil otherwise.
+	Value []byte
+}
+
+// Watcher is a gokv.Store that reports changes of its values.
+type Watcher interface {
+	gokv.Store
+	// Watch returns a channel that receives an Event for every change
+	// of a key that starts with prefix, in the order of the changes,
+	// and a function that stops watching and closes the channel.
+	// Writes wait for watchers that don't keep up with their events.
+	Watch(prefix string) (<-chan Event, func())
+}
+
+// WatchBuffer is the number of events a watcher's channel buffers.
+const WatchBuffer = 64
+
+// Broker delivers events to the watchers of key prefixes, and publishes
+// EventExpire when the deadline of a value that wasn't changed since passes.
+// It's the basis of Watch for stores that learn about their changes
+// themselves.
+// Publishers must publish the changes of a key in the order they were
+// made, for example by holding a lock of the key while they write and
+// publish, as concurrent Publish calls of a key are delivered in any order.
+type Broker struct {
+	mu       sync.Mutex
+	watchers map[*watcher]struct{}
+	timers   map[string]*time.Timer
+	closed   bool
+}
+
+type watcher struct {
+	prefix string
+	ch     chan Event
+	done   chan struct{}
+	// mu is held while sending, so that the channel isn't closed meanwhile.
+	mu   sync.Mutex
+	once sync.Once
+}
+
+// NewBroker creates a Broker without watchers.
+func NewBroker() *Broker {
+	return &Broker{
+		watchers: make(map[*watcher]struct{}),
+		timers:   make(map[string]*time.Timer),
+	}
+}
+
+// Watch implements Watcher.Watch. The channel of a closed Broker
+// is closed right away.
+func (b *Broker) Watch(prefix string) (<-chan Event, func()) {
+	w := &watcher{
+		prefix: prefix,
+		ch:     make(chan Event, WatchBuffer),
+		done:   make(chan struct{}),
+	}
+	b.mu.Lock()
+	if b.closed {
+		close(w.ch)
+		b.mu.Unlock()
+		return w.ch, func() {}
+	}
+	b.watchers[w] = struct{}{}
+	b.mu.Unlock()
+
+	return w.ch, func() {
+		b.mu.Lock()
+		delete(b.watchers, w)
+		b.mu.Unlock()
+		w.stop()
+	}
+}
+
+// stop ends pending sends and closes the channel.
+func (w *watcher) stop() {
+	w.once.Do(func() {
+		close(w.done)
+		w.mu.Lock()
+		close(w.ch)
+		w.mu.Unlock()
+	})
+}
+
+// Watched reports whether anyone watches k, so that publishers
+// can skip encoding values nobody receives.
+func (b *Broker) Watched(k string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for w := range b.watchers {
+		if strings.HasPrefix(k, w.prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// PublishSet publishes an EventSet for k and, unless deadline is zero,
+// an EventExpire at the deadline if k isn't changed until then.
+func (b *Broker) PublishSet(k string, value []byte, deadline time.Time) {
+	b.mu.Lock()
+	b.stopTimer(k)
+	if !deadline.IsZero() && !b.closed {
+		var t *time.Timer
+		t = time.AfterFunc(time.Until(deadline), func() {
+			b.mu.Lock()
+			current := b.timers[k] == t
+			if current {
+				delete(b.timers, k)
+			}
+			b.mu.Unlock()
+			if current {
+				b.publish(Event{Type: EventExpire, Key: k})
+			}
+		})
+		b.timers[k] = t
+	}
+	b.mu.Unlock()
+	b.publish(Event{Type: EventSet, Key: k, Value: value})
+}
+
+// PublishDelete publishes an EventDelete for k.
+func (b *Broker) PublishDelete(k string) {
+	b.mu.Lock()
+	b.stopTimer(k)
+	b.mu.Unlock()
+	b.publish(Event{Type: EventDelete, Key: k})
+}
+
+// stopTimer cancels the pending EventExpire of k. b.mu must be held.
+func (b *Broker) stopTimer(k string) {
+	if t, ok := b.timers[k]; ok {
+		t.Stop()
+		delete(b.timers, k)
+	}
+}
+
+// publish sends e to the watchers of its key.
+func (b *Broker) publish(e Event) {
+	b.mu.Lock()
+	var ws []*watcher
+	for w := range b.watchers {
+		if strings.HasPrefix(e.Key, w.prefix) {
+			ws = append(ws, w)
+		}
+	}
+	b.mu.Unlock()
+	for _, w := range ws {
+		w.mu.Lock()
+		select {
+		case <-w.done:
+		default:
+			select {
+			case w.ch <- e:
+			case <-w.done:
+			}
+		}
+		w.mu.Unlock()
+	}
+}
+
+// Close stops all watchers and pending expiries.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	ws := b.watchers
+	b.watchers = make(map[*watcher]struct{})
+	for k := range b.timers {
+		b.stopTimer(k)
+	}
+	b.closed = true
+	b.mu.Unlock()
+	for w := range ws {
+		w.stop()
+	}
+}
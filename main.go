@@ -1,8 +1,11 @@
 package main
 
 import (
-	"databases/moss"
+	"databases/stores"
+	_ "databases/stores/all"
+	"flag"
 	"fmt"
+	"strings"
 
 	"github.com/philippgille/gokv"
 )
@@ -12,8 +15,19 @@ type insight struct {
 }
 
 func main() {
+	backend := flag.String("backend", "moss", "store to use: "+strings.Join(stores.Names(), ", "))
+	configFile := flag.String("config", "", "JSON or YAML file with the backend and its options, overriding -backend")
+	flag.Parse()
+
 	//Create client
-	client, err := moss.NewStore(nil)
+	cfg := stores.Config{Backend: *backend}
+	if *configFile != "" {
+		var err error
+		if cfg, err = stores.LoadConfig(*configFile); err != nil {
+			panic(err)
+		}
+	}
+	client, err := cfg.Open()
 	if err != nil {
 		panic(err)
 	}
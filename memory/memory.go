@@ -1,9 +1,15 @@
 package memory
 
 import (
-	"fmt"
+	"bytes"
+	"context"
+	"databases/kv"
+	"databases/stores"
+	"sort"
 	"sync"
+	"time"
 
+	"github.com/philippgille/gokv"
 	"github.com/philippgille/gokv/encoding"
 	"github.com/philippgille/gokv/util"
 )
@@ -13,10 +19,31 @@ type Store struct {
 	Db    map[string][]byte
 	Codec encoding.Codec
 	mu    *sync.RWMutex
+	// expires holds the deadlines of the keys set with a TTL.
+	expires map[string]time.Time
+	// sweepAt is the size of expires that triggers the next sweep.
+	sweepAt *int
 }
 
 // Set stores the given value for the given key.
 func (s Store) Set(k string, v interface{}) error {
+	return s.SetContext(context.Background(), k, v)
+}
+
+// SetContext stores the given value for the given key
+// unless the context is done before the value is written.
+func (s Store) SetContext(ctx context.Context, k string, v interface{}) error {
+	return s.set(ctx, k, v, time.Time{})
+}
+
+// SetWithTTL stores the given value for the given key.
+// The value expires after ttl; expired values are removed when they are
+// read, or by a later SetWithTTL once they're many.
+func (s Store) SetWithTTL(k string, v interface{}, ttl time.Duration) error {
+	return s.set(context.Background(), k, v, kv.Deadline(ttl))
+}
+
+func (s Store) set(ctx context.Context, k string, v interface{}, deadline time.Time) error {
 	if err := util.CheckKeyAndValue(k, v); err != nil {
 		return err
 	}
@@ -24,9 +51,13 @@ func (s Store) Set(k string, v interface{}) error {
 	if err != nil {
 		return err
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
 	s.mu.Lock()
 	s.Db[k] = data
+	s.setDeadline(k, deadline)
 	s.mu.Unlock()
 
 	return nil
@@ -35,40 +66,291 @@ func (s Store) Set(k string, v interface{}) error {
 
 // Get retrieves the stored value for the given key.
 func (s Store) Get(k string, v interface{}) (found bool, err error) {
+	return s.GetContext(context.Background(), k, v)
+}
+
+// GetContext retrieves the stored value for the given key
+// unless the context is done before the value is read.
+func (s Store) GetContext(ctx context.Context, k string, v interface{}) (found bool, err error) {
 	if err := util.CheckKeyAndValue(k, v); err != nil {
 		return false, err
 	}
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
 
 	s.mu.RLock()
 	data, ok := s.Db[k]
-	s.mu.Unlock()
+	deadline := s.expires[k]
+	s.mu.RUnlock()
 	if !ok {
 		return false, nil
 	}
+	if kv.Expired(deadline, time.Now()) {
+		s.expire(k)
+		return false, nil
+	}
 
 	return true, s.Codec.Unmarshal(data, v)
 }
 
 // Delete deletes the stored value for the given key.
 func (s Store) Delete(k string) error {
+	return s.DeleteContext(context.Background(), k)
+}
+
+// DeleteContext deletes the stored value for the given key
+// unless the context is done before the value is deleted.
+func (s Store) DeleteContext(ctx context.Context, k string) error {
 	if err := util.CheckKey(k); err != nil {
 		return err
 	}
-	if _, ok := s.Db[k]; ok {
-		s.mu.Lock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	delete(s.Db, k)
+	delete(s.expires, k)
+	s.mu.Unlock()
+	return nil
+}
+
+// SetMany stores the given values for their keys
+// while holding the lock once.
+func (s Store) SetMany(values map[string]interface{}) error {
+	data := make(map[string][]byte, len(values))
+	for k, v := range values {
+		if err := util.CheckKeyAndValue(k, v); err != nil {
+			return err
+		}
+		var err error
+		if data[k], err = s.Codec.Marshal(v); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	for k, d := range data {
+		s.Db[k] = d
+		delete(s.expires, k)
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// GetMany retrieves the stored values for the given keys
+// while holding the lock once.
+func (s Store) GetMany(keys []string, vs []interface{}) (found []bool, err error) {
+	if len(keys) != len(vs) {
+		return nil, kv.ErrLengthMismatch
+	}
+	for i, k := range keys {
+		if err := util.CheckKeyAndValue(k, vs[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	data := make([][]byte, len(keys))
+	s.mu.RLock()
+	for i, k := range keys {
+		if d, ok := s.Db[k]; ok && !kv.Expired(s.expires[k], now) {
+			data[i] = d
+		}
+	}
+	s.mu.RUnlock()
+
+	found = make([]bool, len(keys))
+	for i, d := range data {
+		if d == nil {
+			continue
+		}
+		if err := s.Codec.Unmarshal(d, vs[i]); err != nil {
+			return nil, err
+		}
+		found[i] = true
+	}
+	return found, nil
+}
+
+// DeleteMany deletes the stored values for the given keys
+// while holding the lock once.
+func (s Store) DeleteMany(keys []string) error {
+	for _, k := range keys {
+		if err := util.CheckKey(k); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	for _, k := range keys {
 		delete(s.Db, k)
-		s.mu.Unlock()
-		return nil
+		delete(s.expires, k)
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// Update runs fn in an optimistic read-write transaction.
+// The writes of fn are buffered and applied at commit, unless a value
+// that fn read was changed in the meantime; then Update returns
+// kv.ErrConflict and the caller may retry.
+func (s Store) Update(fn func(tx kv.Txn) error) error {
+	return s.run(fn, false)
+}
+
+// View runs fn in an optimistic read-only transaction.
+// It returns kv.ErrConflict if a value that fn read was changed
+// before fn returned, because fn may then have seen an inconsistent state.
+func (s Store) View(fn func(tx kv.Txn) error) error {
+	return s.run(fn, true)
+}
+
+// read is a value read by a transaction.
+type read struct {
+	data  []byte
+	found bool
+}
+
+func (s Store) run(fn func(tx kv.Txn) error, readOnly bool) error {
+	reads := make(map[string]read)
+	tx := kv.NewBufferedTxn(s.Codec, func(k string) ([]byte, bool, error) {
+		if r, ok := reads[k]; ok {
+			return r.data, r.found, nil
+		}
+		data, found := s.lookup(k)
+		reads[k] = read{data: data, found: found}
+		return data, found, nil
+	}, readOnly)
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for k, r := range reads {
+		data, found := s.Db[k]
+		if found && kv.Expired(s.expires[k], now) {
+			found = false
+		}
+		if found != r.found || !bytes.Equal(data, r.data) {
+			return kv.ErrConflict
+		}
+	}
+	for k, data := range tx.Writes() {
+		if data == nil {
+			delete(s.Db, k)
+		} else {
+			s.Db[k] = data
+		}
+		s.setDeadline(k, tx.Deadline(k))
+	}
+	return nil
+}
 
+// lookup returns the unexpired value of k.
+func (s Store) lookup(k string) (data []byte, found bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data, found = s.Db[k]
+	if !found || kv.Expired(s.expires[k], time.Now()) {
+		return nil, false
 	}
-	return fmt.Errorf("Key not found: %v", k)
+	return data, true
+}
+
+// CompareAndSwap stores new for k if the stored value equals old
+// and reports whether it did.
+func (s Store) CompareAndSwap(k string, old, new interface{}) (swapped bool, err error) {
+	return kv.CompareAndSwap(s.modify, s.Codec, k, old, new)
+}
 
+// SetIfAbsent stores v for k if no value is stored for it
+// and reports whether it did.
+func (s Store) SetIfAbsent(k string, v interface{}) (set bool, err error) {
+	return kv.SetIfAbsent(s.modify, s.Codec, k, v)
+}
+
+// Increment adds delta to the int64 stored for k, or to 0 if none is,
+// and returns the result.
+func (s Store) Increment(k string, delta int64) (int64, error) {
+	return kv.Increment(s.modify, s.Codec, k, delta)
+}
+
+// modify implements kv.ModifyFunc while holding the write lock.
+func (s Store) modify(k string, fn func(data []byte, found bool) ([]byte, bool, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, found := s.Db[k]
+	if found && kv.Expired(s.expires[k], time.Now()) {
+		data, found = nil, false
+	}
+	newData, write, err := fn(data, found)
+	if err != nil || !write {
+		return err
+	}
+	s.Db[k] = newData
+	delete(s.expires, k)
+	return nil
+}
+
+// Scan returns an iterator over the keys that start with prefix
+// and lie within [start, end), in ascending order.
+// The map has no order, so the matching pairs are copied and sorted up front.
+func (s Store) Scan(prefix, start, end string) (kv.Iterator, error) {
+	now := time.Now()
+	var pairs []kv.Pair
+	s.mu.RLock()
+	for k, data := range s.Db {
+		if kv.InRange(k, prefix, start, end) && !kv.Expired(s.expires[k], now) {
+			pairs = append(pairs, kv.Pair{Key: k, Value: data})
+		}
+	}
+	s.mu.RUnlock()
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key < pairs[j].Key })
+	return kv.NewSliceIterator(pairs, s.Codec), nil
+}
+
+// setDeadline records the deadline of k, or that it has none, while
+// s.mu is held. Whenever the deadlines doubled, the values whose deadline
+// passed are deleted, so that values that are never read don't pile up.
+func (s Store) setDeadline(k string, deadline time.Time) {
+	if deadline.IsZero() {
+		delete(s.expires, k)
+		return
+	}
+	s.expires[k] = deadline
+	if len(s.expires) < *s.sweepAt {
+		return
+	}
+	now := time.Now()
+	for k, d := range s.expires {
+		if kv.Expired(d, now) {
+			delete(s.Db, k)
+			delete(s.expires, k)
+		}
+	}
+	*s.sweepAt = 2*len(s.expires) + 1024
+}
+
+// expire deletes k if it's still expired once the write lock is held,
+// because it might have been set again in the meantime.
+func (s Store) expire(k string) {
+	s.mu.Lock()
+	if kv.Expired(s.expires[k], time.Now()) {
+		delete(s.Db, k)
+		delete(s.expires, k)
+	}
+	s.mu.Unlock()
 }
 
 // Close closes the store.
 func (s Store) Close() error {
 	s.mu.Lock()
 	s.Db = nil
+	s.expires = nil
 	s.mu.Unlock()
 	return nil
 }
@@ -90,11 +372,25 @@ func NewStore(options *Options) (Store, error) {
 		options = &DefaultOptions
 	}
 	db := make(map[string][]byte)
+	sweepAt := 1024
 	result := Store{
-		Db:    db,
-		Codec: options.Codec,
-		mu:    &sync.RWMutex{},
+		Db:      db,
+		Codec:   options.Codec,
+		mu:      &sync.RWMutex{},
+		expires: make(map[string]time.Time),
+		sweepAt: &sweepAt,
 	}
 	return result, nil
 
 }
+
+// init registers the store as "memory" with package stores.
+func init() {
+	stores.Register("memory", func(cfg map[string]interface{}) (gokv.Store, error) {
+		options := DefaultOptions
+		if err := stores.DecodeOptions(cfg, &options); err != nil {
+			return nil, err
+		}
+		return NewStore(&options)
+	})
+}
@@ -1,10 +1,15 @@
 package memory
 
 import (
+	"databases/codec"
+	"databases/kv"
+	"databases/storetest"
 	"fmt"
 	"math/rand"
 	"testing"
 	"time"
+
+	"github.com/philippgille/gokv"
 )
 
 type NetworkStats struct {
@@ -38,6 +43,61 @@ var NS = NetworkStats{
 	},
 }
 
+func TestStore(t *testing.T) {
+	storetest.TestStore(t, func(t *testing.T) gokv.Store {
+		s, err := NewStore(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+// TestEmptyValues checks that empty values of codec.Raw are values.
+// TestSweep checks that expired values that are never read are removed.
+func TestSweep(t *testing.T) {
+	s, err := NewStore(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for i := 0; i < 10000; i++ {
+		if err := s.SetWithTTL(fmt.Sprintf("sen%d", i), i, time.Nanosecond); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.Db) > 2048 || len(s.expires) > 2048 {
+		t.Errorf("%d values and %d deadlines are left of 10000 expired ones", len(s.Db), len(s.expires))
+	}
+}
+
+func TestEmptyValues(t *testing.T) {
+	s, err := NewStore(&Options{Codec: codec.Raw})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Set("sen1", []byte{}); err != nil {
+		t.Fatal(err)
+	}
+	var v []byte
+	found, err := s.GetMany([]string{"sen1"}, []interface{}{&v})
+	if err != nil || !found[0] {
+		t.Errorf("GetMany got %v, %v", found, err)
+	}
+	err = s.Update(func(tx kv.Txn) error {
+		return tx.Set("sen2", []byte{})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found, err := s.Get("sen2", &v); err != nil || !found {
+		t.Errorf("got %v, %v after an empty value was set in a transaction", found, err)
+	}
+}
+
 func BenchmarkSet(b *testing.B) {
 	s, err := NewStore(nil)
 	if err != nil {
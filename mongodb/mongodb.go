@@ -0,0 +1,430 @@
+package mongodb
+
+import (
+	"context"
+	"databases/kv"
+	"databases/stores"
+	"errors"
+	"time"
+
+	"github.com/philippgille/gokv"
+	"github.com/philippgille/gokv/encoding"
+	"github.com/philippgille/gokv/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongooptions "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Store is a gokv.Store implementation for a MongoDB collection.
+// Every key is a document with the key as _id and the encoded value
+// as binary data.
+type Store struct {
+	Client     *mongo.Client
+	Collection *mongo.Collection
+	Codec      encoding.Codec
+}
+
+// document is the MongoDB document of a key.
+type document struct {
+	Key   string `bson:"_id"`
+	Value []byte `bson:"value"`
+	// Expires is the time the value expires at, if it has a TTL.
+	// MongoDB removes expired documents with a TTL index, but only
+	// about once a minute, so they are also skipped when read.
+	Expires time.Time `bson:"expires,omitempty"`
+}
+
+func (d document) expired(now time.Time) bool {
+	return kv.Expired(d.Expires, now)
+}
+
+// Set stores the given value for the given key.
+func (s Store) Set(k string, v interface{}) error {
+	return s.SetContext(context.Background(), k, v)
+}
+
+// SetContext stores the given value for the given key
+// unless the context is done before the value is written.
+func (s Store) SetContext(ctx context.Context, k string, v interface{}) error {
+	return s.set(ctx, k, v, time.Time{})
+}
+
+// SetWithTTL stores the given value for the given key.
+// The value expires after ttl; expired values are never returned
+// and are removed by MongoDB's TTL monitor.
+func (s Store) SetWithTTL(k string, v interface{}, ttl time.Duration) error {
+	return s.set(context.Background(), k, v, kv.Deadline(ttl))
+}
+
+func (s Store) set(ctx context.Context, k string, v interface{}, deadline time.Time) error {
+	doc, err := s.document(k, v, deadline)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	_, err = s.Collection.ReplaceOne(ctx, bson.M{"_id": k}, doc, mongooptions.Replace().SetUpsert(true))
+	return err
+}
+
+// document encodes v into the document of k.
+func (s Store) document(k string, v interface{}, deadline time.Time) (document, error) {
+	if err := util.CheckKeyAndValue(k, v); err != nil {
+		return document{}, err
+	}
+	data, err := s.Codec.Marshal(v)
+	if err != nil {
+		return document{}, err
+	}
+	return document{Key: k, Value: data, Expires: deadline}, nil
+}
+
+// Get retrieves the stored value for the given key.
+func (s Store) Get(k string, v interface{}) (found bool, err error) {
+	return s.GetContext(context.Background(), k, v)
+}
+
+// GetContext retrieves the stored value for the given key
+// unless the context is done before the value is read.
+func (s Store) GetContext(ctx context.Context, k string, v interface{}) (found bool, err error) {
+	if err := util.CheckKeyAndValue(k, v); err != nil {
+		return false, err
+	}
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	doc, found, err := s.lookup(ctx, k)
+	if err != nil || !found || doc.expired(time.Now()) {
+		return false, err
+	}
+	return true, s.Codec.Unmarshal(doc.Value, v)
+}
+
+// lookup reads the document of k, even if it expired.
+func (s Store) lookup(ctx context.Context, k string) (doc document, found bool, err error) {
+	err = s.Collection.FindOne(ctx, bson.M{"_id": k}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return doc, false, nil
+	}
+	return doc, err == nil, err
+}
+
+// Delete deletes the stored value for the given key.
+func (s Store) Delete(k string) error {
+	return s.DeleteContext(context.Background(), k)
+}
+
+// DeleteContext deletes the stored value for the given key
+// unless the context is done before the value is deleted.
+func (s Store) DeleteContext(ctx context.Context, k string) error {
+	if err := util.CheckKey(k); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	_, err := s.Collection.DeleteOne(ctx, bson.M{"_id": k})
+	return err
+}
+
+// SetMany stores the given values for their keys with a single bulk write.
+func (s Store) SetMany(values map[string]interface{}) error {
+	models := make([]mongo.WriteModel, 0, len(values))
+	for k, v := range values {
+		doc, err := s.document(k, v, time.Time{})
+		if err != nil {
+			return err
+		}
+		models = append(models, mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"_id": k}).SetReplacement(doc).SetUpsert(true))
+	}
+	if len(models) == 0 {
+		return nil
+	}
+	_, err := s.Collection.BulkWrite(context.Background(), models, mongooptions.BulkWrite().SetOrdered(false))
+	return err
+}
+
+// GetMany retrieves the stored values for the given keys with a single query.
+func (s Store) GetMany(keys []string, vs []interface{}) (found []bool, err error) {
+	if len(keys) != len(vs) {
+		return nil, kv.ErrLengthMismatch
+	}
+	for i, k := range keys {
+		if err := util.CheckKeyAndValue(k, vs[i]); err != nil {
+			return nil, err
+		}
+	}
+	found = make([]bool, len(keys))
+	if len(keys) == 0 {
+		return found, nil
+	}
+
+	ctx := context.Background()
+	cur, err := s.Collection.Find(ctx, bson.M{"_id": bson.M{"$in": keys}})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	docs := make(map[string]document, len(keys))
+	now := time.Now()
+	for cur.Next(ctx) {
+		var doc document
+		if err := cur.Decode(&doc); err != nil {
+			return nil, err
+		}
+		if !doc.expired(now) {
+			docs[doc.Key] = doc
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+	for i, k := range keys {
+		doc, ok := docs[k]
+		if !ok {
+			continue
+		}
+		if err := s.Codec.Unmarshal(doc.Value, vs[i]); err != nil {
+			return nil, err
+		}
+		found[i] = true
+	}
+	return found, nil
+}
+
+// DeleteMany deletes the stored values for the given keys with a single query.
+func (s Store) DeleteMany(keys []string) error {
+	for _, k := range keys {
+		if err := util.CheckKey(k); err != nil {
+			return err
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	_, err := s.Collection.DeleteMany(context.Background(), bson.M{"_id": bson.M{"$in": keys}})
+	return err
+}
+
+// Scan returns an iterator over the keys that start with prefix
+// and lie within [start, end), in ascending order.
+// The documents are read from a cursor as the iterator advances.
+func (s Store) Scan(prefix, start, end string) (kv.Iterator, error) {
+	lo, hi := kv.Bounds(prefix, start, end)
+	rng := bson.M{"$gte": lo}
+	if hi != "" {
+		rng["$lt"] = hi
+	}
+	ctx := context.Background()
+	cur, err := s.Collection.Find(ctx, bson.M{"_id": rng}, mongooptions.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	return &iterator{cur: cur, codec: s.Codec, prefix: prefix, end: end, now: time.Now()}, nil
+}
+
+type iterator struct {
+	cur    *mongo.Cursor
+	codec  encoding.Codec
+	prefix string
+	end    string
+	now    time.Time
+	doc    document
+	err    error
+}
+
+func (it *iterator) Next() bool {
+	ctx := context.Background()
+	for it.err == nil && it.cur.Next(ctx) {
+		var doc document
+		if it.err = it.cur.Decode(&doc); it.err != nil {
+			return false
+		}
+		if !kv.InRange(doc.Key, it.prefix, "", it.end) {
+			return false
+		}
+		if !doc.expired(it.now) {
+			it.doc = doc
+			return true
+		}
+	}
+	if it.err == nil {
+		it.err = it.cur.Err()
+	}
+	return false
+}
+
+func (it *iterator) Key() string {
+	return it.doc.Key
+}
+
+func (it *iterator) Value(v interface{}) error {
+	return it.codec.Unmarshal(it.doc.Value, v)
+}
+
+func (it *iterator) Err() error {
+	return it.err
+}
+
+func (it *iterator) Close() error {
+	return it.cur.Close(context.Background())
+}
+
+// CompareAndSwap stores new for k if the stored value equals old
+// and reports whether it did.
+func (s Store) CompareAndSwap(k string, old, new interface{}) (swapped bool, err error) {
+	return kv.CompareAndSwap(s.modify, s.Codec, k, old, new)
+}
+
+// SetIfAbsent stores v for k if no value is stored for it
+// and reports whether it did.
+func (s Store) SetIfAbsent(k string, v interface{}) (set bool, err error) {
+	return kv.SetIfAbsent(s.modify, s.Codec, k, v)
+}
+
+// Increment adds delta to the int64 stored for k, or to 0 if none is,
+// and returns the result.
+func (s Store) Increment(k string, delta int64) (int64, error) {
+	return kv.Increment(s.modify, s.Codec, k, delta)
+}
+
+// modify implements kv.ModifyFunc optimistically: the new document
+// only replaces the one that was read if it is still unchanged,
+// and fn is run again otherwise.
+func (s Store) modify(k string, fn func(data []byte, found bool) ([]byte, bool, error)) error {
+	ctx := context.Background()
+	for {
+		doc, exists, err := s.lookup(ctx, k)
+		if err != nil {
+			return err
+		}
+		found := exists && !doc.expired(time.Now())
+		var data []byte
+		if found {
+			data = doc.Value
+		}
+		newData, write, err := fn(data, found)
+		if err != nil || !write {
+			return err
+		}
+
+		newDoc := document{Key: k, Value: newData}
+		if !exists {
+			_, err := s.Collection.InsertOne(ctx, newDoc)
+			if isDuplicateKey(err) {
+				continue
+			}
+			return err
+		}
+		filter := bson.D{{Key: "_id", Value: k}, {Key: "value", Value: doc.Value}}
+		if doc.Expires.IsZero() {
+			filter = append(filter, bson.E{Key: "expires", Value: bson.M{"$exists": false}})
+		} else {
+			filter = append(filter, bson.E{Key: "expires", Value: doc.Expires})
+		}
+		res, err := s.Collection.ReplaceOne(ctx, filter, newDoc)
+		if err != nil {
+			return err
+		}
+		if res.MatchedCount == 1 {
+			return nil
+		}
+	}
+}
+
+// isDuplicateKey reports whether err is the error of an insert
+// of an _id that already exists.
+func isDuplicateKey(err error) bool {
+	var we mongo.WriteException
+	if !errors.As(err, &we) {
+		return false
+	}
+	for _, e := range we.WriteErrors {
+		if e.Code == 11000 {
+			return true
+		}
+	}
+	return false
+}
+
+// Close disconnects the client from the server.
+func (s Store) Close() error {
+	return s.Client.Disconnect(context.Background())
+}
+
+// Options are the options for the MongoDB store.
+type Options struct {
+	// Connection string of the server, like "mongodb://localhost:27017".
+	URI string
+	// Name of the database.
+	Database string
+	// Name of the collection that holds the keys.
+	// Every store should have its own collection.
+	Collection string
+	// Timeout of connecting to the server in NewStore.
+	Timeout time.Duration
+	// Encoding format.
+	Codec encoding.Codec
+}
+
+// DefaultOptions is an Options object with default values.
+var DefaultOptions = Options{
+	URI:        "mongodb://localhost:27017",
+	Database:   "databases",
+	Collection: "store",
+	Timeout:    10 * time.Second,
+	Codec:      encoding.JSON,
+}
+
+// NewStore creates a MongoDB store, checks that the server answers
+// and creates the TTL index of the collection.
+func NewStore(options *Options) (Store, error) {
+	if options == nil {
+		options = &DefaultOptions
+	}
+
+	ctx := context.Background()
+	if options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.Timeout)
+		defer cancel()
+	}
+	client, err := mongo.Connect(ctx, mongooptions.Client().ApplyURI(options.URI))
+	if err != nil {
+		return Store{}, err
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		client.Disconnect(context.Background())
+		return Store{}, err
+	}
+	col := client.Database(options.Database).Collection(options.Collection)
+	_, err = col.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.M{"expires": 1},
+		Options: mongooptions.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		client.Disconnect(context.Background())
+		return Store{}, err
+	}
+	result := Store{
+		Client:     client,
+		Collection: col,
+		Codec:      options.Codec,
+	}
+
+	return result, nil
+}
+
+// init registers the store as "mongodb" with package stores.
+func init() {
+	stores.Register("mongodb", func(cfg map[string]interface{}) (gokv.Store, error) {
+		options := DefaultOptions
+		if err := stores.DecodeOptions(cfg, &options); err != nil {
+			return nil, err
+		}
+		return NewStore(&options)
+	})
+}
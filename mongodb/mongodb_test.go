@@ -0,0 +1,121 @@
+package mongodb
+
+import (
+	"context"
+	"databases/storetest"
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/philippgille/gokv"
+)
+
+type NetworkStats struct {
+	SensorID   string           `json:"sensor_id"`
+	Updated    time.Time        `json:"updated"`
+	Interfaces []InterfaceStats `json:"interfaces"`
+}
+type InterfaceStats struct {
+	Interface string `json:"interface_name"`
+	TxBytes   int64  `json:"tx_bytes"`
+	TxPackets int64  `json:"tx_packets"`
+	TxErrors  int64  `json:"tx_errors"`
+	RxBytes   int64  `json:"rx_bytes"`
+	RxPackets int64  `json:"rx_packets"`
+	RxErrors  int64  `json:"rx_errors"`
+}
+
+var NS = NetworkStats{
+	SensorID: "ses1",
+	Updated:  time.Now(),
+	Interfaces: []InterfaceStats{
+		{
+			Interface: "eth0",
+			TxBytes:   123,
+			TxPackets: 345,
+			TxErrors:  234,
+			RxBytes:   566,
+			RxPackets: 12,
+			RxErrors:  12,
+		},
+	},
+}
+
+// newStore creates a store in a new collection of the server at
+// $MONGODB_URI, like mongodb://localhost:27017, and drops the
+// collection when the test ends. Without MONGODB_URI, the test is skipped.
+func newStore(tb testing.TB) Store {
+	uri := os.Getenv("MONGODB_URI")
+	if uri == "" {
+		tb.Skip("MONGODB_URI is not set")
+	}
+	ops := DefaultOptions
+	ops.URI = uri
+	ops.Collection = fmt.Sprintf("test%d", time.Now().UnixNano())
+	s, err := NewStore(&ops)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		if err := s.Collection.Drop(context.Background()); err != nil {
+			tb.Error(err)
+		}
+		s.Close()
+	})
+	return s
+}
+
+func TestStore(t *testing.T) {
+	if os.Getenv("MONGODB_URI") == "" {
+		t.Skip("MONGODB_URI is not set")
+	}
+	storetest.TestStore(t, func(t *testing.T) gokv.Store {
+		return newStore(t)
+	})
+}
+
+func BenchmarkSet(b *testing.B) {
+	s := newStore(b)
+	for i := 0; i < b.N; i++ {
+		key := fmt.Sprintf("sen%d", i)
+		s.Set(key, NS)
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	d := 1000
+	s := createStoreAndWriteNItems(b, d)
+
+	newdata := new(NetworkStats)
+	for i := 0; i < b.N; i++ {
+		j := rand.Intn(d)
+		k := fmt.Sprintf("sen%d", j)
+		if f, _ := s.Get(k, newdata); f != true {
+			fmt.Printf("Can not read data for the key:%v\n", k)
+		}
+	}
+}
+
+func BenchmarkDelete(b *testing.B) {
+	d := 1000
+	s := createStoreAndWriteNItems(b, d)
+
+	for i := 0; i < b.N; i++ {
+		j := rand.Intn(d)
+		k := fmt.Sprintf("sen%d", j)
+		if err := s.Delete(k); err != nil {
+			fmt.Printf("Can not delete key:%v", k)
+		}
+	}
+}
+
+func createStoreAndWriteNItems(tb testing.TB, items int) Store {
+	s := newStore(tb)
+	for i := 0; i < items; i++ {
+		k := fmt.Sprintf("sen%d", i)
+		s.Set(k, NS)
+	}
+	return s
+}
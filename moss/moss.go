@@ -1,20 +1,50 @@
 package moss
 
 import (
+	"context"
+	"databases/kv"
+	"databases/stores"
+	"sync"
+	"time"
+
 	"github.com/couchbase/moss"
+	"github.com/philippgille/gokv"
 	"github.com/philippgille/gokv/encoding"
 	"github.com/philippgille/gokv/util"
 )
 
 // Store is a gokv.Store implementation for moss.
+//
+// Its transactions and atomic operations CompareAndSwap, SetIfAbsent and
+// Increment are only isolated from each other, not from Set and Delete,
+// which write their batches directly so that they don't wait for each other.
 type Store struct {
 	Collection moss.Collection
-	Batch      moss.Batch
 	Codec      encoding.Codec
+	// mu serializes the transactions and atomic operations,
+	// so that they can read and write without interruption.
+	mu *sync.Mutex
 }
 
 // Set stores the given value for the given key.
 func (s Store) Set(k string, v interface{}) error {
+	return s.SetContext(context.Background(), k, v)
+}
+
+// SetContext stores the given value for the given key
+// unless the context is done before the value is written.
+func (s Store) SetContext(ctx context.Context, k string, v interface{}) error {
+	return s.set(ctx, k, v, time.Time{})
+}
+
+// SetWithTTL stores the given value for the given key.
+// The value expires after ttl; expired values are kept
+// until they are overwritten or deleted, but never returned.
+func (s Store) SetWithTTL(k string, v interface{}, ttl time.Duration) error {
+	return s.set(context.Background(), k, v, kv.Deadline(ttl))
+}
+
+func (s Store) set(ctx context.Context, k string, v interface{}, deadline time.Time) error {
 	if err := util.CheckKeyAndValue(k, v); err != nil {
 		return err
 	}
@@ -22,51 +52,380 @@ func (s Store) Set(k string, v interface{}) error {
 	if err != nil {
 		return err
 	}
-	if err := s.Batch.Set([]byte(k), data); err != nil {
+	data = kv.WithExpiry(data, deadline)
+	batch, err := s.Collection.NewBatch(1, len(k)+len(data))
+	if err != nil {
+		return err
+	}
+	defer batch.Close()
+	if err := batch.Set([]byte(k), data); err != nil {
 		return err
 	}
-	if err := s.Collection.ExecuteBatch(s.Batch, moss.WriteOptions{}); err != nil {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
-	return nil
+	return s.Collection.ExecuteBatch(batch, moss.WriteOptions{})
 }
 
 // Get retrieves the stored value for the given key.
 func (s Store) Get(k string, v interface{}) (found bool, err error) {
+	return s.GetContext(context.Background(), k, v)
+}
+
+// GetContext retrieves the stored value for the given key
+// unless the context is done before the value is read.
+func (s Store) GetContext(ctx context.Context, k string, v interface{}) (found bool, err error) {
 	if err := util.CheckKeyAndValue(k, v); err != nil {
 		return false, err
 	}
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
 	ropts := moss.ReadOptions{}
 	ss, err := s.Collection.Snapshot()
+	if err != nil {
+		return false, err
+	}
 	defer ss.Close()
 	data, err := ss.Get([]byte(k), ropts)
 	if err != nil || data == nil {
 		return false, err
 	}
+	deadline, data, err := kv.SplitExpiry(data)
+	if err != nil {
+		return false, err
+	}
+	if kv.Expired(deadline, time.Now()) {
+		return false, nil
+	}
 	return true, s.Codec.Unmarshal(data, v)
 }
 
 // Delete deletes the stored value for the given key.
 func (s Store) Delete(k string) error {
+	return s.DeleteContext(context.Background(), k)
+}
+
+// DeleteContext deletes the stored value for the given key
+// unless the context is done before the value is deleted.
+func (s Store) DeleteContext(ctx context.Context, k string) error {
 	if err := util.CheckKey(k); err != nil {
 		return err
 	}
-	if err := s.Batch.Del([]byte(k)); err != nil {
+	batch, err := s.Collection.NewBatch(1, len(k))
+	if err != nil {
+		return err
+	}
+	defer batch.Close()
+	if err := batch.Del([]byte(k)); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
 		return err
 	}
-	if err := s.Collection.ExecuteBatch(s.Batch, moss.WriteOptions{}); err != nil {
+	return s.Collection.ExecuteBatch(batch, moss.WriteOptions{})
+}
+
+// SetMany stores the given values for their keys
+// with a single batch.
+func (s Store) SetMany(values map[string]interface{}) error {
+	data := make(map[string][]byte, len(values))
+	size := 0
+	for k, v := range values {
+		if err := util.CheckKeyAndValue(k, v); err != nil {
+			return err
+		}
+		d, err := s.Codec.Marshal(v)
+		if err != nil {
+			return err
+		}
+		data[k] = kv.WithExpiry(d, time.Time{})
+		size += len(k) + len(data[k])
+	}
+
+	batch, err := s.Collection.NewBatch(len(data), size)
+	if err != nil {
 		return err
 	}
+	defer batch.Close()
+	for k, d := range data {
+		if err := batch.Set([]byte(k), d); err != nil {
+			return err
+		}
+	}
+	return s.Collection.ExecuteBatch(batch, moss.WriteOptions{})
+}
+
+// GetMany retrieves the stored values for the given keys
+// from a single snapshot.
+func (s Store) GetMany(keys []string, vs []interface{}) (found []bool, err error) {
+	if len(keys) != len(vs) {
+		return nil, kv.ErrLengthMismatch
+	}
+	for i, k := range keys {
+		if err := util.CheckKeyAndValue(k, vs[i]); err != nil {
+			return nil, err
+		}
+	}
 
-	return nil
+	ss, err := s.Collection.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer ss.Close()
+	now := time.Now()
+	found = make([]bool, len(keys))
+	for i, k := range keys {
+		data, err := ss.Get([]byte(k), moss.ReadOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if data == nil {
+			continue
+		}
+		deadline, data, err := kv.SplitExpiry(data)
+		if err != nil {
+			return nil, err
+		}
+		if kv.Expired(deadline, now) {
+			continue
+		}
+		if err := s.Codec.Unmarshal(data, vs[i]); err != nil {
+			return nil, err
+		}
+		found[i] = true
+	}
+	return found, nil
 }
 
-// Close closes the store.
-func (s Store) Close() error {
-	if err := s.Batch.Close(); err != nil {
+// DeleteMany deletes the stored values for the given keys
+// with a single batch.
+func (s Store) DeleteMany(keys []string) error {
+	size := 0
+	for _, k := range keys {
+		if err := util.CheckKey(k); err != nil {
+			return err
+		}
+		size += len(k)
+	}
+
+	batch, err := s.Collection.NewBatch(len(keys), size)
+	if err != nil {
+		return err
+	}
+	defer batch.Close()
+	for _, k := range keys {
+		if err := batch.Del([]byte(k)); err != nil {
+			return err
+		}
+	}
+	return s.Collection.ExecuteBatch(batch, moss.WriteOptions{})
+}
+
+// Update runs fn in a read-write transaction.
+// fn reads from a snapshot of the collection and its writes are applied
+// with a single atomic batch if it returns nil. Other transactions and
+// atomic operations are blocked while it runs, so transactions can't
+// conflict, but a concurrent Set of a key it read may be overwritten.
+func (s Store) Update(fn func(tx kv.Txn) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ss, err := s.Collection.Snapshot()
+	if err != nil {
+		return err
+	}
+	defer ss.Close()
+
+	tx := kv.NewBufferedTxn(s.Codec, s.reader(ss), false)
+	if err := fn(tx); err != nil {
+		return err
+	}
+	writes := tx.Writes()
+	if len(writes) == 0 {
+		return nil
+	}
+	size := 0
+	for k, data := range writes {
+		size += len(k) + len(data)
+	}
+	batch, err := s.Collection.NewBatch(len(writes), size)
+	if err != nil {
 		return err
 	}
-	return nil
+	defer batch.Close()
+	for k, data := range writes {
+		if data == nil {
+			err = batch.Del([]byte(k))
+		} else {
+			err = batch.Set([]byte(k), kv.WithExpiry(data, tx.Deadline(k)))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return s.Collection.ExecuteBatch(batch, moss.WriteOptions{})
+}
+
+// View runs fn in a read-only transaction that reads from
+// a snapshot of the collection.
+func (s Store) View(fn func(tx kv.Txn) error) error {
+	ss, err := s.Collection.Snapshot()
+	if err != nil {
+		return err
+	}
+	defer ss.Close()
+	return fn(kv.NewBufferedTxn(s.Codec, s.reader(ss), true))
+}
+
+// reader returns a function that reads the unexpired values of ss.
+func (s Store) reader(ss moss.Snapshot) func(k string) ([]byte, bool, error) {
+	return func(k string) ([]byte, bool, error) {
+		data, err := ss.Get([]byte(k), moss.ReadOptions{})
+		if err != nil || data == nil {
+			return nil, false, err
+		}
+		deadline, data, err := kv.SplitExpiry(data)
+		if err != nil || kv.Expired(deadline, time.Now()) {
+			return nil, false, err
+		}
+		return data, true, nil
+	}
+}
+
+// CompareAndSwap stores new for k if the stored value equals old
+// and reports whether it did.
+func (s Store) CompareAndSwap(k string, old, new interface{}) (swapped bool, err error) {
+	return kv.CompareAndSwap(s.modify, s.Codec, k, old, new)
+}
+
+// SetIfAbsent stores v for k if no value is stored for it
+// and reports whether it did.
+func (s Store) SetIfAbsent(k string, v interface{}) (set bool, err error) {
+	return kv.SetIfAbsent(s.modify, s.Codec, k, v)
+}
+
+// Increment adds delta to the int64 stored for k, or to 0 if none is,
+// and returns the result.
+func (s Store) Increment(k string, delta int64) (int64, error) {
+	return kv.Increment(s.modify, s.Codec, k, delta)
+}
+
+// modify implements kv.ModifyFunc while holding the lock of the
+// transactions.
+func (s Store) modify(k string, fn func(data []byte, found bool) ([]byte, bool, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ss, err := s.Collection.Snapshot()
+	if err != nil {
+		return err
+	}
+	defer ss.Close()
+	data, found, err := s.reader(ss)(k)
+	if err != nil {
+		return err
+	}
+	newData, write, err := fn(data, found)
+	if err != nil || !write {
+		return err
+	}
+	newData = kv.WithExpiry(newData, time.Time{})
+	batch, err := s.Collection.NewBatch(1, len(k)+len(newData))
+	if err != nil {
+		return err
+	}
+	defer batch.Close()
+	if err := batch.Set([]byte(k), newData); err != nil {
+		return err
+	}
+	return s.Collection.ExecuteBatch(batch, moss.WriteOptions{})
+}
+
+// Scan returns an iterator over the keys that start with prefix
+// and lie within [start, end), in ascending order.
+// The iterator reads from a snapshot of the collection.
+func (s Store) Scan(prefix, start, end string) (kv.Iterator, error) {
+	lo, hi := kv.Bounds(prefix, start, end)
+	ss, err := s.Collection.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	var endKey []byte
+	if hi != "" {
+		endKey = []byte(hi)
+	}
+	mit, err := ss.StartIterator([]byte(lo), endKey, moss.IteratorOptions{})
+	if err != nil {
+		ss.Close()
+		return nil, err
+	}
+	return &iterator{ss: ss, it: mit, codec: s.Codec, now: time.Now()}, nil
+}
+
+type iterator struct {
+	ss      moss.Snapshot
+	it      moss.Iterator
+	codec   encoding.Codec
+	now     time.Time
+	started bool
+	key     []byte
+	val     []byte
+	// err is moss.ErrIteratorDone once the iteration is done.
+	err error
+}
+
+func (it *iterator) Next() bool {
+	for it.err == nil {
+		if it.started {
+			if err := it.it.Next(); err != nil {
+				it.err = err
+				return false
+			}
+		}
+		it.started = true
+		key, data, err := it.it.Current()
+		if err != nil {
+			it.err = err
+			return false
+		}
+		deadline, val, err := kv.SplitExpiry(data)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		if !kv.Expired(deadline, it.now) {
+			it.key, it.val = key, val
+			return true
+		}
+	}
+	return false
+}
+
+func (it *iterator) Key() string {
+	return string(it.key)
+}
+
+func (it *iterator) Value(v interface{}) error {
+	return it.codec.Unmarshal(it.val, v)
+}
+
+func (it *iterator) Err() error {
+	if it.err == moss.ErrIteratorDone {
+		return nil
+	}
+	return it.err
+}
+
+func (it *iterator) Close() error {
+	err := it.it.Close()
+	if err2 := it.ss.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// Close closes the store.
+func (s Store) Close() error {
+	return s.Collection.Close()
 }
 
 // Options are the options for the moss store.
@@ -94,16 +453,28 @@ func NewStore(options *Options) (Store, error) {
 		return Store{}, err
 	}
 
-	batch, err := col.NewBatch(0, 0)
-	if err != nil {
+	// The collection's background merger must run, otherwise
+	// ExecuteBatch blocks once too many batches are pending.
+	if err := col.Start(); err != nil {
 		return Store{}, err
 	}
 
 	result := Store{
 		Collection: col,
-		Batch:      batch,
 		Codec:      options.Codec,
+		mu:         &sync.Mutex{},
 	}
 
 	return result, nil
 }
+
+// init registers the store as "moss" with package stores.
+func init() {
+	stores.Register("moss", func(cfg map[string]interface{}) (gokv.Store, error) {
+		options := DefaultOptions
+		if err := stores.DecodeOptions(cfg, &options); err != nil {
+			return nil, err
+		}
+		return NewStore(&options)
+	})
+}
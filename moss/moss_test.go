@@ -1,6 +1,7 @@
 package moss
 
 import (
+	"databases/storetest"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -9,6 +10,7 @@ import (
 	"time"
 
 	"github.com/couchbase/moss"
+	"github.com/philippgille/gokv"
 )
 
 type NetworkStats struct {
@@ -43,6 +45,16 @@ var NS = NetworkStats{
 	},
 }
 
+func TestStore(t *testing.T) {
+	storetest.TestStore(t, func(t *testing.T) gokv.Store {
+		s, err := NewStore(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
 func BenchmarkSet(b *testing.B) {
 	buf, _ := json.Marshal(NS)
 	writeOptions := moss.WriteOptions{}
@@ -1,6 +1,14 @@
 package nutsdb
 
 import (
+	"context"
+	"databases/kv"
+	"databases/stores"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/philippgille/gokv"
 	"github.com/philippgille/gokv/encoding"
 	"github.com/philippgille/gokv/util"
 	"github.com/xujiajun/nutsdb"
@@ -15,6 +23,31 @@ type Store struct {
 
 // Set stores the given value for the given key.
 func (s Store) Set(k string, v interface{}) error {
+	return s.SetContext(context.Background(), k, v)
+}
+
+// SetContext stores the given value for the given key
+// unless the context is done before the value is written.
+func (s Store) SetContext(ctx context.Context, k string, v interface{}) error {
+	return s.set(ctx, k, v, nutsdb.Persistent)
+}
+
+// SetWithTTL stores the given value for the given key.
+// The value expires after ttl, which nutsdb tracks in seconds,
+// so ttl is rounded up to the next whole second.
+func (s Store) SetWithTTL(k string, v interface{}, ttl time.Duration) error {
+	return s.set(context.Background(), k, v, ttlSeconds(ttl))
+}
+
+// ttlSeconds converts ttl to the TTL argument of nutsdb.Tx.Put.
+func ttlSeconds(ttl time.Duration) uint32 {
+	if ttl <= 0 {
+		return nutsdb.Persistent
+	}
+	return uint32((ttl + time.Second - 1) / time.Second)
+}
+
+func (s Store) set(ctx context.Context, k string, v interface{}, ttl uint32) error {
 	if err := util.CheckKeyAndValue(k, v); err != nil {
 		return err
 	}
@@ -23,8 +56,13 @@ func (s Store) Set(k string, v interface{}) error {
 		return err
 	}
 
+	// The transaction is rolled back instead of committed
+	// if the context is done in the meantime.
 	err = s.Db.Update(func(tx *nutsdb.Tx) error {
-		return tx.Put(s.Bucket, []byte(k), data, nutsdb.Persistent)
+		if err := tx.Put(s.Bucket, []byte(k), data, ttl); err != nil {
+			return err
+		}
+		return ctx.Err()
 	})
 	if err != nil {
 		return err
@@ -35,12 +73,21 @@ func (s Store) Set(k string, v interface{}) error {
 
 // Get retrieves the stored value for the given key.
 func (s Store) Get(k string, v interface{}) (found bool, err error) {
+	return s.GetContext(context.Background(), k, v)
+}
+
+// GetContext retrieves the stored value for the given key
+// unless the context is done before the value is read.
+func (s Store) GetContext(ctx context.Context, k string, v interface{}) (found bool, err error) {
 	if err := util.CheckKeyAndValue(k, v); err != nil {
 		return false, err
 	}
 
 	var data []byte
 	err = s.Db.View(func(tx *nutsdb.Tx) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		item, err := tx.Get(s.Bucket, []byte(k))
 		if err != nil {
 			return err
@@ -49,7 +96,7 @@ func (s Store) Get(k string, v interface{}) (found bool, err error) {
 		return nil
 	})
 	// If no value was found return false
-	if err == nutsdb.ErrKeyNotFound {
+	if isNotFound(err) {
 		return false, nil
 	} else if err != nil {
 		return false, err
@@ -60,15 +107,286 @@ func (s Store) Get(k string, v interface{}) (found bool, err error) {
 
 // Delete deletes the stored value for the given key.
 func (s Store) Delete(k string) error {
+	return s.DeleteContext(context.Background(), k)
+}
+
+// DeleteContext deletes the stored value for the given key
+// unless the context is done before the value is deleted.
+func (s Store) DeleteContext(ctx context.Context, k string) error {
 	if err := util.CheckKey(k); err != nil {
 		return err
 	}
 
 	return s.Db.Update(func(tx *nutsdb.Tx) error {
-		return tx.Delete(s.Bucket, []byte(k))
+		if err := tx.Delete(s.Bucket, []byte(k)); err != nil {
+			return err
+		}
+		return ctx.Err()
+	})
+}
+
+// SetMany stores the given values for their keys
+// in a single transaction.
+func (s Store) SetMany(values map[string]interface{}) error {
+	data := make(map[string][]byte, len(values))
+	for k, v := range values {
+		if err := util.CheckKeyAndValue(k, v); err != nil {
+			return err
+		}
+		var err error
+		if data[k], err = s.Codec.Marshal(v); err != nil {
+			return err
+		}
+	}
+
+	return s.Db.Update(func(tx *nutsdb.Tx) error {
+		for k, d := range data {
+			if err := tx.Put(s.Bucket, []byte(k), d, nutsdb.Persistent); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetMany retrieves the stored values for the given keys
+// in a single read-only transaction.
+func (s Store) GetMany(keys []string, vs []interface{}) (found []bool, err error) {
+	if len(keys) != len(vs) {
+		return nil, kv.ErrLengthMismatch
+	}
+	for i, k := range keys {
+		if err := util.CheckKeyAndValue(k, vs[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	data := make([][]byte, len(keys))
+	err = s.Db.View(func(tx *nutsdb.Tx) error {
+		for i, k := range keys {
+			item, err := tx.Get(s.Bucket, []byte(k))
+			if isNotFound(err) {
+				continue
+			} else if err != nil {
+				return err
+			}
+			data[i] = item.Value
+		}
+		return nil
+	})
+	if err != nil && !isNotFound(err) {
+		return nil, err
+	}
+
+	found = make([]bool, len(keys))
+	for i, d := range data {
+		if d == nil {
+			continue
+		}
+		if err := s.Codec.Unmarshal(d, vs[i]); err != nil {
+			return nil, err
+		}
+		found[i] = true
+	}
+	return found, nil
+}
+
+// DeleteMany deletes the stored values for the given keys
+// in a single transaction.
+func (s Store) DeleteMany(keys []string) error {
+	for _, k := range keys {
+		if err := util.CheckKey(k); err != nil {
+			return err
+		}
+	}
+
+	return s.Db.Update(func(tx *nutsdb.Tx) error {
+		for _, k := range keys {
+			if err := tx.Delete(s.Bucket, []byte(k)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Update runs fn in a read-write nutsdb transaction.
+// nutsdb allows a single read-write transaction at a time,
+// so transactions can't conflict.
+func (s Store) Update(fn func(tx kv.Txn) error) error {
+	return s.Db.Update(func(t *nutsdb.Tx) error {
+		return fn(tx{tx: t, bucket: s.Bucket, codec: s.Codec, writes: make(map[string][]byte)})
 	})
 }
 
+// View runs fn in a read-only nutsdb transaction.
+func (s Store) View(fn func(tx kv.Txn) error) error {
+	return s.Db.View(func(t *nutsdb.Tx) error {
+		return fn(tx{tx: t, bucket: s.Bucket, codec: s.Codec, readOnly: true})
+	})
+}
+
+// tx is a kv.TTLTxn for a nutsdb transaction.
+type tx struct {
+	tx       *nutsdb.Tx
+	bucket   string
+	codec    encoding.Codec
+	readOnly bool
+	// writes holds the values written by the transaction, or nil for
+	// deleted keys, because nutsdb only indexes them at commit.
+	writes map[string][]byte
+}
+
+func (t tx) Get(k string, v interface{}) (found bool, err error) {
+	if err := util.CheckKeyAndValue(k, v); err != nil {
+		return false, err
+	}
+	if data, ok := t.writes[k]; ok {
+		if data == nil {
+			return false, nil
+		}
+		return true, t.codec.Unmarshal(data, v)
+	}
+	item, err := t.tx.Get(t.bucket, []byte(k))
+	if isNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, t.codec.Unmarshal(item.Value, v)
+}
+
+func (t tx) Set(k string, v interface{}) error {
+	return t.put(k, v, nutsdb.Persistent)
+}
+
+// SetWithTTL stores the given value for the given key.
+// The value expires after ttl, rounded up to the next whole second.
+func (t tx) SetWithTTL(k string, v interface{}, ttl time.Duration) error {
+	return t.put(k, v, ttlSeconds(ttl))
+}
+
+func (t tx) put(k string, v interface{}, ttl uint32) error {
+	if err := util.CheckKeyAndValue(k, v); err != nil {
+		return err
+	}
+	if t.readOnly {
+		return kv.ErrReadOnly
+	}
+	data, err := t.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := t.tx.Put(t.bucket, []byte(k), data, ttl); err != nil {
+		return err
+	}
+	t.writes[k] = data
+	return nil
+}
+
+func (t tx) Delete(k string) error {
+	if err := util.CheckKey(k); err != nil {
+		return err
+	}
+	if t.readOnly {
+		return kv.ErrReadOnly
+	}
+	if err := t.tx.Delete(t.bucket, []byte(k)); err != nil {
+		return err
+	}
+	t.writes[k] = nil
+	return nil
+}
+
+// CompareAndSwap stores new for k if the stored value equals old
+// and reports whether it did.
+func (s Store) CompareAndSwap(k string, old, new interface{}) (swapped bool, err error) {
+	return kv.CompareAndSwap(s.modify, s.Codec, k, old, new)
+}
+
+// SetIfAbsent stores v for k if no value is stored for it
+// and reports whether it did.
+func (s Store) SetIfAbsent(k string, v interface{}) (set bool, err error) {
+	return kv.SetIfAbsent(s.modify, s.Codec, k, v)
+}
+
+// Increment adds delta to the int64 stored for k, or to 0 if none is,
+// and returns the result.
+func (s Store) Increment(k string, delta int64) (int64, error) {
+	return kv.Increment(s.modify, s.Codec, k, delta)
+}
+
+// modify implements kv.ModifyFunc with a transaction.
+func (s Store) modify(k string, fn func(data []byte, found bool) ([]byte, bool, error)) error {
+	return s.Db.Update(func(tx *nutsdb.Tx) error {
+		var data []byte
+		item, err := tx.Get(s.Bucket, []byte(k))
+		found := err == nil
+		if found {
+			data = item.Value
+		} else if !isNotFound(err) {
+			return err
+		}
+		newData, write, err := fn(data, found)
+		if err != nil || !write {
+			return err
+		}
+		return tx.Put(s.Bucket, []byte(k), newData, nutsdb.Persistent)
+	})
+}
+
+// Scan returns an iterator over the keys that start with prefix
+// and lie within [start, end), in ascending order.
+// The matching pairs are read up front in a single transaction,
+// with a prefix scan if there is a prefix, or else a range scan
+// if the range is bounded.
+func (s Store) Scan(prefix, start, end string) (kv.Iterator, error) {
+	var pairs []kv.Pair
+	err := s.Db.View(func(tx *nutsdb.Tx) error {
+		var entries nutsdb.Entries
+		var err error
+		switch {
+		case prefix != "":
+			entries, err = tx.PrefixScan(s.Bucket, []byte(prefix), nutsdb.ScanNoLimit)
+		case end != "":
+			// RangeScan includes end, which is filtered out below.
+			entries, err = tx.RangeScan(s.Bucket, []byte(start), []byte(end))
+		default:
+			entries, err = tx.GetAll(s.Bucket)
+		}
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if k := string(e.Key); kv.InRange(k, prefix, start, end) {
+				pairs = append(pairs, kv.Pair{Key: k, Value: e.Value})
+			}
+		}
+		return nil
+	})
+	if err != nil && !isNotFound(err) && !isNoResult(err) {
+		return nil, err
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key < pairs[j].Key })
+	return kv.NewSliceIterator(pairs, s.Codec), nil
+}
+
+// isNoResult reports whether err means that a scan found no keys.
+func isNoResult(err error) bool {
+	return err == nutsdb.ErrPrefixScan || err == nutsdb.ErrRangeScan ||
+		err == nutsdb.ErrBucketEmpty || err == nutsdb.ErrStartKey
+}
+
+// isNotFound reports whether err means that the key or its bucket doesn't exist.
+// nutsdb reports a missing bucket with an ad hoc error instead of a sentinel.
+func isNotFound(err error) bool {
+	if err == nil {
+		return false
+	}
+	return err == nutsdb.ErrKeyNotFound || err == nutsdb.ErrNotFoundKey ||
+		strings.HasPrefix(err.Error(), "not found bucket:")
+}
+
 // Close closes the store.
 func (s Store) Close() error {
 	s.Db.Close()
@@ -114,3 +432,14 @@ func NewStore(options *Options) (Store, error) {
 
 	return result, nil
 }
+
+// init registers the store as "nutsdb" with package stores.
+func init() {
+	stores.Register("nutsdb", func(cfg map[string]interface{}) (gokv.Store, error) {
+		options := DefaultOptions
+		if err := stores.DecodeOptions(cfg, &options); err != nil {
+			return nil, err
+		}
+		return NewStore(&options)
+	})
+}
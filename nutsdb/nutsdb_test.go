@@ -1,6 +1,8 @@
 package nutsdb
 
 import (
+	"databases/codec"
+	"databases/storetest"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
@@ -8,6 +10,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/philippgille/gokv"
 	"github.com/philippgille/gokv/encoding"
 	"github.com/xujiajun/nutsdb"
 )
@@ -43,6 +46,56 @@ var NS = NetworkStats{
 	},
 }
 
+func TestStore(t *testing.T) {
+	storetest.TestStore(t, func(t *testing.T) gokv.Store {
+		tmpDir, err := ioutil.TempDir("", "store")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ops := &Options{
+			Config: nutsdb.DefaultOptions,
+			Dir:    tmpDir,
+			Bucket: "gigamon",
+			Codec:  encoding.JSON,
+		}
+		s, err := NewStore(ops)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { os.RemoveAll(tmpDir) })
+		return s
+	})
+}
+
+// TestEmptyValues checks that the atomic operations
+// tell empty values apart from missing ones.
+func TestEmptyValues(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	s, err := NewStore(&Options{
+		Config: nutsdb.DefaultOptions,
+		Dir:    tmpDir,
+		Bucket: "gigamon",
+		Codec:  codec.Raw,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Set("sen1", []byte{}); err != nil {
+		t.Fatal(err)
+	}
+	if set, err := s.SetIfAbsent("sen1", []byte("new")); err != nil || set {
+		t.Errorf("SetIfAbsent of an empty value got %v, %v", set, err)
+	}
+	if swapped, err := s.CompareAndSwap("sen1", []byte{}, []byte("new")); err != nil || !swapped {
+		t.Errorf("CompareAndSwap of an empty value got %v, %v", swapped, err)
+	}
+}
+
 func BenchmarkSet(b *testing.B) {
 	tmpDir, _ := ioutil.TempDir("", "store")
 	defer os.RemoveAll(tmpDir)
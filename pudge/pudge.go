@@ -1,8 +1,14 @@
 package pudge
 
 import (
-	"encoding/json"
+	"context"
+	"databases/kv"
+	"databases/stores"
+	"sort"
+	"sync"
+	"time"
 
+	"github.com/philippgille/gokv"
 	"github.com/philippgille/gokv/encoding"
 	"github.com/philippgille/gokv/util"
 	"github.com/recoilme/pudge"
@@ -12,28 +18,61 @@ import (
 type Store struct {
 	Db    *pudge.Db
 	Codec encoding.Codec
+	// mu serializes the writes, so that the atomic operations
+	// can read and write a value without interruption.
+	mu *sync.Mutex
 }
 
 // Set stores the given value for the given key.
 func (s Store) Set(k string, v interface{}) error {
+	return s.SetContext(context.Background(), k, v)
+}
+
+// SetContext stores the given value for the given key
+// unless the context is done before the value is written.
+func (s Store) SetContext(ctx context.Context, k string, v interface{}) error {
+	return s.set(ctx, k, v, time.Time{})
+}
+
+// SetWithTTL stores the given value for the given key.
+// The value expires after ttl; expired values are kept
+// until they are overwritten or deleted, but never returned.
+func (s Store) SetWithTTL(k string, v interface{}, ttl time.Duration) error {
+	return s.set(context.Background(), k, v, kv.Deadline(ttl))
+}
+
+func (s Store) set(ctx context.Context, k string, v interface{}, deadline time.Time) error {
 	if err := util.CheckKeyAndValue(k, v); err != nil {
 		return err
 	}
-	var data []byte
-	var err error
-	if data, err = json.Marshal(v); err != nil {
+	data, err := s.Codec.Marshal(v)
+	if err != nil {
 		return err
 	}
-	err = s.Db.Set(k, data)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	err = s.Db.Set(k, kv.WithExpiry(data, deadline))
+	s.mu.Unlock()
 	return err
 
 }
 
 // Get retrieves the stored value for the given key.
 func (s Store) Get(k string, v interface{}) (found bool, err error) {
+	return s.GetContext(context.Background(), k, v)
+}
+
+// GetContext retrieves the stored value for the given key
+// unless the context is done before the value is read.
+func (s Store) GetContext(ctx context.Context, k string, v interface{}) (found bool, err error) {
 	if err := util.CheckKeyAndValue(k, v); err != nil {
 		return false, err
 	}
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
 
 	var data []byte
 	if err := s.Db.Get(k, &data); err == pudge.ErrKeyNotFound {
@@ -41,17 +80,174 @@ func (s Store) Get(k string, v interface{}) (found bool, err error) {
 	} else if err != nil {
 		return false, err
 	}
+	deadline, data, err := kv.SplitExpiry(data)
+	if err != nil {
+		return false, err
+	}
+	if kv.Expired(deadline, time.Now()) {
+		return false, nil
+	}
 
 	return true, s.Codec.Unmarshal(data, v)
 }
 
 // Delete deletes the stored value for the given key.
 func (s Store) Delete(k string) error {
+	return s.DeleteContext(context.Background(), k)
+}
+
+// DeleteContext deletes the stored value for the given key
+// unless the context is done before the value is deleted.
+func (s Store) DeleteContext(ctx context.Context, k string) error {
 	if err := util.CheckKey(k); err != nil {
 		return err
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	err := s.Db.Delete(k)
+	s.mu.Unlock()
+	if err != nil && err != pudge.ErrKeyNotFound {
+		return err
+	}
+	return nil
+}
+
+// SetMany stores the given values for their keys one by one,
+// because pudge has no batches.
+func (s Store) SetMany(values map[string]interface{}) error {
+	return kv.SetEach(s, values)
+}
+
+// GetMany retrieves the stored values for the given keys one by one.
+func (s Store) GetMany(keys []string, vs []interface{}) (found []bool, err error) {
+	return kv.GetEach(s, keys, vs)
+}
+
+// DeleteMany deletes the stored values for the given keys one by one.
+func (s Store) DeleteMany(keys []string) error {
+	return kv.DeleteEach(s, keys)
+}
+
+// CompareAndSwap stores new for k if the stored value equals old
+// and reports whether it did.
+func (s Store) CompareAndSwap(k string, old, new interface{}) (swapped bool, err error) {
+	return kv.CompareAndSwap(s.modify, s.Codec, k, old, new)
+}
+
+// SetIfAbsent stores v for k if no value is stored for it
+// and reports whether it did.
+func (s Store) SetIfAbsent(k string, v interface{}) (set bool, err error) {
+	return kv.SetIfAbsent(s.modify, s.Codec, k, v)
+}
+
+// Increment adds delta to the int64 stored for k, or to 0 if none is,
+// and returns the result.
+func (s Store) Increment(k string, delta int64) (int64, error) {
+	return kv.Increment(s.modify, s.Codec, k, delta)
+}
+
+// modify implements kv.ModifyFunc while holding the write lock.
+func (s Store) modify(k string, fn func(data []byte, found bool) ([]byte, bool, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var data []byte
+	found := true
+	if err := s.Db.Get(k, &data); err == pudge.ErrKeyNotFound {
+		found = false
+	} else if err != nil {
+		return err
+	}
+	if found {
+		deadline, value, err := kv.SplitExpiry(data)
+		if err != nil {
+			return err
+		}
+		data, found = value, !kv.Expired(deadline, time.Now())
+	}
+	newData, write, err := fn(data, found)
+	if err != nil || !write {
+		return err
+	}
+	return s.Db.Set(k, kv.WithExpiry(newData, time.Time{}))
+}
+
+// Scan returns an iterator over the keys that start with prefix
+// and lie within [start, end), in ascending order.
+// The keys come from pudge's sorted key index when the scan starts;
+// their values are read as the iterator advances.
+func (s Store) Scan(prefix, start, end string) (kv.Iterator, error) {
+	var keys [][]byte
+	var err error
+	if prefix != "" {
+		keys, err = s.Db.KeysByPrefix([]byte(prefix), 0, 0, true)
+	} else {
+		keys, err = s.Db.Keys(nil, 0, 0, true)
+	}
+	if err != nil && err != pudge.ErrKeyNotFound {
+		return nil, err
+	}
+	i := sort.Search(len(keys), func(i int) bool { return string(keys[i]) >= start })
+	return &iterator{db: s.Db, codec: s.Codec, keys: keys[i:], prefix: prefix, end: end, now: time.Now()}, nil
+}
+
+type iterator struct {
+	db     *pudge.Db
+	codec  encoding.Codec
+	keys   [][]byte
+	prefix string
+	end    string
+	now    time.Time
+	key    string
+	val    []byte
+	err    error
+}
+
+func (it *iterator) Next() bool {
+	for it.err == nil && len(it.keys) > 0 {
+		k := string(it.keys[0])
+		it.keys = it.keys[1:]
+		if !kv.InRange(k, it.prefix, "", it.end) {
+			return false
+		}
+		var data []byte
+		if err := it.db.Get(k, &data); err == pudge.ErrKeyNotFound {
+			// Deleted since the keys were listed.
+			continue
+		} else if err != nil {
+			it.err = err
+			return false
+		}
+		deadline, val, err := kv.SplitExpiry(data)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		if !kv.Expired(deadline, it.now) {
+			it.key, it.val = k, val
+			return true
+		}
+	}
+	return false
+}
 
-	return s.Db.Delete(k)
+func (it *iterator) Key() string {
+	return it.key
+}
+
+func (it *iterator) Value(v interface{}) error {
+	return it.codec.Unmarshal(it.val, v)
+}
+
+func (it *iterator) Err() error {
+	return it.err
+}
+
+func (it *iterator) Close() error {
+	it.keys = nil
+	return nil
 }
 
 // Close closes the store.
@@ -92,6 +288,21 @@ func NewStore(options *Options) (Store, error) {
 	result := Store{
 		Db:    db,
 		Codec: options.Codec,
+		mu:    &sync.Mutex{},
 	}
 	return result, nil
 }
+
+// init registers the store as "pudge" with package stores.
+func init() {
+	stores.Register("pudge", func(cfg map[string]interface{}) (gokv.Store, error) {
+		options := DefaultOptions
+		// Don't modify the Config of the DefaultOptions.
+		config := *options.Config
+		options.Config = &config
+		if err := stores.DecodeOptions(cfg, &options); err != nil {
+			return nil, err
+		}
+		return NewStore(&options)
+	})
+}
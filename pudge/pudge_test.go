@@ -1,6 +1,8 @@
 package pudge
 
 import (
+	"databases/codec"
+	"databases/storetest"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
@@ -9,6 +11,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/philippgille/gokv"
 	"github.com/philippgille/gokv/encoding"
 	"github.com/recoilme/pudge"
 )
@@ -44,6 +47,91 @@ var NS = NetworkStats{
 	},
 }
 
+func TestStore(t *testing.T) {
+	storetest.TestStore(t, newStore(encoding.JSON))
+}
+
+// TestCodecs checks that values are only encoded with the configured codec.
+func TestCodecs(t *testing.T) {
+	for _, name := range []string{"gob", "binary", "json+flate"} {
+		c, err := codec.Lookup(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Run(name, func(t *testing.T) {
+			storetest.TestStore(t, newStore(c))
+		})
+	}
+
+	enc, err := codec.NewEncrypted(codec.JSON, &codec.EncryptOptions{
+		Keys:  map[uint32][]byte{1: make([]byte, 32)},
+		KeyID: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Run("encrypted", func(t *testing.T) {
+		storetest.TestStore(t, newStore(enc))
+	})
+}
+
+// TestHeaderless checks that values written without an expiry header,
+// like by stores without TTLs, still read as values that never expire.
+func TestHeaderless(t *testing.T) {
+	s := newStore(encoding.JSON)(t).(Store)
+	defer s.Close()
+	data, err := encoding.JSON.Marshal(NS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Db.Set("ses1", data); err != nil {
+		t.Fatal(err)
+	}
+	var got NetworkStats
+	found, err := s.Get("ses1", &got)
+	if err != nil || !found || got.SensorID != NS.SensorID {
+		t.Fatalf("got %+v, %v, %v", got, found, err)
+	}
+
+	// Values without a TTL are still written without a header.
+	if err := s.Set("ses2", NS); err != nil {
+		t.Fatal(err)
+	}
+	var stored []byte
+	if err := s.Db.Get("ses2", &stored); err != nil {
+		t.Fatal(err)
+	}
+	if string(stored) != string(data) {
+		t.Errorf("stored %q, want %q", stored, data)
+	}
+}
+
+// newStore returns a function that creates stores with the given codec
+// in temporary directories.
+func newStore(c encoding.Codec) storetest.NewStoreFunc {
+	return func(t *testing.T) gokv.Store {
+		tmpDir, err := ioutil.TempDir("", "store")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ops := &Options{
+			Config: &pudge.Config{
+				SyncInterval: 0,
+				FileMode:     0666,
+				DirMode:      0777,
+			},
+			File:  path.Join(tmpDir, "db"),
+			Codec: c,
+		}
+		s, err := NewStore(ops)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { os.RemoveAll(tmpDir) })
+		return s
+	}
+}
+
 func BenchmarkSet(b *testing.B) {
 	tmpDir, _ := ioutil.TempDir("", "store")
 	defer os.RemoveAll(tmpDir)
@@ -0,0 +1,308 @@
+package redis
+
+import (
+	"context"
+	"databases/kv"
+	"databases/stores"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/philippgille/gokv"
+	"github.com/philippgille/gokv/encoding"
+	"github.com/philippgille/gokv/util"
+)
+
+// scanCount is the number of keys a SCAN call asks Redis for.
+const scanCount = 1000
+
+// Store is a gokv.Store implementation for a Redis server.
+//
+// It doesn't implement the atomic operations and transactions of the
+// other stores, because they would need MULTI/EXEC or Lua scripts,
+// which Redis stand-ins like package resp don't provide.
+type Store struct {
+	Client *redis.Client
+	Codec  encoding.Codec
+}
+
+// Set stores the given value for the given key.
+func (s Store) Set(k string, v interface{}) error {
+	return s.SetContext(context.Background(), k, v)
+}
+
+// SetContext stores the given value for the given key
+// unless the context is done before the value is written.
+func (s Store) SetContext(ctx context.Context, k string, v interface{}) error {
+	return s.set(ctx, k, v, 0)
+}
+
+// SetWithTTL stores the given value for the given key.
+// Redis expires the value after ttl.
+func (s Store) SetWithTTL(k string, v interface{}, ttl time.Duration) error {
+	return s.set(context.Background(), k, v, ttl)
+}
+
+func (s Store) set(ctx context.Context, k string, v interface{}, ttl time.Duration) error {
+	if err := util.CheckKeyAndValue(k, v); err != nil {
+		return err
+	}
+	data, err := s.Codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.Client.WithContext(ctx).Set(k, data, ttl).Err()
+}
+
+// Get retrieves the stored value for the given key.
+func (s Store) Get(k string, v interface{}) (found bool, err error) {
+	return s.GetContext(context.Background(), k, v)
+}
+
+// GetContext retrieves the stored value for the given key
+// unless the context is done before the value is read.
+func (s Store) GetContext(ctx context.Context, k string, v interface{}) (found bool, err error) {
+	if err := util.CheckKeyAndValue(k, v); err != nil {
+		return false, err
+	}
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	data, err := s.Client.WithContext(ctx).Get(k).Bytes()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, s.Codec.Unmarshal(data, v)
+}
+
+// Delete deletes the stored value for the given key.
+func (s Store) Delete(k string) error {
+	return s.DeleteContext(context.Background(), k)
+}
+
+// DeleteContext deletes the stored value for the given key
+// unless the context is done before the value is deleted.
+func (s Store) DeleteContext(ctx context.Context, k string) error {
+	if err := util.CheckKey(k); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.Client.WithContext(ctx).Del(k).Err()
+}
+
+// SetMany stores the given values for their keys with a single MSET.
+func (s Store) SetMany(values map[string]interface{}) error {
+	if len(values) == 0 {
+		return nil
+	}
+	pairs := make([]interface{}, 0, 2*len(values))
+	for k, v := range values {
+		if err := util.CheckKeyAndValue(k, v); err != nil {
+			return err
+		}
+		data, err := s.Codec.Marshal(v)
+		if err != nil {
+			return err
+		}
+		pairs = append(pairs, k, data)
+	}
+	return s.Client.MSet(pairs...).Err()
+}
+
+// GetMany retrieves the stored values for the given keys with a single MGET.
+func (s Store) GetMany(keys []string, vs []interface{}) (found []bool, err error) {
+	if len(keys) != len(vs) {
+		return nil, kv.ErrLengthMismatch
+	}
+	for i, k := range keys {
+		if err := util.CheckKeyAndValue(k, vs[i]); err != nil {
+			return nil, err
+		}
+	}
+	found = make([]bool, len(keys))
+	if len(keys) == 0 {
+		return found, nil
+	}
+	values, err := s.Client.MGet(keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, value := range values {
+		// MGET returns bulk strings as strings and missing keys as nil.
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+		if err := s.Codec.Unmarshal([]byte(data), vs[i]); err != nil {
+			return nil, err
+		}
+		found[i] = true
+	}
+	return found, nil
+}
+
+// DeleteMany deletes the stored values for the given keys with a single DEL.
+func (s Store) DeleteMany(keys []string) error {
+	for _, k := range keys {
+		if err := util.CheckKey(k); err != nil {
+			return err
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return s.Client.Del(keys...).Err()
+}
+
+// Scan returns an iterator over the keys that start with prefix
+// and lie within [start, end), in ascending order.
+// Redis doesn't keep its keys in order, so the matching keys are listed
+// with SCAN and sorted when the scan starts; their values are read as
+// the iterator advances.
+func (s Store) Scan(prefix, start, end string) (kv.Iterator, error) {
+	var keys []string
+	iter := s.Client.Scan(0, escapeGlob(prefix)+"*", scanCount).Iterator()
+	for iter.Next() {
+		if k := iter.Val(); kv.InRange(k, prefix, start, end) {
+			keys = append(keys, k)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	// SCAN can return a key more than once.
+	unique := keys[:0]
+	for i, k := range keys {
+		if i == 0 || k != keys[i-1] {
+			unique = append(unique, k)
+		}
+	}
+	return &iterator{client: s.Client, codec: s.Codec, keys: unique}, nil
+}
+
+type iterator struct {
+	client *redis.Client
+	codec  encoding.Codec
+	keys   []string
+	key    string
+	val    []byte
+	err    error
+}
+
+func (it *iterator) Next() bool {
+	for it.err == nil && len(it.keys) > 0 {
+		k := it.keys[0]
+		it.keys = it.keys[1:]
+		data, err := it.client.Get(k).Bytes()
+		if err == redis.Nil {
+			// Deleted or expired since the keys were listed.
+			continue
+		}
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.key, it.val = k, data
+		return true
+	}
+	return false
+}
+
+func (it *iterator) Key() string {
+	return it.key
+}
+
+func (it *iterator) Value(v interface{}) error {
+	return it.codec.Unmarshal(it.val, v)
+}
+
+func (it *iterator) Err() error {
+	return it.err
+}
+
+func (it *iterator) Close() error {
+	it.keys = nil
+	return nil
+}
+
+// escapeGlob escapes the characters of s that are special
+// in the patterns of SCAN MATCH.
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// Close closes the connections to the server.
+func (s Store) Close() error {
+	return s.Client.Close()
+}
+
+// Options are the options for the Redis store.
+type Options struct {
+	// Address of the Redis server, as host:port.
+	Address string
+	// Password for the AUTH command. Empty means no authentication.
+	Password string
+	// Database to SELECT.
+	DB int
+	// Encoding format.
+	Codec encoding.Codec
+}
+
+// DefaultOptions is an Options object with default values.
+var DefaultOptions = Options{
+	Address: "localhost:6379",
+	Codec:   encoding.JSON,
+}
+
+// NewStore creates a Redis store and checks that the server answers.
+func NewStore(options *Options) (Store, error) {
+	if options == nil {
+		options = &DefaultOptions
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:     options.Address,
+		Password: options.Password,
+		DB:       options.DB,
+	})
+	if err := client.Ping().Err(); err != nil {
+		client.Close()
+		return Store{}, err
+	}
+	result := Store{
+		Client: client,
+		Codec:  options.Codec,
+	}
+
+	return result, nil
+}
+
+// init registers the store as "redis" with package stores.
+func init() {
+	stores.Register("redis", func(cfg map[string]interface{}) (gokv.Store, error) {
+		options := DefaultOptions
+		if err := stores.DecodeOptions(cfg, &options); err != nil {
+			return nil, err
+		}
+		return NewStore(&options)
+	})
+}
@@ -0,0 +1,174 @@
+package redis
+
+import (
+	"databases/memory"
+	"databases/resp"
+	"databases/storetest"
+	"fmt"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/philippgille/gokv"
+)
+
+type NetworkStats struct {
+	SensorID   string           `json:"sensor_id"`
+	Updated    time.Time        `json:"updated"`
+	Interfaces []InterfaceStats `json:"interfaces"`
+}
+type InterfaceStats struct {
+	Interface string `json:"interface_name"`
+	TxBytes   int64  `json:"tx_bytes"`
+	TxPackets int64  `json:"tx_packets"`
+	TxErrors  int64  `json:"tx_errors"`
+	RxBytes   int64  `json:"rx_bytes"`
+	RxPackets int64  `json:"rx_packets"`
+	RxErrors  int64  `json:"rx_errors"`
+}
+
+var NS = NetworkStats{
+	SensorID: "ses1",
+	Updated:  time.Now(),
+	Interfaces: []InterfaceStats{
+		{
+			Interface: "eth0",
+			TxBytes:   123,
+			TxPackets: 345,
+			TxErrors:  234,
+			RxBytes:   566,
+			RxPackets: 12,
+			RxErrors:  12,
+		},
+	},
+}
+
+// startServer serves an empty memory store with the Redis protocol
+// and returns its address and a function that stops it.
+func startServer() (string, func(), error) {
+	store, err := memory.NewStore(&memory.DefaultOptions)
+	if err != nil {
+		return "", nil, err
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+	srv := resp.NewServer(store)
+	go srv.Serve(l)
+	return l.Addr().String(), func() {
+		srv.Close()
+		store.Close()
+	}, nil
+}
+
+// newStore creates a store connected to a new in-process server.
+func newStore(tb testing.TB) Store {
+	addr, stop, err := startServer()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(stop)
+	ops := DefaultOptions
+	ops.Address = addr
+	s, err := NewStore(&ops)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return s
+}
+
+func TestStore(t *testing.T) {
+	storetest.TestStore(t, func(t *testing.T) gokv.Store {
+		return newStore(t)
+	})
+}
+
+func TestScanSpecialPrefix(t *testing.T) {
+	s := newStore(t)
+	defer s.Close()
+
+	for _, k := range []string{"a*b", "a*c", "axb", "a?"} {
+		if err := s.Set(k, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	it, err := s.Scan("a*", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+	var keys []string
+	for it.Next() {
+		keys = append(keys, it.Key())
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(keys) != "[a*b a*c]" {
+		t.Errorf("got %v, want [a*b a*c]", keys)
+	}
+}
+
+func TestNewStoreUnreachable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	ops := DefaultOptions
+	ops.Address = addr
+	if _, err := NewStore(&ops); err == nil {
+		t.Error("NewStore succeeded without a server")
+	}
+}
+
+func BenchmarkSet(b *testing.B) {
+	s := newStore(b)
+	defer s.Close()
+	for i := 0; i < b.N; i++ {
+		key := fmt.Sprintf("sen%d", i)
+		s.Set(key, NS)
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	d := 1000
+	s := createStoreAndWriteNItems(b, d)
+	defer s.Close()
+
+	newdata := new(NetworkStats)
+	for i := 0; i < b.N; i++ {
+		j := rand.Intn(d)
+		k := fmt.Sprintf("sen%d", j)
+		if f, _ := s.Get(k, newdata); f != true {
+			fmt.Printf("Can not read data for the key:%v\n", k)
+		}
+	}
+}
+
+func BenchmarkDelete(b *testing.B) {
+	d := 1000
+	s := createStoreAndWriteNItems(b, d)
+	defer s.Close()
+
+	for i := 0; i < b.N; i++ {
+		j := rand.Intn(d)
+		k := fmt.Sprintf("sen%d", j)
+		if err := s.Delete(k); err != nil {
+			fmt.Printf("Can not delete key:%v", k)
+		}
+	}
+}
+
+func createStoreAndWriteNItems(tb testing.TB, items int) Store {
+	s := newStore(tb)
+	for i := 0; i < items; i++ {
+		k := fmt.Sprintf("sen%d", i)
+		s.Set(k, NS)
+	}
+	return s
+}
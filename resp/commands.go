@@ -0,0 +1,394 @@
+package resp
+
+import (
+	"databases/kv"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// command is a Redis command. Like in Redis, a positive arity is the
+// exact number of arguments including the command name, and a negative
+// arity the minimum number.
+type command struct {
+	arity int
+	run   func(s *Server, w writer, args [][]byte) error
+}
+
+var commands = map[string]command{
+	"ping":    {-1, ping},
+	"echo":    {2, echo},
+	"select":  {2, selectDB},
+	"command": {-1, commandInfo},
+	"get":     {2, get},
+	"set":     {-3, set},
+	"setnx":   {3, setNX},
+	"del":     {-2, del},
+	"exists":  {-2, exists},
+	"expire":  {3, expire(time.Second)},
+	"pexpire": {3, expire(time.Millisecond)},
+	"mget":    {-2, mget},
+	"mset":    {-3, mset},
+	"scan":    {-2, scan},
+}
+
+var (
+	errSyntax  = errors.New("syntax error")
+	errInteger = errors.New("value is not an integer or out of range")
+)
+
+// errExpireTime is the error of a timeout that is out of range of the
+// command, including ones that would overflow a time.Duration.
+func errExpireTime(command string) error {
+	return fmt.Errorf("invalid expire time in '%s' command", command)
+}
+
+func ping(s *Server, w writer, args [][]byte) error {
+	switch len(args) {
+	case 1:
+		w.simple("PONG")
+	case 2:
+		w.bulk(args[1])
+	default:
+		return errors.New("wrong number of arguments for 'ping' command")
+	}
+	return nil
+}
+
+func echo(s *Server, w writer, args [][]byte) error {
+	w.bulk(args[1])
+	return nil
+}
+
+// selectDB accepts the only database, 0.
+func selectDB(s *Server, w writer, args [][]byte) error {
+	if string(args[1]) != "0" {
+		return errors.New("DB index is out of range")
+	}
+	w.simple("OK")
+	return nil
+}
+
+// commandInfo answers COMMAND, which redis-cli sends on startup,
+// with an empty list.
+func commandInfo(s *Server, w writer, args [][]byte) error {
+	w.array(0)
+	return nil
+}
+
+func get(s *Server, w writer, args [][]byte) error {
+	var v []byte
+	found, err := s.Store.Get(string(args[1]), &v)
+	if err != nil {
+		return err
+	}
+	if !found {
+		w.null()
+		return nil
+	}
+	w.bulk(v)
+	return nil
+}
+
+func set(s *Server, w writer, args [][]byte) error {
+	k, v := string(args[1]), args[2]
+	var ttl time.Duration
+	var nx, xx bool
+	for i := 3; i < len(args); i++ {
+		switch opt := strings.ToLower(string(args[i])); {
+		case opt == "nx" && !xx:
+			nx = true
+		case opt == "xx" && !nx:
+			xx = true
+		case (opt == "ex" || opt == "px") && ttl == 0 && i+1 < len(args):
+			n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return errInteger
+			}
+			unit := time.Second
+			if opt == "px" {
+				unit = time.Millisecond
+			}
+			if n <= 0 || n > math.MaxInt64/int64(unit) {
+				return errExpireTime("set")
+			}
+			ttl = time.Duration(n) * unit
+			i++
+		default:
+			return errSyntax
+		}
+	}
+
+	switch {
+	case nx:
+		ok, err := s.setIfAbsent(k, v, ttl)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			w.null()
+			return nil
+		}
+	case xx:
+		found, err := s.exists([]string{k})
+		if err != nil {
+			return err
+		}
+		if found[0] {
+			if err := s.set(k, v, ttl); err != nil {
+				return err
+			}
+		} else {
+			w.null()
+			return nil
+		}
+	default:
+		if err := s.set(k, v, ttl); err != nil {
+			return err
+		}
+	}
+	w.simple("OK")
+	return nil
+}
+
+func setNX(s *Server, w writer, args [][]byte) error {
+	ok, err := s.setIfAbsent(string(args[1]), args[2], 0)
+	if err != nil {
+		return err
+	}
+	w.integer(boolToInt(ok))
+	return nil
+}
+
+func del(s *Server, w writer, args [][]byte) error {
+	keys := stringArgs(args[1:])
+	found, err := s.exists(keys)
+	if err != nil {
+		return err
+	}
+	if err := kv.WithBatch(s.Store).DeleteMany(keys); err != nil {
+		return err
+	}
+	w.integer(count(found))
+	return nil
+}
+
+func exists(s *Server, w writer, args [][]byte) error {
+	found, err := s.exists(stringArgs(args[1:]))
+	if err != nil {
+		return err
+	}
+	w.integer(count(found))
+	return nil
+}
+
+// expire returns the EXPIRE command for timeouts in units of unit.
+// It reads the value and sets it again with the TTL in a transaction,
+// so it needs a kv.Transactional store whose transactions are kv.TTLTxns.
+func expire(unit time.Duration) func(s *Server, w writer, args [][]byte) error {
+	return func(s *Server, w writer, args [][]byte) error {
+		k := string(args[1])
+		n, err := strconv.ParseInt(string(args[2]), 10, 64)
+		if err != nil {
+			return errInteger
+		}
+		if n > math.MaxInt64/int64(unit) {
+			return errExpireTime(strings.ToLower(string(args[0])))
+		}
+		ts, ok := s.Store.(kv.Transactional)
+		if !ok {
+			return kv.ErrNotSupported
+		}
+		var found bool
+		for {
+			err = ts.Update(func(tx kv.Txn) error {
+				var v []byte
+				var err error
+				if found, err = tx.Get(k, &v); err != nil || !found {
+					return err
+				}
+				// Like Redis, a timeout in the past deletes the key.
+				if n <= 0 {
+					return tx.Delete(k)
+				}
+				tt, ok := tx.(kv.TTLTxn)
+				if !ok {
+					return kv.ErrNotSupported
+				}
+				return tt.SetWithTTL(k, v, time.Duration(n)*unit)
+			})
+			if err != kv.ErrConflict {
+				break
+			}
+		}
+		if err != nil {
+			return err
+		}
+		w.integer(boolToInt(found))
+		return nil
+	}
+}
+
+func mget(s *Server, w writer, args [][]byte) error {
+	keys := stringArgs(args[1:])
+	values := make([][]byte, len(keys))
+	vs := make([]interface{}, len(keys))
+	for i := range values {
+		vs[i] = &values[i]
+	}
+	found, err := kv.WithBatch(s.Store).GetMany(keys, vs)
+	if err != nil {
+		return err
+	}
+	w.array(len(keys))
+	for i, v := range values {
+		if found[i] {
+			w.bulk(v)
+		} else {
+			w.null()
+		}
+	}
+	return nil
+}
+
+func mset(s *Server, w writer, args [][]byte) error {
+	if len(args)%2 != 1 {
+		return errors.New("wrong number of arguments for 'mset' command")
+	}
+	values := make(map[string]interface{}, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		values[string(args[i])] = args[i+1]
+	}
+	if err := kv.WithBatch(s.Store).SetMany(values); err != nil {
+		return err
+	}
+	w.simple("OK")
+	return nil
+}
+
+// scan answers SCAN in key order. Cursors other than 0 are handed out
+// by the server and remember the key the next call starts at.
+func scan(s *Server, w writer, args [][]byte) error {
+	cursor, err := strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil {
+		return errors.New("invalid cursor")
+	}
+	pattern, n := "", 10
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return errSyntax
+		}
+		switch strings.ToLower(string(args[i])) {
+		case "match":
+			pattern = string(args[i+1])
+		case "count":
+			if n, err = strconv.Atoi(string(args[i+1])); err != nil {
+				return errInteger
+			}
+			if n < 1 {
+				return errSyntax
+			}
+		default:
+			return errSyntax
+		}
+	}
+
+	sc, ok := s.Store.(kv.Scanner)
+	if !ok {
+		return kv.ErrNotSupported
+	}
+	var start string
+	if cursor != 0 {
+		if start, ok = s.cursors.take(cursor); !ok {
+			return errors.New("invalid cursor")
+		}
+	}
+	it, err := sc.Scan(literalPrefix(pattern), start, "")
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+
+	var keys []string
+	for i := 0; i < n && it.Next(); i++ {
+		if k := it.Key(); pattern == "" || match(pattern, k) {
+			keys = append(keys, k)
+		}
+	}
+	var next uint64
+	if it.Next() {
+		next = s.cursors.add(it.Key())
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+
+	w.array(2)
+	w.bulkString(strconv.FormatUint(next, 10))
+	w.array(len(keys))
+	for _, k := range keys {
+		w.bulkString(k)
+	}
+	return nil
+}
+
+func (s *Server) set(k string, v []byte, ttl time.Duration) error {
+	if ttl == 0 {
+		return s.Store.Set(k, v)
+	}
+	ts, ok := s.Store.(kv.TTLStore)
+	if !ok {
+		return kv.ErrNotSupported
+	}
+	return ts.SetWithTTL(k, v, ttl)
+}
+
+// setIfAbsent sets k if it doesn't exist. The expiry is set afterwards.
+func (s *Server) setIfAbsent(k string, v []byte, ttl time.Duration) (bool, error) {
+	as, ok := s.Store.(kv.AtomicStore)
+	if !ok {
+		return false, kv.ErrNotSupported
+	}
+	if ok, err := as.SetIfAbsent(k, v); !ok || err != nil {
+		return ok, err
+	}
+	if ttl > 0 {
+		return true, s.set(k, v, ttl)
+	}
+	return true, nil
+}
+
+// exists reports which of the keys exist.
+func (s *Server) exists(keys []string) ([]bool, error) {
+	vs := make([]interface{}, len(keys))
+	for i := range vs {
+		vs[i] = new([]byte)
+	}
+	return kv.WithBatch(s.Store).GetMany(keys, vs)
+}
+
+func stringArgs(args [][]byte) []string {
+	ss := make([]string, len(args))
+	for i, arg := range args {
+		ss[i] = string(arg)
+	}
+	return ss
+}
+
+func count(found []bool) int64 {
+	var n int64
+	for _, f := range found {
+		n += boolToInt(f)
+	}
+	return n
+}
+
+func boolToInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,98 @@
+package resp
+
+import "strings"
+
+// match reports whether s matches the glob-style pattern of SCAN MATCH:
+// * matches any sequence, ? any byte, [abc], [^abc] and [a-z] a byte
+// of a class, and \ escapes the next byte.
+func match(pattern, s string) bool {
+	for pattern != "" {
+		switch pattern[0] {
+		case '*':
+			pattern = strings.TrimLeft(pattern, "*")
+			if pattern == "" {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if match(pattern, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s == "" {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		case '[':
+			if s == "" {
+				return false
+			}
+			var ok bool
+			ok, pattern = matchClass(pattern[1:], s[0])
+			if !ok {
+				return false
+			}
+			s = s[1:]
+		default:
+			if pattern[0] == '\\' && len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			if s == "" || s[0] != pattern[0] {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		}
+	}
+	return s == ""
+}
+
+// matchClass matches c against the class at the start of p, which follows
+// the opening bracket, and returns the rest of the pattern after the class.
+func matchClass(p string, c byte) (bool, string) {
+	negate := false
+	if p != "" && p[0] == '^' {
+		negate, p = true, p[1:]
+	}
+	matched := false
+	for p != "" && p[0] != ']' {
+		switch {
+		case p[0] == '\\' && len(p) > 1:
+			matched = matched || p[1] == c
+			p = p[2:]
+		case len(p) > 2 && p[1] == '-' && p[2] != ']':
+			lo, hi := p[0], p[2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			matched = matched || lo <= c && c <= hi
+			p = p[3:]
+		default:
+			matched = matched || p[0] == c
+			p = p[1:]
+		}
+	}
+	if p != "" {
+		p = p[1:]
+	}
+	return matched != negate, p
+}
+
+// literalPrefix returns the prefix that every key matching pattern has.
+func literalPrefix(pattern string) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*', '?', '[':
+			return b.String()
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				b.WriteByte(pattern[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
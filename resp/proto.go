@@ -0,0 +1,165 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+)
+
+const (
+	// MaxBulkSize is the maximum size of a bulk string in a request.
+	MaxBulkSize = 512 << 20
+	// MaxArgs is the maximum number of arguments of a request.
+	MaxArgs = 1 << 20
+
+	// maxArgsCap bounds the arguments allocated before they're read.
+	maxArgsCap = 64
+)
+
+// protocolError is a malformed request. The connection is closed after it.
+type protocolError string
+
+func (e protocolError) Error() string {
+	return "Protocol error: " + string(e)
+}
+
+// reader reads requests, either as RESP arrays of bulk strings or
+// as inline commands separated by spaces.
+type reader struct {
+	*bufio.Reader
+}
+
+func newReader(r io.Reader) reader {
+	return reader{bufio.NewReaderSize(r, 16<<10)}
+}
+
+// readCommand reads the arguments of the next request.
+// Empty requests return no arguments.
+func (r reader) readCommand() ([][]byte, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if b[0] != '*' {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		return bytes.Fields(line), nil
+	}
+
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n > MaxArgs {
+		return nil, protocolError("invalid multibulk length")
+	}
+	if n <= 0 {
+		// Like Redis, *-1 and *0 are empty requests.
+		return nil, nil
+	}
+	// The length comes from the client, so it only grows the
+	// arguments as they arrive.
+	c := n
+	if c > maxArgsCap {
+		c = maxArgsCap
+	}
+	args := make([][]byte, 0, c)
+	for i := 0; i < n; i++ {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, protocolError("expected '$'")
+		}
+		size, err := strconv.Atoi(string(line[1:]))
+		if err != nil || size < 0 || size > MaxBulkSize {
+			return nil, protocolError("invalid bulk length")
+		}
+		arg := make([]byte, size+2)
+		if _, err := io.ReadFull(r, arg); err != nil {
+			return nil, err
+		}
+		if arg[size] != '\r' || arg[size+1] != '\n' {
+			return nil, protocolError("expected CRLF after bulk string")
+		}
+		args = append(args, arg[:size])
+	}
+	return args, nil
+}
+
+// readLine reads a line without its CRLF. The line is only valid
+// until the next read.
+func (r reader) readLine() ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, protocolError("too big request line")
+	}
+	if err != nil {
+		return nil, err
+	}
+	line = line[:len(line)-1]
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+	return line, nil
+}
+
+// writer writes RESP replies.
+type writer struct {
+	*bufio.Writer
+}
+
+func newWriter(w io.Writer) writer {
+	return writer{bufio.NewWriterSize(w, 16<<10)}
+}
+
+func (w writer) simple(s string) {
+	w.WriteByte('+')
+	w.WriteString(s)
+	w.WriteString("\r\n")
+}
+
+func (w writer) error(s string) {
+	w.WriteByte('-')
+	w.WriteString(s)
+	w.WriteString("\r\n")
+}
+
+func (w writer) integer(n int64) {
+	w.prefixed(':', n)
+}
+
+func (w writer) bulk(b []byte) {
+	w.prefixed('$', int64(len(b)))
+	w.Write(b)
+	w.WriteString("\r\n")
+}
+
+func (w writer) bulkString(s string) {
+	w.prefixed('$', int64(len(s)))
+	w.WriteString(s)
+	w.WriteString("\r\n")
+}
+
+// null writes the null bulk string.
+func (w writer) null() {
+	w.WriteString("$-1\r\n")
+}
+
+// array writes the header of an array of n elements.
+func (w writer) array(n int) {
+	w.prefixed('*', int64(n))
+}
+
+func (w writer) prefixed(prefix byte, n int64) {
+	var buf [24]byte
+	b := append(buf[:0], prefix)
+	b = strconv.AppendInt(b, n, 10)
+	b = append(b, '\r', '\n')
+	w.Write(b)
+}
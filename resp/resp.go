@@ -0,0 +1,222 @@
+// Package resp serves a gokv.Store with the Redis protocol (RESP),
+// so that redis-cli and Redis clients can use the embedded stores.
+//
+// The supported commands are:
+//
+//	GET key
+//	SET key value [EX seconds|PX milliseconds] [NX|XX]
+//	SETNX key value
+//	DEL key [key ...]
+//	EXISTS key [key ...]
+//	EXPIRE key seconds
+//	PEXPIRE key milliseconds
+//	MGET key [key ...]
+//	MSET key value [key value ...]
+//	SCAN cursor [MATCH pattern] [COUNT count]
+//	PING [message], ECHO message, SELECT 0, COMMAND, QUIT
+//
+// Values are stored as []byte with the Codec of the store.
+// Expiry needs a kv.TTLStore, EXPIRE a kv.Transactional store whose
+// transactions are kv.TTLTxns, NX a kv.AtomicStore and SCAN a kv.Scanner;
+// on other stores these commands answer an error.
+// XX and the expiry of SET with NX are not atomic.
+package resp
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/philippgille/gokv"
+)
+
+// MaxCursors is the number of SCAN cursors a Server keeps.
+// When there are more, the oldest cursors become invalid.
+const MaxCursors = 1024
+
+// ErrServerClosed is returned by Serve after Close.
+var ErrServerClosed = errors.New("resp: server closed")
+
+// Server serves a store to Redis clients.
+type Server struct {
+	Store gokv.Store
+
+	mu        sync.Mutex
+	closed    bool
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+	cursors   cursors
+}
+
+// NewServer creates a Server that serves store.
+func NewServer(store gokv.Store) *Server {
+	return &Server{Store: store}
+}
+
+// ListenAndServe listens on the TCP address addr and serves the
+// connections to it.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve serves the connections accepted by l until Close is called.
+// It always returns an error and closes l.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+		s.conns = make(map[net.Conn]struct{})
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+		l.Close()
+	}()
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			nc.Close()
+			return ErrServerClosed
+		}
+		s.conns[nc] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.serveConn(nc)
+	}
+}
+
+// Close closes the listeners and connections of the server and waits
+// for the running commands to finish. It doesn't close the store.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for nc := range s.conns {
+		nc.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+func (s *Server) serveConn(nc net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, nc)
+		s.mu.Unlock()
+		nc.Close()
+		s.wg.Done()
+	}()
+
+	r, w := newReader(nc), newWriter(nc)
+	for {
+		args, err := r.readCommand()
+		if err != nil {
+			var pe protocolError
+			if errors.As(err, &pe) {
+				w.error("ERR " + pe.Error())
+				w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		quit := s.execute(w, args)
+		// Replies to pipelined requests are sent together.
+		if quit || r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+		if quit {
+			return
+		}
+	}
+}
+
+// execute runs a command and writes its reply. It reports whether the
+// connection is to be closed.
+func (s *Server) execute(w writer, args [][]byte) bool {
+	name := strings.ToLower(string(args[0]))
+	if name == "quit" {
+		w.simple("OK")
+		return true
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		w.error("ERR unknown command '" + string(args[0]) + "'")
+		return false
+	}
+	if n := len(args); cmd.arity > 0 && n != cmd.arity || cmd.arity < 0 && n < -cmd.arity {
+		w.error("ERR wrong number of arguments for '" + name + "' command")
+		return false
+	}
+	if err := cmd.run(s, w, args); err != nil {
+		w.error("ERR " + err.Error())
+	}
+	return false
+}
+
+// cursors maps the SCAN cursors handed out to clients to the key
+// the next call starts at.
+type cursors struct {
+	mu    sync.Mutex
+	last  uint64
+	keys  map[uint64]string
+	order []uint64
+}
+
+// add returns a new cursor for start.
+func (c *cursors) add(start string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.keys == nil {
+		c.keys = make(map[uint64]string)
+	}
+	c.last++
+	c.keys[c.last] = start
+	c.order = append(c.order, c.last)
+	if len(c.order) > MaxCursors {
+		delete(c.keys, c.order[0])
+		c.order = c.order[1:]
+	}
+	return c.last
+}
+
+// take returns the start of cursor and invalidates it.
+func (c *cursors) take(cursor uint64) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	start, ok := c.keys[cursor]
+	delete(c.keys, cursor)
+	return start, ok
+}
@@ -0,0 +1,343 @@
+package resp_test
+
+import (
+	"bufio"
+	"databases/kv"
+	"databases/memory"
+	"databases/resp"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/philippgille/gokv"
+)
+
+// plainStore hides the optional interfaces of the store it wraps.
+type plainStore struct {
+	gokv.Store
+}
+
+// newServer serves a memory store, wrapped by wrap, on a local port.
+func newServer(t *testing.T, wrap func(gokv.Store) gokv.Store) string {
+	store, err := memory.NewStore(&memory.DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := resp.NewServer(wrap(store))
+	go srv.Serve(l)
+	t.Cleanup(func() {
+		srv.Close()
+		store.Close()
+	})
+	return l.Addr().String()
+}
+
+func newClient(t *testing.T, wrap func(gokv.Store) gokv.Store) *redis.Client {
+	client := redis.NewClient(&redis.Options{Addr: newServer(t, wrap)})
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func identity(s gokv.Store) gokv.Store { return s }
+
+func check(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetSetDel(t *testing.T) {
+	c := newClient(t, identity)
+
+	if err := c.Get("a").Err(); err != redis.Nil {
+		t.Fatalf("got %v for a missing key, want redis.Nil", err)
+	}
+	check(t, c.Set("a", "1", 0).Err())
+	check(t, c.Set("b", []byte("\x00\xff"), 0).Err())
+	if v, err := c.Get("a").Result(); err != nil || v != "1" {
+		t.Errorf("got %q, %v, want 1", v, err)
+	}
+	if v, err := c.Get("b").Bytes(); err != nil || string(v) != "\x00\xff" {
+		t.Errorf("got %q, %v, want binary value", v, err)
+	}
+	check(t, c.Set("empty", "", 0).Err())
+	if v, err := c.Get("empty").Result(); err != nil || v != "" {
+		t.Errorf("got %q, %v for the empty value", v, err)
+	}
+	if n, err := c.Exists("a", "b", "x").Result(); err != nil || n != 2 {
+		t.Errorf("EXISTS got %d, %v, want 2", n, err)
+	}
+	if n, err := c.Del("a", "x").Result(); err != nil || n != 1 {
+		t.Errorf("DEL got %d, %v, want 1", n, err)
+	}
+	if err := c.Get("a").Err(); err != redis.Nil {
+		t.Errorf("got %v after DEL, want redis.Nil", err)
+	}
+}
+
+func TestSetOptions(t *testing.T) {
+	c := newClient(t, identity)
+
+	if ok, err := c.SetNX("a", "1", 0).Result(); err != nil || !ok {
+		t.Fatalf("SETNX of a new key got %v, %v", ok, err)
+	}
+	if ok, err := c.SetNX("a", "2", time.Minute).Result(); err != nil || ok {
+		t.Errorf("SET NX of an existing key got %v, %v", ok, err)
+	}
+	if ok, err := c.SetXX("b", "1", 0).Result(); err != nil || ok {
+		t.Errorf("SET XX of a missing key got %v, %v", ok, err)
+	}
+	if ok, err := c.SetXX("a", "3", 0).Result(); err != nil || !ok {
+		t.Errorf("SET XX of an existing key got %v, %v", ok, err)
+	}
+	if v := c.Get("a").Val(); v != "3" {
+		t.Errorf("got %q, want 3", v)
+	}
+	if err := c.Do("set", "a", "1", "ex", "0").Err(); err == nil {
+		t.Error("SET with EX 0 succeeded")
+	}
+	for _, opt := range []string{"ex", "px"} {
+		err := c.Do("set", "a", "1", opt, "9223372036854775807").Err()
+		if err == nil || !strings.Contains(err.Error(), "invalid expire time") {
+			t.Errorf("SET with an overflowing %s got %v", opt, err)
+		}
+	}
+	if err := c.Do("set", "a", "1", "nx", "xx").Err(); err == nil {
+		t.Error("SET with NX and XX succeeded")
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	c := newClient(t, identity)
+
+	check(t, c.Set("a", "1", 100*time.Millisecond).Err())
+	check(t, c.Set("b", "1", 0).Err())
+	if ok, err := c.Expire("b", time.Second).Result(); err != nil || !ok {
+		t.Errorf("EXPIRE got %v, %v", ok, err)
+	}
+	if ok, err := c.Expire("x", time.Second).Result(); err != nil || ok {
+		t.Errorf("EXPIRE of a missing key got %v, %v", ok, err)
+	}
+	for _, cmd := range []string{"expire", "pexpire"} {
+		err := c.Do(cmd, "b", "9223372036854775807").Err()
+		if err == nil || !strings.Contains(err.Error(), "invalid expire time in '"+cmd+"'") {
+			t.Errorf("%s with an overflowing timeout got %v", cmd, err)
+		}
+	}
+	if v := c.Get("a").Val(); v != "1" {
+		t.Errorf("got %q before the expiry, want 1", v)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if err := c.Get("a").Err(); err != redis.Nil {
+		t.Errorf("got %v after PX expired, want redis.Nil", err)
+	}
+	if v := c.Get("b").Val(); v != "1" {
+		t.Errorf("got %q before EXPIRE expired, want 1", v)
+	}
+	time.Sleep(time.Second)
+	if err := c.Get("b").Err(); err != redis.Nil {
+		t.Errorf("got %v after EXPIRE expired, want redis.Nil", err)
+	}
+}
+
+// racingStore is a memory store that sets "a" to "2" while the
+// first transaction runs.
+type racingStore struct {
+	memory.Store
+	once sync.Once
+}
+
+func (s *racingStore) Update(fn func(tx kv.Txn) error) error {
+	return s.Store.Update(func(tx kv.Txn) error {
+		err := fn(tx)
+		s.once.Do(func() { s.Store.Set("a", []byte("2")) })
+		return err
+	})
+}
+
+// TestExpireConflict checks that EXPIRE doesn't overwrite
+// a value that was set while it ran.
+func TestExpireConflict(t *testing.T) {
+	c := newClient(t, func(s gokv.Store) gokv.Store { return &racingStore{Store: s.(memory.Store)} })
+
+	check(t, c.Set("a", "1", 0).Err())
+	if ok, err := c.PExpire("a", 100*time.Millisecond).Result(); err != nil || !ok {
+		t.Fatalf("PEXPIRE got %v, %v", ok, err)
+	}
+	if v, err := c.Get("a").Result(); err != nil || v != "2" {
+		t.Errorf("got %q, %v, want the value set during EXPIRE", v, err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if err := c.Get("a").Err(); err != redis.Nil {
+		t.Errorf("got %v after EXPIRE expired, want redis.Nil", err)
+	}
+}
+
+func TestMGetMSet(t *testing.T) {
+	c := newClient(t, identity)
+
+	check(t, c.MSet("a", "1", "b", "2", "a", "3").Err())
+	vs, err := c.MGet("a", "x", "b").Result()
+	check(t, err)
+	if len(vs) != 3 || vs[0] != "3" || vs[1] != nil || vs[2] != "2" {
+		t.Errorf("MGET got %v", vs)
+	}
+	if err := c.Do("mset", "a", "1", "b").Err(); err == nil {
+		t.Error("MSET with an odd number of arguments succeeded")
+	}
+}
+
+func TestScan(t *testing.T) {
+	c := newClient(t, identity)
+
+	want := []string{}
+	pairs := []interface{}{}
+	for i := 0; i < 25; i++ {
+		k := "user:" + string(rune('a'+i))
+		want = append(want, k)
+		pairs = append(pairs, k, "1")
+	}
+	pairs = append(pairs, "other", "1", "user", "1")
+	check(t, c.MSet(pairs...).Err())
+
+	var got []string
+	iter := c.Scan(0, "user:*", 4).Iterator()
+	for iter.Next() {
+		got = append(got, iter.Val())
+	}
+	check(t, iter.Err())
+	if !sort.StringsAreSorted(got) || strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	keys, cursor, err := c.Scan(0, "user:[a-c]", 100).Result()
+	check(t, err)
+	if cursor != 0 || strings.Join(keys, ",") != "user:a,user:b,user:c" {
+		t.Errorf("got %v with cursor %d", keys, cursor)
+	}
+	keys, _, err = c.Scan(0, "*er", 100).Result()
+	check(t, err)
+	if strings.Join(keys, ",") != "other,user" {
+		t.Errorf("got %v", keys)
+	}
+	if err := c.Scan(12345, "", 0).Err(); err == nil {
+		t.Error("SCAN with an unknown cursor succeeded")
+	}
+}
+
+func TestNotSupported(t *testing.T) {
+	c := newClient(t, func(s gokv.Store) gokv.Store { return plainStore{s} })
+
+	if err := c.Scan(0, "", 0).Err(); err == nil {
+		t.Error("SCAN succeeded on a store without Scan")
+	}
+	if err := c.Set("a", "1", time.Minute).Err(); err == nil {
+		t.Error("SET EX succeeded on a store without TTL")
+	}
+	if err := c.SetNX("a", "1", 0).Err(); err == nil {
+		t.Error("SETNX succeeded on a store without atomic operations")
+	}
+	check(t, c.Set("b", "1", 0).Err())
+	if err := c.Expire("b", time.Minute).Err(); err == nil {
+		t.Error("EXPIRE succeeded on a store without transactions")
+	}
+	// The connection is still usable after errors.
+	check(t, c.MSet("a", "1").Err())
+	if v := c.Get("a").Val(); v != "1" {
+		t.Errorf("got %q, want 1", v)
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	c := newClient(t, identity)
+
+	p := c.Pipeline()
+	for i := 0; i < 100; i++ {
+		p.Set("k", i, 0)
+	}
+	get := p.Get("k")
+	_, err := p.Exec()
+	check(t, err)
+	if v := get.Val(); v != "99" {
+		t.Errorf("got %q, want 99", v)
+	}
+}
+
+func TestInline(t *testing.T) {
+	conn, err := net.Dial("tcp", newServer(t, identity))
+	check(t, err)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	for _, tt := range []struct{ request, reply string }{
+		{"PING\r\n", "+PONG\r\n"},
+		{"set a hello\r\n", "+OK\r\n"},
+		{"get a\r\n", "$5\r\nhello\r\n"},
+		{"nosuch\r\n", "-ERR unknown command 'nosuch'\r\n"},
+		{"get\r\n", "-ERR wrong number of arguments for 'get' command\r\n"},
+		{"*2\r\n$3\r\nGET\r\n$1\r\nx\r\n", "$-1\r\n"},
+		{"QUIT\r\n", "+OK\r\n"},
+	} {
+		if _, err := conn.Write([]byte(tt.request)); err != nil {
+			t.Fatal(err)
+		}
+		reply, err := readReply(r)
+		check(t, err)
+		if reply != tt.reply {
+			t.Errorf("%q: got %q, want %q", tt.request, reply, tt.reply)
+		}
+	}
+}
+
+func TestProtocolError(t *testing.T) {
+	addr := newServer(t, identity)
+	for _, request := range []string{
+		"*1\r\n+GET\r\n",
+		"*2\r\n$3\r\nGET\r\n$-1\r\n",
+		"*2\r\n$3\r\nGET\r\n$-5\r\n",
+	} {
+		conn, err := net.Dial("tcp", addr)
+		check(t, err)
+		defer conn.Close()
+		conn.Write([]byte(request))
+		reply, err := bufio.NewReader(conn).ReadString('\n')
+		check(t, err)
+		if !strings.HasPrefix(reply, "-ERR Protocol error") {
+			t.Errorf("%q: got %q", request, reply)
+		}
+	}
+}
+
+// TestNullRequest checks that negative and zero multibulk lengths
+// are skipped like empty requests.
+func TestNullRequest(t *testing.T) {
+	conn, err := net.Dial("tcp", newServer(t, identity))
+	check(t, err)
+	defer conn.Close()
+
+	conn.Write([]byte("*-1\r\n*-2\r\n*0\r\nPING\r\n"))
+	reply, err := readReply(bufio.NewReader(conn))
+	check(t, err)
+	if reply != "+PONG\r\n" {
+		t.Errorf("got %q, want PONG", reply)
+	}
+}
+
+// readReply reads a simple, error or bulk string reply.
+func readReply(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil || line[0] != '$' || line == "$-1\r\n" {
+		return line, err
+	}
+	data, err := r.ReadString('\n')
+	return line + data, err
+}
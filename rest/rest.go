@@ -0,0 +1,296 @@
+// Package rest serves a gokv.Store over HTTP with JSON values.
+//
+// The API is:
+//
+//	GET    /keys/{key}           value of the key, or 404
+//	PUT    /keys/{key}[?ttl=1m]  set the value to the request body
+//	DELETE /keys/{key}           delete the key
+//	POST   /batch/get            body ["k1", "k2"], returns {"k1": value} for the found keys
+//	POST   /batch/set            body {"k1": value, "k2": value}
+//	POST   /batch/delete         body ["k1", "k2"]
+//	GET    /scan?prefix=&start=&end=&limit=
+//	                             returns [{"key": "k1", "value": value}] in key order
+//
+// Values are JSON documents. Errors are returned as {"error": "message"}.
+// The ttl parameter needs a kv.TTLStore and scans need a kv.Scanner;
+// stores without them answer 501 Not Implemented.
+package rest
+
+import (
+	"databases/kv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/philippgille/gokv"
+)
+
+// MaxBodySize is the maximum size of a request body in bytes.
+const MaxBodySize = 32 << 20
+
+// Handler is an http.Handler that serves a store.
+type Handler struct {
+	Store gokv.Store
+}
+
+// NewHandler creates a Handler that serves store.
+func NewHandler(store gokv.Store) Handler {
+	return Handler{Store: store}
+}
+
+// Pair is a key and its value, as returned by scans.
+type Pair struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+// errorResponse is the body of error responses.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// statusError is an error with the HTTP status to answer it with.
+type statusError struct {
+	status int
+	err    error
+}
+
+func (e statusError) Error() string {
+	return e.err.Error()
+}
+
+func errorf(status int, format string, args ...interface{}) error {
+	return statusError{status: status, err: fmt.Errorf(format, args...)}
+}
+
+var errNotFound = errorf(http.StatusNotFound, "key not found")
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, MaxBodySize)
+	var err error
+	switch path := r.URL.Path; {
+	case strings.HasPrefix(path, "/keys/"):
+		err = h.serveKey(w, r, strings.TrimPrefix(path, "/keys/"))
+	case path == "/batch/get":
+		err = h.serveBatch(w, r, h.batchGet)
+	case path == "/batch/set":
+		err = h.serveBatch(w, r, h.batchSet)
+	case path == "/batch/delete":
+		err = h.serveBatch(w, r, h.batchDelete)
+	case path == "/scan":
+		err = h.serveScan(w, r)
+	default:
+		err = errorf(http.StatusNotFound, "no such endpoint: %s", path)
+	}
+	if err != nil {
+		writeError(w, err)
+	}
+}
+
+func (h Handler) serveKey(w http.ResponseWriter, r *http.Request, k string) error {
+	if k == "" {
+		return errorf(http.StatusBadRequest, "empty key")
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		var value json.RawMessage
+		found, err := h.Store.Get(k, &value)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return errNotFound
+		}
+		writeJSON(w, http.StatusOK, value)
+		return nil
+	case http.MethodPut:
+		value, err := readValue(r)
+		if err != nil {
+			return err
+		}
+		if ttl := r.URL.Query().Get("ttl"); ttl != "" {
+			err = h.setWithTTL(k, value, ttl)
+		} else {
+			err = h.Store.Set(k, value)
+		}
+		if err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	case http.MethodDelete:
+		if err := h.Store.Delete(k); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+		return errorf(http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+	}
+}
+
+func (h Handler) setWithTTL(k string, value json.RawMessage, ttl string) error {
+	d, err := time.ParseDuration(ttl)
+	if err != nil || d <= 0 {
+		return errorf(http.StatusBadRequest, "invalid ttl %q", ttl)
+	}
+	ts, ok := h.Store.(kv.TTLStore)
+	if !ok {
+		return kv.ErrNotSupported
+	}
+	return ts.SetWithTTL(k, value, d)
+}
+
+// serveBatch decodes the JSON body of a batch request and runs fn with it.
+func (h Handler) serveBatch(w http.ResponseWriter, r *http.Request, fn func(w http.ResponseWriter, body []byte) error) error {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		return errorf(http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+	}
+	body, err := readValue(r)
+	if err != nil {
+		return err
+	}
+	return fn(w, body)
+}
+
+func (h Handler) batchGet(w http.ResponseWriter, body []byte) error {
+	var keys []string
+	if err := json.Unmarshal(body, &keys); err != nil {
+		return errorf(http.StatusBadRequest, "body must be an array of keys: %v", err)
+	}
+	if err := checkKeys(keys); err != nil {
+		return err
+	}
+	vs := make([]interface{}, len(keys))
+	for i := range vs {
+		vs[i] = new(json.RawMessage)
+	}
+	found, err := kv.WithBatch(h.Store).GetMany(keys, vs)
+	if err != nil {
+		return err
+	}
+	values := make(map[string]json.RawMessage)
+	for i, k := range keys {
+		if found[i] {
+			values[k] = *vs[i].(*json.RawMessage)
+		}
+	}
+	writeJSON(w, http.StatusOK, values)
+	return nil
+}
+
+func (h Handler) batchSet(w http.ResponseWriter, body []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return errorf(http.StatusBadRequest, "body must be an object of keys and values: %v", err)
+	}
+	values := make(map[string]interface{}, len(raw))
+	for k, v := range raw {
+		if k == "" {
+			return errorf(http.StatusBadRequest, "empty key")
+		}
+		values[k] = v
+	}
+	if err := kv.WithBatch(h.Store).SetMany(values); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (h Handler) batchDelete(w http.ResponseWriter, body []byte) error {
+	var keys []string
+	if err := json.Unmarshal(body, &keys); err != nil {
+		return errorf(http.StatusBadRequest, "body must be an array of keys: %v", err)
+	}
+	if err := checkKeys(keys); err != nil {
+		return err
+	}
+	if err := kv.WithBatch(h.Store).DeleteMany(keys); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (h Handler) serveScan(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		return errorf(http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+	}
+	q := r.URL.Query()
+	limit := -1
+	if l := q.Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit < 0 {
+			return errorf(http.StatusBadRequest, "invalid limit %q", l)
+		}
+	}
+	sc, ok := h.Store.(kv.Scanner)
+	if !ok {
+		return kv.ErrNotSupported
+	}
+	it, err := sc.Scan(q.Get("prefix"), q.Get("start"), q.Get("end"))
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+
+	pairs := []Pair{}
+	for limit != 0 && it.Next() {
+		var value json.RawMessage
+		if err := it.Value(&value); err != nil {
+			return err
+		}
+		pairs = append(pairs, Pair{Key: it.Key(), Value: value})
+		limit--
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, pairs)
+	return nil
+}
+
+// checkKeys rejects empty keys before they reach the store.
+func checkKeys(keys []string) error {
+	for _, k := range keys {
+		if k == "" {
+			return errorf(http.StatusBadRequest, "empty key")
+		}
+	}
+	return nil
+}
+
+// readValue reads a request body that must be a JSON document.
+func readValue(r *http.Request) (json.RawMessage, error) {
+	var value json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&value); err != nil {
+		return nil, errorf(http.StatusBadRequest, "body must be JSON: %v", err)
+	}
+	return value, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var se statusError
+	switch {
+	case errors.As(err, &se):
+		status = se.status
+	case err == kv.ErrNotSupported:
+		status = http.StatusNotImplemented
+	}
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
@@ -0,0 +1,161 @@
+package rest_test
+
+import (
+	"databases/codec"
+	"databases/memory"
+	"databases/rest"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/philippgille/gokv"
+)
+
+// plainStore hides the optional interfaces of the store it wraps.
+type plainStore struct {
+	gokv.Store
+}
+
+func newServer(t *testing.T, wrap func(gokv.Store) gokv.Store) *httptest.Server {
+	store, err := memory.NewStore(&memory.DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(rest.NewHandler(wrap(store)))
+	t.Cleanup(func() {
+		srv.Close()
+		store.Close()
+	})
+	return srv
+}
+
+func identity(s gokv.Store) gokv.Store { return s }
+
+// do sends a request and returns the status and body of the response.
+func do(t *testing.T, srv *httptest.Server, method, path, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, strings.TrimSpace(string(data))
+}
+
+func expect(t *testing.T, srv *httptest.Server, method, path, body string, status int, want string) {
+	t.Helper()
+	gotStatus, got := do(t, srv, method, path, body)
+	if gotStatus != status {
+		t.Fatalf("%s %s: got status %d (%s), want %d", method, path, gotStatus, got, status)
+	}
+	if want != "" && got != want {
+		t.Errorf("%s %s: got body %s, want %s", method, path, got, want)
+	}
+}
+
+func TestKeys(t *testing.T) {
+	srv := newServer(t, identity)
+
+	expect(t, srv, "GET", "/keys/a", "", http.StatusNotFound, `{"error":"key not found"}`)
+	expect(t, srv, "PUT", "/keys/a", `{"n": 1}`, http.StatusNoContent, "")
+	expect(t, srv, "GET", "/keys/a", "", http.StatusOK, `{"n":1}`)
+	expect(t, srv, "HEAD", "/keys/a", "", http.StatusOK, "")
+	expect(t, srv, "PUT", "/keys/a", `"two"`, http.StatusNoContent, "")
+	expect(t, srv, "GET", "/keys/a", "", http.StatusOK, `"two"`)
+	expect(t, srv, "DELETE", "/keys/a", "", http.StatusNoContent, "")
+	expect(t, srv, "GET", "/keys/a", "", http.StatusNotFound, "")
+	expect(t, srv, "DELETE", "/keys/a", "", http.StatusNoContent, "")
+}
+
+// TestRawCodec checks that stores with codec.Raw keep the JSON values as they are.
+func TestRawCodec(t *testing.T) {
+	store, err := memory.NewStore(&memory.Options{Codec: codec.Raw})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	srv := httptest.NewServer(rest.NewHandler(store))
+	defer srv.Close()
+
+	expect(t, srv, "PUT", "/keys/a", `{"n": 1}`, http.StatusNoContent, "")
+	expect(t, srv, "GET", "/keys/a", "", http.StatusOK, `{"n":1}`)
+	var data []byte
+	if _, err := store.Get("a", &data); err != nil || string(data) != `{"n": 1}` {
+		t.Errorf("the store has %q, %v", data, err)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	srv := newServer(t, identity)
+
+	expect(t, srv, "PUT", "/keys/a?ttl=100ms", `1`, http.StatusNoContent, "")
+	expect(t, srv, "GET", "/keys/a", "", http.StatusOK, `1`)
+	time.Sleep(200 * time.Millisecond)
+	expect(t, srv, "GET", "/keys/a", "", http.StatusNotFound, "")
+
+	expect(t, srv, "PUT", "/keys/a?ttl=soon", `1`, http.StatusBadRequest, "")
+	expect(t, srv, "PUT", "/keys/a?ttl=-1s", `1`, http.StatusBadRequest, "")
+}
+
+func TestBatch(t *testing.T) {
+	srv := newServer(t, identity)
+
+	expect(t, srv, "POST", "/batch/set", `{"a": 1, "b": [2], "c": "3"}`, http.StatusNoContent, "")
+	expect(t, srv, "POST", "/batch/get", `["a", "b", "x"]`, http.StatusOK, `{"a":1,"b":[2]}`)
+	expect(t, srv, "POST", "/batch/delete", `["a", "x"]`, http.StatusNoContent, "")
+	expect(t, srv, "POST", "/batch/get", `["a", "b", "c"]`, http.StatusOK, `{"b":[2],"c":"3"}`)
+	expect(t, srv, "GET", "/batch/get", "", http.StatusMethodNotAllowed, "")
+}
+
+func TestScan(t *testing.T) {
+	srv := newServer(t, identity)
+
+	expect(t, srv, "POST", "/batch/set", `{"a1": 1, "a2": 2, "a3": 3, "b1": 4}`, http.StatusNoContent, "")
+	_, body := do(t, srv, "GET", "/scan?prefix=a", "")
+	var pairs []rest.Pair
+	if err := json.Unmarshal([]byte(body), &pairs); err != nil {
+		t.Fatal(err)
+	}
+	if len(pairs) != 3 || pairs[0].Key != "a1" || string(pairs[2].Value) != "3" {
+		t.Errorf("got %s", body)
+	}
+	expect(t, srv, "GET", "/scan?start=a2&limit=2", "", http.StatusOK,
+		`[{"key":"a2","value":2},{"key":"a3","value":3}]`)
+	expect(t, srv, "GET", "/scan?start=a2&end=a3", "", http.StatusOK, `[{"key":"a2","value":2}]`)
+	expect(t, srv, "GET", "/scan?prefix=x", "", http.StatusOK, `[]`)
+	expect(t, srv, "GET", "/scan?limit=-1", "", http.StatusBadRequest, "")
+}
+
+func TestNotSupported(t *testing.T) {
+	srv := newServer(t, func(s gokv.Store) gokv.Store { return plainStore{s} })
+
+	expect(t, srv, "GET", "/scan", "", http.StatusNotImplemented, "")
+	expect(t, srv, "PUT", "/keys/a?ttl=1s", `1`, http.StatusNotImplemented, "")
+	// Batches fall back to one call per key.
+	expect(t, srv, "POST", "/batch/set", `{"a": 1}`, http.StatusNoContent, "")
+	expect(t, srv, "POST", "/batch/get", `["a"]`, http.StatusOK, `{"a":1}`)
+}
+
+func TestBadRequests(t *testing.T) {
+	srv := newServer(t, identity)
+
+	expect(t, srv, "PUT", "/keys/a", `{not json`, http.StatusBadRequest, "")
+	expect(t, srv, "PUT", "/keys/", `1`, http.StatusBadRequest, `{"error":"empty key"}`)
+	expect(t, srv, "POST", "/batch/get", `{"a": 1}`, http.StatusBadRequest, "")
+	expect(t, srv, "POST", "/batch/set", `{"": 1}`, http.StatusBadRequest, "")
+	expect(t, srv, "POST", "/batch/delete", `[""]`, http.StatusBadRequest, "")
+	expect(t, srv, "PATCH", "/keys/a", `1`, http.StatusMethodNotAllowed, "")
+	expect(t, srv, "GET", "/other", "", http.StatusNotFound, "")
+}
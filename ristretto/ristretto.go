@@ -1,56 +1,170 @@
 package ristretto
 
 import (
-	"encoding/json"
-	"fmt"
+	"context"
+	"databases/kv"
+	"databases/stores"
+	"sync"
+	"time"
 
 	"github.com/dgraph-io/ristretto"
+	"github.com/philippgille/gokv"
 	"github.com/philippgille/gokv/encoding"
 	"github.com/philippgille/gokv/util"
 )
 
 // Store is a gokv.Store implementation for ristretto.
+//
+// Its atomic operations CompareAndSwap, SetIfAbsent and Increment are
+// best-effort: they are only atomic with respect to each other, not to
+// Set and Delete, ristretto may reject or evict a value at any time,
+// and a new key only becomes visible once ristretto applied its write.
 type Store struct {
 	Db    *ristretto.Cache
 	Codec encoding.Codec
+	// mu serializes the atomic operations.
+	mu *sync.Mutex
 }
 
 // Set stores the given value for the given key.
 func (s Store) Set(k string, v interface{}) error {
+	return s.SetContext(context.Background(), k, v)
+}
+
+// SetContext stores the given value for the given key
+// unless the context is done before the value is written.
+func (s Store) SetContext(ctx context.Context, k string, v interface{}) error {
+	return s.set(ctx, k, v, 0)
+}
+
+// SetWithTTL stores the given value for the given key.
+// The value expires after ttl. Like Set, the write is applied asynchronously.
+func (s Store) SetWithTTL(k string, v interface{}, ttl time.Duration) error {
+	return s.set(context.Background(), k, v, ttl)
+}
+
+func (s Store) set(ctx context.Context, k string, v interface{}, ttl time.Duration) error {
 	if err := util.CheckKeyAndValue(k, v); err != nil {
 		return err
 	}
-	s.Db.Set(k, v, 1)
+	data, err := s.Codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if ttl > 0 {
+		s.Db.SetWithTTL(k, data, cost(data), ttl)
+	} else {
+		s.Db.Set(k, data, cost(data))
+	}
 	return nil
+}
 
+// cost is the cost of a value in the cache: its encoded size,
+// so that MaxCost limits the memory the values take.
+func cost(data []byte) int64 {
+	return int64(len(data))
 }
 
 // Get retrieves the stored value for the given key.
 func (s Store) Get(k string, v interface{}) (found bool, err error) {
+	return s.GetContext(context.Background(), k, v)
+}
+
+// GetContext retrieves the stored value for the given key
+// unless the context is done before the value is read.
+func (s Store) GetContext(ctx context.Context, k string, v interface{}) (found bool, err error) {
 	if err := util.CheckKeyAndValue(k, v); err != nil {
 		return false, err
 	}
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
 	data, found := s.Db.Get(k)
 	if !found || data == nil {
-		return false, fmt.Errorf("value is missing")
+		return false, nil
 	}
-	b, err := json.Marshal(data)
-	if err != nil {
-		fmt.Println(err)
-		return false, err
-	}
-	return true, s.Codec.Unmarshal(b, v)
+	return true, s.Codec.Unmarshal(data.([]byte), v)
 }
 
 // Delete deletes the stored value for the given key.
 func (s Store) Delete(k string) error {
+	return s.DeleteContext(context.Background(), k)
+}
+
+// DeleteContext deletes the stored value for the given key
+// unless the context is done before the value is deleted.
+func (s Store) DeleteContext(ctx context.Context, k string) error {
 	if err := util.CheckKey(k); err != nil {
 		return err
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	s.Db.Del(k)
 	return nil
 }
 
+// SetMany stores the given values for their keys one by one,
+// because ristretto has no batches.
+func (s Store) SetMany(values map[string]interface{}) error {
+	return kv.SetEach(s, values)
+}
+
+// GetMany retrieves the stored values for the given keys one by one.
+func (s Store) GetMany(keys []string, vs []interface{}) (found []bool, err error) {
+	return kv.GetEach(s, keys, vs)
+}
+
+// DeleteMany deletes the stored values for the given keys one by one.
+func (s Store) DeleteMany(keys []string) error {
+	return kv.DeleteEach(s, keys)
+}
+
+// CompareAndSwap stores new for k if the stored value equals old
+// and reports whether it did.
+func (s Store) CompareAndSwap(k string, old, new interface{}) (swapped bool, err error) {
+	return kv.CompareAndSwap(s.modify, s.Codec, k, old, new)
+}
+
+// SetIfAbsent stores v for k if no value is stored for it
+// and reports whether it did.
+func (s Store) SetIfAbsent(k string, v interface{}) (set bool, err error) {
+	return kv.SetIfAbsent(s.modify, s.Codec, k, v)
+}
+
+// Increment adds delta to the int64 stored for k, or to 0 if none is,
+// and returns the result.
+func (s Store) Increment(k string, delta int64) (int64, error) {
+	return kv.Increment(s.modify, s.Codec, k, delta)
+}
+
+// modify implements kv.ModifyFunc while holding the lock
+// of the atomic operations.
+func (s Store) modify(k string, fn func(data []byte, found bool) ([]byte, bool, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var data []byte
+	cur, found := s.Db.Get(k)
+	if found = found && cur != nil; found {
+		data = cur.([]byte)
+	}
+	newData, write, err := fn(data, found)
+	if err != nil || !write {
+		return err
+	}
+	s.Db.Set(k, newData, cost(newData))
+	return nil
+}
+
+// Scan returns kv.ErrNotSupported, because ristretto doesn't keep its keys
+// in any order and can't enumerate them.
+func (s Store) Scan(prefix, start, end string) (kv.Iterator, error) {
+	return nil, kv.ErrNotSupported
+}
+
 // Close closes the store.
 func (s Store) Close() error {
 	s.Db.Close()
@@ -90,6 +204,18 @@ func NewStore(options *Options) (Store, error) {
 	result := Store{
 		Codec: options.Codec,
 		Db:    cache,
+		mu:    &sync.Mutex{},
 	}
 	return result, nil
 }
+
+// init registers the store as "ristretto" with package stores.
+func init() {
+	stores.Register("ristretto", func(cfg map[string]interface{}) (gokv.Store, error) {
+		options := DefaultOptions
+		if err := stores.DecodeOptions(cfg, &options); err != nil {
+			return nil, err
+		}
+		return NewStore(&options)
+	})
+}
@@ -1,10 +1,14 @@
 package ristretto
 
 import (
+	"databases/codec"
+	"databases/storetest"
 	"fmt"
 	"math/rand"
 	"testing"
 	"time"
+
+	"github.com/philippgille/gokv"
 )
 
 type NetworkStats struct {
@@ -38,6 +42,63 @@ var NS = NetworkStats{
 	},
 }
 
+func TestStore(t *testing.T) {
+	// ristretto applies writes asynchronously through its set buffer.
+	ops := storetest.DefaultOptions
+	ops.Eventual = true
+	storetest.TestStoreWithOptions(t, func(t *testing.T) gokv.Store {
+		s, err := NewStore(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}, &ops)
+}
+
+// TestCodecs checks that values are stored encoded with the configured
+// codec instead of as they are.
+func TestCodecs(t *testing.T) {
+	ops := storetest.DefaultOptions
+	ops.Eventual = true
+	for _, name := range []string{"gob", "binary", "json+flate"} {
+		c, err := codec.Lookup(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Run(name, func(t *testing.T) {
+			storetest.TestStoreWithOptions(t, func(t *testing.T) gokv.Store {
+				options := DefaultOptions
+				options.Codec = c
+				s, err := NewStore(&options)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return s
+			}, &ops)
+		})
+	}
+
+	// A stored value doesn't change with the value it was set from.
+	s, err := NewStore(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	v := []int{1}
+	s.Set("k", v)
+	v[0] = 2
+	var got []int
+	found := false
+	for start := time.Now(); !found && time.Since(start) < time.Second; {
+		if found, err = s.Get("k", &got); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !found || got[0] != 1 {
+		t.Errorf("got %v, %v, want [1]", got, found)
+	}
+}
+
 func BenchmarkSet(b *testing.B) {
 	s, err := NewStore(nil)
 	if err != nil {
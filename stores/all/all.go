@@ -0,0 +1,20 @@
+// Package all registers all stores of this repository with package
+// stores when it's imported:
+//
+//	import _ "databases/stores/all"
+package all
+
+import (
+	// The stores register themselves.
+	_ "databases/badgerdb"
+	_ "databases/bigcache"
+	_ "databases/memory"
+	_ "databases/mongodb"
+	_ "databases/moss"
+	_ "databases/nutsdb"
+	_ "databases/pudge"
+	_ "databases/redis"
+	_ "databases/ristretto"
+	_ "databases/tiered"
+	_ "databases/writebehind"
+)
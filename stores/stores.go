@@ -0,0 +1,232 @@
+// Package stores opens any of the stores by name with options from a
+// config map, so that the backend of a service can be chosen by its
+// deployment config instead of in code.
+//
+// Every store package registers itself when it's imported, like the
+// drivers of database/sql. Import databases/stores/all to register all:
+//
+//	import _ "databases/stores/all"
+//
+//	store, err := stores.Open("badgerdb", map[string]interface{}{
+//		"Dir":   "/var/lib/sensors",
+//		"codec": "json",
+//	})
+package stores
+
+import (
+	"bytes"
+	"databases/codec"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/philippgille/gokv"
+	"github.com/philippgille/gokv/encoding"
+	"gopkg.in/yaml.v3"
+)
+
+// OpenFunc creates a store with the options in cfg.
+type OpenFunc func(cfg map[string]interface{}) (gokv.Store, error)
+
+var (
+	mu       sync.RWMutex
+	registry = make(map[string]OpenFunc)
+)
+
+// Register makes a store available by the given name.
+// It panics if the name is empty or already registered, or if open is nil.
+func Register(name string, open OpenFunc) {
+	mu.Lock()
+	defer mu.Unlock()
+	if name == "" || open == nil {
+		panic("stores: Register with an empty name or a nil OpenFunc")
+	}
+	if _, dup := registry[name]; dup {
+		panic("stores: Register called twice for store " + name)
+	}
+	registry[name] = open
+}
+
+// Open creates the store registered with the given name.
+// cfg holds the fields of the store's Options by name, which default to
+// its DefaultOptions; see DecodeOptions. A nil cfg means DefaultOptions.
+func Open(name string, cfg map[string]interface{}) (gokv.Store, error) {
+	mu.RLock()
+	open, ok := registry[name]
+	mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("stores: unknown store %q (forgotten import?)", name)
+	}
+	s, err := open(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("stores: %s: %v", name, err)
+	}
+	return s, nil
+}
+
+// Names returns the names of the registered stores in sorted order.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var (
+	codecType    = reflect.TypeOf((*encoding.Codec)(nil)).Elem()
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// DecodeOptions sets the fields of options, a pointer to the Options of a
+// store, to the values in cfg with the matching names. Names are matched
+// case-insensitively like by encoding/json, and nested structs are maps.
+//
+// The "codec" is either an encoding.Codec or the name of a codec of
+// package codec, like "json" or "msgpack+gzip". A duration may be given
+// as a string like "10s". Names that aren't fields are an error.
+func DecodeOptions(cfg map[string]interface{}, options interface{}) error {
+	rv := reflect.ValueOf(options)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("stores: can't decode options into %T", options)
+	}
+	rv = rv.Elem()
+
+	rest := make(map[string]interface{}, len(cfg))
+	for name, value := range cfg {
+		field, ok := rv.Type().FieldByNameFunc(func(f string) bool {
+			return strings.EqualFold(f, name)
+		})
+		switch {
+		case ok && field.Type == codecType:
+			c, err := toCodec(value)
+			if err != nil {
+				return err
+			}
+			rv.FieldByIndex(field.Index).Set(reflect.ValueOf(c))
+		case ok && field.Type == durationType:
+			d, err := toDuration(value)
+			if err != nil {
+				return fmt.Errorf("stores: %s: %v", name, err)
+			}
+			rv.FieldByIndex(field.Index).SetInt(int64(d))
+		default:
+			rest[name] = value
+		}
+	}
+	if len(rest) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(rest)
+	if err != nil {
+		return fmt.Errorf("stores: %v", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(options); err != nil {
+		return fmt.Errorf("stores: %v", err)
+	}
+	return nil
+}
+
+func toCodec(value interface{}) (encoding.Codec, error) {
+	switch value := value.(type) {
+	case encoding.Codec:
+		return value, nil
+	case string:
+		return codec.Lookup(value)
+	}
+	return nil, fmt.Errorf("stores: codec is a %T, not a name", value)
+}
+
+func toDuration(value interface{}) (time.Duration, error) {
+	switch value := value.(type) {
+	case string:
+		return time.ParseDuration(value)
+	case time.Duration:
+		return value, nil
+	case int:
+		return time.Duration(value), nil
+	case int64:
+		return time.Duration(value), nil
+	case float64:
+		return time.Duration(value), nil
+	}
+	return 0, fmt.Errorf("%v is no duration", value)
+}
+
+// Config selects a store and its options, like in a config file:
+//
+//	backend: pudge
+//	options:
+//	  file: /var/lib/sensors/db
+//	  codec: json+flate
+type Config struct {
+	// Name of the store, like "badgerdb".
+	Backend string `json:"backend" yaml:"backend"`
+	// Fields of the store's Options, see DecodeOptions.
+	Options map[string]interface{} `json:"options" yaml:"options"`
+}
+
+// Open creates the store of the config.
+func (c Config) Open() (gokv.Store, error) {
+	return Open(c.Backend, c.Options)
+}
+
+// DecodeConfig converts the config of a store nested in the options of
+// another, like the store a wrapper wraps, to a Config. value is a Config
+// or a map with its fields.
+func DecodeConfig(value interface{}) (Config, error) {
+	if c, ok := value.(Config); ok {
+		return c, nil
+	}
+	var c Config
+	data, err := json.Marshal(value)
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, err
+	}
+	if c.Backend == "" {
+		return c, errors.New("the config has no backend")
+	}
+	return c, nil
+}
+
+// LoadConfig reads the config of a store from a file, see LoadFile.
+func LoadConfig(name string) (Config, error) {
+	var cfg Config
+	err := LoadFile(name, &cfg)
+	return cfg, err
+}
+
+// LoadFile decodes a YAML file, if its name ends in .yaml or .yml,
+// or else a JSON file into v.
+func LoadFile(name string, v interface{}) error {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, v)
+	default:
+		err = json.Unmarshal(data, v)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return nil
+}
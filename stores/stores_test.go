@@ -0,0 +1,137 @@
+package stores_test
+
+import (
+	"databases/codec"
+	"databases/pudge"
+	"databases/stores"
+	_ "databases/stores/all"
+	"databases/storetest"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/philippgille/gokv/encoding"
+)
+
+func TestNames(t *testing.T) {
+	want := []string{"badgerdb", "bigcache", "memory", "mongodb", "moss", "nutsdb", "pudge", "redis", "ristretto", "tiered", "writebehind"}
+	if got := stores.Names(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	s, err := stores.Open("memory", map[string]interface{}{"codec": "gob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	want := storetest.Value{SensorID: "sen1", TxBytes: 1}
+	if err := s.Set("sen1", want); err != nil {
+		t.Fatal(err)
+	}
+	var got storetest.Value
+	if found, err := s.Get("sen1", &got); err != nil || !found || got != want {
+		t.Errorf("got %+v, %v, %v", got, found, err)
+	}
+
+	if _, err := stores.Open("unknown", nil); err == nil {
+		t.Error("Open of an unknown store succeeded")
+	}
+	for _, cfg := range []map[string]interface{}{
+		{"Dri": "data"},
+		{"Dir": 1},
+		{"codec": "unknown"},
+		{"codec": 1},
+	} {
+		if _, err := stores.Open("badgerdb", cfg); err == nil {
+			t.Errorf("Open with %v succeeded", cfg)
+		}
+	}
+}
+
+func TestDecodeOptions(t *testing.T) {
+	type nested struct {
+		Size int
+	}
+	type options struct {
+		Name    string
+		Timeout time.Duration
+		Nested  nested
+		Codec   encoding.Codec
+	}
+	ops := options{Name: "default", Nested: nested{Size: 1}}
+	err := stores.DecodeOptions(map[string]interface{}{
+		"timeout": "1m30s",
+		"nested":  map[string]interface{}{"size": 2},
+		"codec":   codec.Binary,
+	}, &ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := options{Name: "default", Timeout: 90 * time.Second, Nested: nested{Size: 2}, Codec: codec.Binary}
+	if !reflect.DeepEqual(ops, want) {
+		t.Errorf("got %+v, want %+v", ops, want)
+	}
+	if err := stores.DecodeOptions(map[string]interface{}{"timeout": "soon"}, &ops); err == nil {
+		t.Error("decoding an invalid duration succeeded")
+	}
+	if err := stores.DecodeOptions(nil, ops); err == nil {
+		t.Error("decoding into a non-pointer succeeded")
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stores")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db := filepath.Join(dir, "db")
+	files := map[string]string{
+		"store.yaml": "backend: pudge\noptions:\n  file: " + db + "\n  codec: json+flate\n  config:\n    syncinterval: 1\n",
+		"store.json": `{"backend": "pudge", "options": {"file": "` + db + `", "codec": "json+flate", "config": {"SyncInterval": 1}}}`,
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			file := filepath.Join(dir, name)
+			if err := ioutil.WriteFile(file, []byte(content), 0666); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := stores.LoadConfig(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Backend != "pudge" || cfg.Options["file"] != db {
+				t.Fatalf("got %+v", cfg)
+			}
+			s, err := cfg.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Set("sen1", 1); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(db); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	// The config only applies to the opened store.
+	if pudge.DefaultOptions.Config.SyncInterval != 0 {
+		t.Error("the DefaultOptions of pudge changed")
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "bad.yml"), []byte("backend: [pudge"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stores.LoadConfig(filepath.Join(dir, "bad.yml")); err == nil {
+		t.Error("loading invalid YAML succeeded")
+	}
+}
@@ -0,0 +1,835 @@
+// Package storetest provides a backend-agnostic conformance suite for the
+// gokv.Store implementations in this repository.
+//
+// Every backend package calls TestStore from its own tests so that all of
+// them are held to the same Set/Get/Delete/Close contract.
+package storetest
+
+import (
+	"context"
+	"databases/kv"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/philippgille/gokv"
+)
+
+// Value is the value type stored by the conformance suite.
+type Value struct {
+	SensorID string `json:"sensor_id"`
+	TxBytes  int64  `json:"tx_bytes"`
+	RxBytes  int64  `json:"rx_bytes"`
+}
+
+// NewStoreFunc creates a new, empty store for a single test.
+// The suite closes the store; any other cleanup, like removing
+// the database directory, must be registered with t.Cleanup.
+type NewStoreFunc func(t *testing.T) gokv.Store
+
+// Options are the options for the conformance suite.
+type Options struct {
+	// Eventual marks stores whose writes become visible asynchronously,
+	// like ristretto. Reads after a write are retried until Timeout.
+	Eventual bool
+	// Timeout for eventually visible writes.
+	Timeout time.Duration
+}
+
+// DefaultOptions is an Options object with default values.
+var DefaultOptions = Options{
+	Timeout: time.Second,
+}
+
+// TestStore runs the conformance suite against stores created by newStore.
+func TestStore(t *testing.T, newStore NewStoreFunc) {
+	TestStoreWithOptions(t, newStore, &DefaultOptions)
+}
+
+// TestStoreWithOptions runs the conformance suite with the given options.
+func TestStoreWithOptions(t *testing.T, newStore NewStoreFunc, options *Options) {
+	if options == nil {
+		options = &DefaultOptions
+	}
+	s := suite{newStore: newStore, opts: *options}
+	if s.opts.Timeout <= 0 {
+		s.opts.Timeout = DefaultOptions.Timeout
+	}
+
+	t.Run("GetMissing", s.testGetMissing)
+	t.Run("SetGet", s.testSetGet)
+	t.Run("Overwrite", s.testOverwrite)
+	t.Run("Delete", s.testDelete)
+	t.Run("DeleteMissing", s.testDeleteMissing)
+	t.Run("InvalidKey", s.testInvalidKey)
+	t.Run("InvalidValue", s.testInvalidValue)
+	t.Run("Concurrent", s.testConcurrent)
+	t.Run("Close", s.testClose)
+	t.Run("Context", s.testContext)
+	t.Run("TTL", s.testTTL)
+	t.Run("Scan", s.testScan)
+	t.Run("Batch", s.testBatch)
+	t.Run("Transaction", s.testTransaction)
+	t.Run("TransactionTTL", s.testTransactionTTL)
+	t.Run("Atomic", s.testAtomic)
+	t.Run("Watch", s.testWatch)
+}
+
+type suite struct {
+	newStore NewStoreFunc
+	opts     Options
+}
+
+// open creates a store that is closed when the test finishes.
+func (s suite) open(t *testing.T) gokv.Store {
+	store := s.newStore(t)
+	t.Cleanup(func() {
+		if err := store.Close(); err != nil {
+			t.Errorf("Close returned an error: %v", err)
+		}
+	})
+	return store
+}
+
+func (s suite) testGetMissing(t *testing.T) {
+	store := s.open(t)
+
+	v := new(Value)
+	found, err := store.Get("missing", v)
+	if err != nil {
+		t.Fatalf("Get of a missing key returned an error: %v", err)
+	}
+	if found {
+		t.Fatal("Get of a missing key returned found=true")
+	}
+}
+
+func (s suite) testSetGet(t *testing.T) {
+	store := s.open(t)
+
+	want := Value{SensorID: "sen1", TxBytes: 123, RxBytes: 566}
+	if err := store.Set("sen1", want); err != nil {
+		t.Fatal(err)
+	}
+	s.expect(t, store, "sen1", want)
+
+	// Pointers to values must be accepted as well.
+	if err := store.Set("sen2", &want); err != nil {
+		t.Fatal(err)
+	}
+	s.expect(t, store, "sen2", want)
+}
+
+func (s suite) testOverwrite(t *testing.T) {
+	store := s.open(t)
+
+	if err := store.Set("sen1", Value{SensorID: "old"}); err != nil {
+		t.Fatal(err)
+	}
+	s.expect(t, store, "sen1", Value{SensorID: "old"})
+
+	want := Value{SensorID: "new", TxBytes: 1}
+	if err := store.Set("sen1", want); err != nil {
+		t.Fatal(err)
+	}
+	s.expect(t, store, "sen1", want)
+}
+
+func (s suite) testDelete(t *testing.T) {
+	store := s.open(t)
+
+	if err := store.Set("sen1", Value{SensorID: "sen1"}); err != nil {
+		t.Fatal(err)
+	}
+	s.expect(t, store, "sen1", Value{SensorID: "sen1"})
+
+	if err := store.Delete("sen1"); err != nil {
+		t.Fatal(err)
+	}
+	s.expectMissing(t, store, "sen1")
+}
+
+func (s suite) testDeleteMissing(t *testing.T) {
+	store := s.open(t)
+
+	if err := store.Delete("missing"); err != nil {
+		t.Fatalf("Delete of a missing key returned an error: %v", err)
+	}
+}
+
+func (s suite) testInvalidKey(t *testing.T) {
+	store := s.open(t)
+
+	if err := store.Set("", Value{}); err == nil {
+		t.Error("Set with an empty key returned no error")
+	}
+	if _, err := store.Get("", new(Value)); err == nil {
+		t.Error("Get with an empty key returned no error")
+	}
+	if err := store.Delete(""); err == nil {
+		t.Error("Delete with an empty key returned no error")
+	}
+}
+
+func (s suite) testInvalidValue(t *testing.T) {
+	store := s.open(t)
+
+	if err := store.Set("sen1", nil); err == nil {
+		t.Error("Set with a nil value returned no error")
+	}
+	if _, err := store.Get("sen1", nil); err == nil {
+		t.Error("Get with a nil value returned no error")
+	}
+}
+
+func (s suite) testConcurrent(t *testing.T) {
+	store := s.open(t)
+
+	const (
+		goroutines = 8
+		iterations = 100
+	)
+	var wg sync.WaitGroup
+	errs := make(chan error, goroutines)
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				// Every goroutine writes its own keys and a shared one.
+				own := fmt.Sprintf("sen%d-%d", g, i)
+				if err := store.Set(own, Value{SensorID: own}); err != nil {
+					errs <- err
+					return
+				}
+				if err := store.Set("shared", Value{SensorID: own}); err != nil {
+					errs <- err
+					return
+				}
+				if _, err := store.Get("shared", new(Value)); err != nil {
+					errs <- err
+					return
+				}
+				if _, err := store.Get(own, new(Value)); err != nil {
+					errs <- err
+					return
+				}
+				if i%2 == 0 {
+					if err := store.Delete(own); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if t.Failed() {
+		return
+	}
+
+	for g := 0; g < goroutines; g++ {
+		k := fmt.Sprintf("sen%d-%d", g, iterations-1)
+		s.expect(t, store, k, Value{SensorID: k})
+	}
+}
+
+func (s suite) testClose(t *testing.T) {
+	store := s.newStore(t)
+
+	if err := store.Set("sen1", Value{SensorID: "sen1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close returned an error: %v", err)
+	}
+}
+
+// testContext checks the kv.ContextStore methods, if the store has them.
+func (s suite) testContext(t *testing.T) {
+	store := s.open(t)
+	cs, ok := store.(kv.ContextStore)
+	if !ok {
+		t.Skip("store doesn't implement kv.ContextStore")
+	}
+
+	ctx := context.Background()
+	want := Value{SensorID: "sen1"}
+	if err := cs.SetContext(ctx, "sen1", want); err != nil {
+		t.Fatal(err)
+	}
+	s.expect(t, store, "sen1", want)
+	got := new(Value)
+	if _, err := cs.GetContext(ctx, "sen1", got); err != nil {
+		t.Fatal(err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := cs.SetContext(cancelled, "sen2", want); !errors.Is(err, context.Canceled) {
+		t.Errorf("SetContext with a cancelled context returned %v, want %v", err, context.Canceled)
+	}
+	if _, err := cs.GetContext(cancelled, "sen1", new(Value)); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetContext with a cancelled context returned %v, want %v", err, context.Canceled)
+	}
+	if err := cs.DeleteContext(cancelled, "sen1"); !errors.Is(err, context.Canceled) {
+		t.Errorf("DeleteContext with a cancelled context returned %v, want %v", err, context.Canceled)
+	}
+	// Nothing must have been changed by the cancelled calls.
+	s.expectMissing(t, store, "sen2")
+	s.expect(t, store, "sen1", want)
+
+	if err := cs.DeleteContext(ctx, "sen1"); err != nil {
+		t.Fatal(err)
+	}
+	s.expectMissing(t, store, "sen1")
+}
+
+// testTTL checks kv.TTLStore.SetWithTTL, if the store has it.
+// Some stores track expiry in whole seconds, so the test waits
+// for TTLs of a few seconds to pass.
+func (s suite) testTTL(t *testing.T) {
+	store := s.open(t)
+	ts, ok := store.(kv.TTLStore)
+	if !ok {
+		t.Skip("store doesn't implement kv.TTLStore")
+	}
+
+	const ttl = 2 * time.Second
+	if err := ts.SetWithTTL("long", Value{SensorID: "long"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.SetWithTTL("none", Value{SensorID: "none"}, 0); err != nil {
+		t.Fatal(err)
+	}
+	// A Set after SetWithTTL removes the expiry.
+	if err := ts.SetWithTTL("reset", Value{SensorID: "old"}, ttl); err != nil {
+		t.Fatal(err)
+	}
+	s.expect(t, store, "reset", Value{SensorID: "old"})
+	if err := store.Set("reset", Value{SensorID: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	s.expect(t, store, "reset", Value{SensorID: "new"})
+	if err := ts.SetWithTTL("short", Value{SensorID: "short"}, ttl); err != nil {
+		t.Fatal(err)
+	}
+	s.expect(t, store, "short", Value{SensorID: "short"})
+	s.expect(t, store, "long", Value{SensorID: "long"})
+	s.expect(t, store, "none", Value{SensorID: "none"})
+
+	deadline := time.Now().Add(2 * ttl)
+	for {
+		found, err := store.Get("short", new(Value))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !found {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("value still found %v after it was set with a TTL of %v", 2*ttl, ttl)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.expect(t, store, "reset", Value{SensorID: "new"})
+	s.expect(t, store, "long", Value{SensorID: "long"})
+}
+
+// testScan checks kv.Scanner.Scan, if the store supports it.
+func (s suite) testScan(t *testing.T) {
+	store := s.open(t)
+	sc, ok := store.(kv.Scanner)
+	if !ok {
+		t.Skip("store doesn't implement kv.Scanner")
+	}
+	if it, err := sc.Scan("", "", ""); err == kv.ErrNotSupported {
+		t.Skip("store doesn't support scans")
+	} else if err != nil {
+		t.Fatal(err)
+	} else {
+		it.Close()
+	}
+
+	for _, k := range []string{"b2", "a1", "deleted", "a3", "b1", "a2"} {
+		if err := store.Set(k, Value{SensorID: k}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Delete("deleted"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		prefix, start, end string
+		want               []string
+	}{
+		{"", "", "", []string{"a1", "a2", "a3", "b1", "b2"}},
+		{"a", "", "", []string{"a1", "a2", "a3"}},
+		{"", "a2", "b2", []string{"a2", "a3", "b1"}},
+		{"a", "a2", "", []string{"a2", "a3"}},
+		{"b", "", "b2", []string{"b1"}},
+		{"c", "", "", nil},
+	}
+	for _, test := range tests {
+		it, err := sc.Scan(test.prefix, test.start, test.end)
+		if err != nil {
+			t.Fatalf("Scan(%q, %q, %q): %v", test.prefix, test.start, test.end, err)
+		}
+		var got []string
+		for it.Next() {
+			v := new(Value)
+			if err := it.Value(v); err != nil {
+				t.Fatal(err)
+			}
+			if v.SensorID != it.Key() {
+				t.Errorf("Scan(%q, %q, %q): value of %q is %+v", test.prefix, test.start, test.end, it.Key(), *v)
+			}
+			got = append(got, it.Key())
+		}
+		if err := it.Err(); err != nil {
+			t.Error(err)
+		}
+		if err := it.Close(); err != nil {
+			t.Error(err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("Scan(%q, %q, %q) = %v, want %v", test.prefix, test.start, test.end, got, test.want)
+		}
+	}
+}
+
+// testBatch checks the kv.BatchStore methods, if the store has them.
+func (s suite) testBatch(t *testing.T) {
+	store := s.open(t)
+	bs, ok := store.(kv.BatchStore)
+	if !ok {
+		t.Skip("store doesn't implement kv.BatchStore")
+	}
+
+	values := map[string]interface{}{
+		"sen1": Value{SensorID: "sen1"},
+		"sen2": &Value{SensorID: "sen2"},
+		"sen3": Value{SensorID: "sen3"},
+	}
+	if err := bs.SetMany(values); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"sen1", "sen2", "sen3"} {
+		s.expect(t, store, k, Value{SensorID: k})
+	}
+
+	keys := []string{"sen1", "missing", "sen3"}
+	vs := []interface{}{new(Value), new(Value), new(Value)}
+	found, err := bs.GetMany(keys, vs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(found) != "[true false true]" {
+		t.Fatalf("GetMany(%q) found %v, want [true false true]", keys, found)
+	}
+	if *vs[0].(*Value) != (Value{SensorID: "sen1"}) || *vs[2].(*Value) != (Value{SensorID: "sen3"}) {
+		t.Errorf("GetMany(%q) = %+v, %+v", keys, vs[0], vs[2])
+	}
+	if _, err := bs.GetMany(keys, vs[:1]); err == nil {
+		t.Error("GetMany with fewer values than keys returned no error")
+	}
+
+	if err := bs.DeleteMany([]string{"sen1", "missing", "sen2"}); err != nil {
+		t.Fatal(err)
+	}
+	s.expectMissing(t, store, "sen1")
+	s.expectMissing(t, store, "sen2")
+	found, err = bs.GetMany([]string{"sen1", "sen2", "sen3"}, []interface{}{new(Value), new(Value), new(Value)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(found) != "[false false true]" {
+		t.Errorf("GetMany after DeleteMany found %v, want [false false true]", found)
+	}
+
+	if err := bs.SetMany(map[string]interface{}{"": Value{}}); err == nil {
+		t.Error("SetMany with an empty key returned no error")
+	}
+	if err := bs.DeleteMany([]string{""}); err == nil {
+		t.Error("DeleteMany with an empty key returned no error")
+	}
+}
+
+// testTransaction checks the kv.Transactional methods, if the store has them.
+func (s suite) testTransaction(t *testing.T) {
+	store := s.open(t)
+	ts, ok := store.(kv.Transactional)
+	if !ok {
+		t.Skip("store doesn't implement kv.Transactional")
+	}
+
+	if err := store.Set("old", Value{SensorID: "old"}); err != nil {
+		t.Fatal(err)
+	}
+	err := ts.Update(func(tx kv.Txn) error {
+		if err := tx.Set("sen1", Value{SensorID: "sen1"}); err != nil {
+			return err
+		}
+		if err := tx.Set("index", Value{SensorID: "sen1"}); err != nil {
+			return err
+		}
+		if err := tx.Delete("old"); err != nil {
+			return err
+		}
+		// The transaction sees its own writes.
+		got := new(Value)
+		if found, err := tx.Get("sen1", got); err != nil || !found || got.SensorID != "sen1" {
+			t.Errorf("Get of a key set in the transaction = %v, %+v, %v", found, *got, err)
+		}
+		if found, err := tx.Get("old", new(Value)); err != nil || found {
+			t.Errorf("Get of a key deleted in the transaction = %v, %v", found, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.expect(t, store, "sen1", Value{SensorID: "sen1"})
+	s.expect(t, store, "index", Value{SensorID: "sen1"})
+	s.expectMissing(t, store, "old")
+
+	// An error rolls the transaction back.
+	errRollback := errors.New("rollback")
+	err = ts.Update(func(tx kv.Txn) error {
+		if err := tx.Set("sen1", Value{SensorID: "changed"}); err != nil {
+			return err
+		}
+		if err := tx.Set("sen2", Value{SensorID: "sen2"}); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if err != errRollback {
+		t.Fatalf("Update returned %v, want %v", err, errRollback)
+	}
+	s.expect(t, store, "sen1", Value{SensorID: "sen1"})
+	s.expectMissing(t, store, "sen2")
+
+	err = ts.View(func(tx kv.Txn) error {
+		got := new(Value)
+		if found, err := tx.Get("index", got); err != nil || !found || got.SensorID != "sen1" {
+			t.Errorf("Get in View = %v, %+v, %v", found, *got, err)
+		}
+		if err := tx.Set("sen2", Value{}); err == nil {
+			t.Error("Set in View returned no error")
+		}
+		if err := tx.Delete("sen1"); err == nil {
+			t.Error("Delete in View returned no error")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.expect(t, store, "sen1", Value{SensorID: "sen1"})
+
+	s.testTransactionIncrements(t, ts)
+}
+
+// testTransactionTTL checks kv.TTLTxn.SetWithTTL,
+// if the transactions of the store implement it.
+func (s suite) testTransactionTTL(t *testing.T) {
+	store := s.open(t)
+	ts, ok := store.(kv.Transactional)
+	if !ok {
+		t.Skip("store doesn't implement kv.Transactional")
+	}
+
+	const ttl = 2 * time.Second
+	if err := store.Set("reset", Value{SensorID: "old"}); err != nil {
+		t.Fatal(err)
+	}
+	err := ts.Update(func(tx kv.Txn) error {
+		tt, ok := tx.(kv.TTLTxn)
+		if !ok {
+			t.Skip("transaction doesn't implement kv.TTLTxn")
+		}
+		if err := tt.SetWithTTL("short", Value{SensorID: "short"}, ttl); err != nil {
+			return err
+		}
+		if err := tt.SetWithTTL("long", Value{SensorID: "long"}, time.Hour); err != nil {
+			return err
+		}
+		// A Set after SetWithTTL removes the expiry.
+		if err := tt.SetWithTTL("reset", Value{SensorID: "new"}, ttl); err != nil {
+			return err
+		}
+		return tt.Set("reset", Value{SensorID: "new"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.expect(t, store, "short", Value{SensorID: "short"})
+
+	deadline := time.Now().Add(2 * ttl)
+	for {
+		found, err := store.Get("short", new(Value))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !found {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("value still found %v after it was set with a TTL of %v", 2*ttl, ttl)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.expect(t, store, "reset", Value{SensorID: "new"})
+	s.expect(t, store, "long", Value{SensorID: "long"})
+}
+
+// testTransactionIncrements increments a counter in concurrent
+// transactions, which are retried on conflicts. No increment may be lost.
+func (s suite) testTransactionIncrements(t *testing.T, ts kv.Transactional) {
+	const (
+		goroutines = 4
+		iterations = 25
+	)
+	var wg sync.WaitGroup
+	errs := make(chan error, goroutines)
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				for {
+					err := ts.Update(func(tx kv.Txn) error {
+						v := new(Value)
+						if _, err := tx.Get("counter", v); err != nil {
+							return err
+						}
+						v.TxBytes++
+						return tx.Set("counter", v)
+					})
+					if err == kv.ErrConflict {
+						continue
+					}
+					if err != nil {
+						errs <- err
+						return
+					}
+					break
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	s.expect(t, ts, "counter", Value{TxBytes: goroutines * iterations})
+}
+
+// testAtomic checks the kv.AtomicStore methods, if the store has them.
+func (s suite) testAtomic(t *testing.T) {
+	store := s.open(t)
+	as, ok := store.(kv.AtomicStore)
+	if !ok {
+		t.Skip("store doesn't implement kv.AtomicStore")
+	}
+
+	v1, v2, v3 := Value{SensorID: "v1"}, Value{SensorID: "v2"}, Value{SensorID: "v3"}
+	if set, err := as.SetIfAbsent("sen1", v1); err != nil || !set {
+		t.Fatalf("SetIfAbsent of a missing key = %v, %v", set, err)
+	}
+	s.expect(t, store, "sen1", v1)
+	if set, err := as.SetIfAbsent("sen1", v2); err != nil || set {
+		t.Fatalf("SetIfAbsent of an existing key = %v, %v", set, err)
+	}
+	s.expect(t, store, "sen1", v1)
+
+	if swapped, err := as.CompareAndSwap("sen1", v2, v3); err != nil || swapped {
+		t.Fatalf("CompareAndSwap with a wrong old value = %v, %v", swapped, err)
+	}
+	s.expect(t, store, "sen1", v1)
+	if swapped, err := as.CompareAndSwap("sen1", v1, v3); err != nil || !swapped {
+		t.Fatalf("CompareAndSwap with the right old value = %v, %v", swapped, err)
+	}
+	s.expect(t, store, "sen1", v3)
+	if swapped, err := as.CompareAndSwap("missing", v1, v2); err != nil || swapped {
+		t.Fatalf("CompareAndSwap of a missing key = %v, %v", swapped, err)
+	}
+
+	if n, err := as.Increment("counter", 5); err != nil || n != 5 {
+		t.Fatalf("Increment of a missing key = %v, %v, want 5", n, err)
+	}
+	s.expectCounter(t, store, 5)
+	if n, err := as.Increment("counter", -2); err != nil || n != 3 {
+		t.Fatalf("Increment = %v, %v, want 3", n, err)
+	}
+	s.expectCounter(t, store, 3)
+	if _, err := as.Increment("sen1", 1); err == nil {
+		t.Error("Increment of a non-integer value returned no error")
+	}
+
+	const (
+		goroutines = 8
+		iterations = 50
+	)
+	var wg sync.WaitGroup
+	errs := make(chan error, goroutines)
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				if _, err := as.Increment("counter", 1); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	s.expectCounter(t, store, 3+goroutines*iterations)
+}
+
+// expectCounter fails the test unless the counter incremented
+// by testAtomic equals want.
+func (s suite) expectCounter(t *testing.T, store gokv.Store, want int64) {
+	t.Helper()
+	deadline := time.Now().Add(s.opts.Timeout)
+	for {
+		var got int64
+		found, err := store.Get("counter", &got)
+		if err != nil {
+			t.Fatalf("Get(%q): %v", "counter", err)
+		}
+		if found && got == want {
+			return
+		}
+		if !s.opts.Eventual || time.Now().After(deadline) {
+			t.Fatalf("Get(%q) = %v, %v, want %v", "counter", got, found, want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// testWatch checks kv.Watcher.Watch, if the store has it.
+func (s suite) testWatch(t *testing.T) {
+	store := s.open(t)
+	w, ok := store.(kv.Watcher)
+	if !ok {
+		t.Skip("store doesn't implement kv.Watcher")
+	}
+
+	events, stop := w.Watch("sen")
+	defer stop()
+	if err := store.Set("sen1", Value{SensorID: "sen1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("other", Value{SensorID: "other"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete("sen1"); err != nil {
+		t.Fatal(err)
+	}
+	if e := s.nextEvent(t, events, time.Second); e.Type != kv.EventSet || e.Key != "sen1" || e.Value == nil {
+		t.Errorf("first event = %v %q %q, want a set of sen1", e.Type, e.Key, e.Value)
+	}
+	if e := s.nextEvent(t, events, time.Second); e.Type != kv.EventDelete || e.Key != "sen1" {
+		t.Errorf("second event = %v %q, want a delete of sen1", e.Type, e.Key)
+	}
+
+	if ts, ok := store.(kv.TTLStore); ok {
+		const ttl = time.Second
+		if err := ts.SetWithTTL("sen2", Value{SensorID: "sen2"}, ttl); err != nil {
+			t.Fatal(err)
+		}
+		if e := s.nextEvent(t, events, time.Second); e.Type != kv.EventSet || e.Key != "sen2" {
+			t.Errorf("event = %v %q, want a set of sen2", e.Type, e.Key)
+		}
+		// Some stores track expiry in whole seconds.
+		if e := s.nextEvent(t, events, ttl+2*time.Second); e.Type != kv.EventExpire || e.Key != "sen2" {
+			t.Errorf("event = %v %q, want an expiry of sen2", e.Type, e.Key)
+		}
+	}
+
+	stop()
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return
+			}
+		case <-time.After(time.Second):
+			t.Fatal("events channel not closed after stopping the watch")
+		}
+	}
+}
+
+// nextEvent returns the next event, or fails the test
+// if none arrives within timeout.
+func (s suite) nextEvent(t *testing.T, events <-chan kv.Event, timeout time.Duration) kv.Event {
+	t.Helper()
+	select {
+	case e, ok := <-events:
+		if !ok {
+			t.Fatal("events channel closed")
+		}
+		return e
+	case <-time.After(timeout):
+		t.Fatal("no event")
+	}
+	return kv.Event{}
+}
+
+// expect fails the test unless the value stored for k equals want.
+func (s suite) expect(t *testing.T, store gokv.Store, k string, want Value) {
+	t.Helper()
+	deadline := time.Now().Add(s.opts.Timeout)
+	for {
+		got := new(Value)
+		found, err := store.Get(k, got)
+		if err != nil {
+			t.Fatalf("Get(%q): %v", k, err)
+		}
+		if found && *got == want {
+			return
+		}
+		if !s.opts.Eventual || time.Now().After(deadline) {
+			if !found {
+				t.Fatalf("Get(%q): value not found", k)
+			}
+			t.Fatalf("Get(%q) = %+v, want %+v", k, *got, want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// expectMissing fails the test unless no value is stored for k.
+func (s suite) expectMissing(t *testing.T, store gokv.Store, k string) {
+	t.Helper()
+	deadline := time.Now().Add(s.opts.Timeout)
+	for {
+		found, err := store.Get(k, new(Value))
+		if err != nil {
+			t.Fatalf("Get(%q): %v", k, err)
+		}
+		if !found {
+			return
+		}
+		if !s.opts.Eventual || time.Now().After(deadline) {
+			t.Fatalf("Get(%q): value still found", k)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
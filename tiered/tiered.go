@@ -0,0 +1,510 @@
+// Package tiered composes two stores into one: a fast, usually volatile
+// front store, like ristretto or bigcache, that caches the values of a
+// slow, persistent back store, like badgerdb or pudge.
+//
+// Reads are served by the front store if it has the value, and otherwise
+// by the back store, whose value is then promoted into the front store.
+// How writes reach the back store depends on the Mode. Misses can be
+// remembered for a while, so that reads of missing keys don't reach the
+// back store either.
+//
+// Every writer of the back store must use the tiered.Store, as the front
+// store and the remembered misses only see the changes made through it.
+package tiered
+
+import (
+	"databases/kv"
+	"databases/stores"
+	"databases/writebehind"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/philippgille/gokv"
+	"github.com/philippgille/gokv/encoding"
+	"github.com/philippgille/gokv/util"
+)
+
+// Mode is how writes reach the back store.
+type Mode int
+
+// The modes.
+const (
+	// WriteThrough writes to the back store and then to the front store,
+	// so that values are cached as soon as they are written.
+	WriteThrough Mode = iota
+	// ReadThrough writes to the back store and removes the value from
+	// the front store, so that only values that are read are cached.
+	ReadThrough
+	// WriteBack writes to the front store and queues the changes for the
+	// back store in a writebehind.Store, which writes them in the
+	// background, every FlushInterval or once MaxDirty keys changed, and
+	// on Flush and Close. Queued changes are lost if the process dies.
+	WriteBack
+)
+
+var modeNames = []string{"write-through", "read-through", "write-back"}
+
+func (m Mode) String() string {
+	if m < 0 || int(m) >= len(modeNames) {
+		return fmt.Sprintf("Mode(%d)", int(m))
+	}
+	return modeNames[m]
+}
+
+// MarshalText returns the name of the mode.
+func (m Mode) MarshalText() ([]byte, error) {
+	if m < 0 || int(m) >= len(modeNames) {
+		return nil, fmt.Errorf("tiered: invalid mode %d", int(m))
+	}
+	return []byte(modeNames[m]), nil
+}
+
+// UnmarshalText sets the mode with the given name, like "write-back".
+func (m *Mode) UnmarshalText(text []byte) error {
+	for i, name := range modeNames {
+		if string(text) == name {
+			*m = Mode(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("tiered: unknown mode %q", text)
+}
+
+// Stats are the counters of a tiered store.
+type Stats struct {
+	// Reads served by the front store.
+	FrontHits int64
+	// Reads served by the back store, including, in WriteBack mode,
+	// by changes that aren't written to it yet.
+	BackHits int64
+	// Reads of missing keys that reached the back store.
+	Misses int64
+	// Reads of missing keys that were answered by the remembered misses.
+	NegativeHits int64
+	// Values copied into the front store after they were read from the back store.
+	Promotions int64
+	// Changes written from the front tier to the back store in WriteBack mode.
+	Demotions int64
+	// Changes that aren't written to the back store yet.
+	Dirty int
+}
+
+// numLocks is the number of locks that the keys are spread over.
+const numLocks = 256
+
+// Store is a gokv.Store that caches the values of a back store
+// in a front store.
+type Store struct {
+	Front gokv.Store
+	// Back is the back store, wrapped by a writebehind.Store in WriteBack mode.
+	Back gokv.Store
+	mode Mode
+	// frontTTL is the TTL of promoted values, if the front store supports it.
+	frontTTL time.Duration
+	state    *state
+}
+
+// state is the state that the copies of a Store share.
+type state struct {
+	// The counters of Stats, updated atomically.
+	frontHits, backHits, misses, negativeHits, promotions int64
+
+	// locks serialize the writes of a key with the reads that promote it,
+	// so that a read can't promote a value that a write just replaced.
+	locks [numLocks]sync.RWMutex
+
+	mu sync.Mutex
+	// Remembered misses and when they are forgotten.
+	negative    map[string]time.Time
+	negativeTTL time.Duration
+	maxNegative int
+	// Deadlines of the values written with a TTL,
+	// so that they are promoted with the rest of it.
+	deadlines map[string]time.Time
+	sweepAt   int
+
+	closeOnce sync.Once
+}
+
+func (s *state) lock(k string) *sync.RWMutex {
+	h := fnv.New32a()
+	h.Write([]byte(k))
+	return &s.locks[h.Sum32()%numLocks]
+}
+
+// written records that k was written with the deadline.
+func (s *state) written(k string, deadline time.Time, deleted bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if deleted {
+		s.remember(k)
+	} else {
+		delete(s.negative, k)
+	}
+	if deadline.IsZero() {
+		delete(s.deadlines, k)
+		return
+	}
+	s.deadlines[k] = deadline
+	// Forget the deadlines that passed whenever the map doubled.
+	if len(s.deadlines) >= s.sweepAt {
+		now := time.Now()
+		for k, d := range s.deadlines {
+			if kv.Expired(d, now) {
+				delete(s.deadlines, k)
+			}
+		}
+		s.sweepAt = 2*len(s.deadlines) + 1024
+	}
+}
+
+// deadline returns the deadline of the value of k, if it has one.
+func (s *state) deadline(k string) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.deadlines[k]
+}
+
+// rememberMiss remembers that k is missing.
+func (s *state) rememberMiss(k string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remember(k)
+}
+
+func (s *state) remember(k string) {
+	if s.negativeTTL <= 0 {
+		return
+	}
+	if len(s.negative) >= s.maxNegative {
+		s.negative = make(map[string]time.Time)
+	}
+	s.negative[k] = time.Now().Add(s.negativeTTL)
+}
+
+// knownMiss reports whether k is remembered as missing.
+func (s *state) knownMiss(k string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	until, ok := s.negative[k]
+	if ok && kv.Expired(until, time.Now()) {
+		delete(s.negative, k)
+		return false
+	}
+	return ok
+}
+
+// Set stores the given value for the given key.
+func (s Store) Set(k string, v interface{}) error {
+	return s.set(k, v, 0)
+}
+
+// SetWithTTL stores the given value for the given key.
+// The value expires after ttl. It returns kv.ErrNotSupported
+// if the back store doesn't implement kv.TTLStore.
+// The value is only cached by front stores that implement it, too.
+func (s Store) SetWithTTL(k string, v interface{}, ttl time.Duration) error {
+	if _, ok := s.Back.(kv.TTLStore); !ok {
+		return kv.ErrNotSupported
+	}
+	return s.set(k, v, ttl)
+}
+
+func (s Store) set(k string, v interface{}, ttl time.Duration) error {
+	if err := util.CheckKeyAndValue(k, v); err != nil {
+		return err
+	}
+	deadline := kv.Deadline(ttl)
+	l := s.state.lock(k)
+	l.Lock()
+	defer l.Unlock()
+
+	var err error
+	if ttl > 0 {
+		err = s.Back.(kv.TTLStore).SetWithTTL(k, v, ttl)
+	} else {
+		err = s.Back.Set(k, v)
+	}
+	if err != nil {
+		return err
+	}
+	s.state.written(k, deadline, false)
+	if s.mode == ReadThrough {
+		return s.Front.Delete(k)
+	}
+	return s.setFront(k, v, deadline)
+}
+
+// setFront caches v for k until the deadline, if the front store can.
+// If caching fails, the value of k is removed from the front store,
+// so that it doesn't keep serving an older one.
+func (s Store) setFront(k string, v interface{}, deadline time.Time) error {
+	var err error
+	ts, ok := s.Front.(kv.TTLStore)
+	switch ttl := time.Until(deadline); {
+	case deadline.IsZero():
+		err = s.Front.Set(k, v)
+	case !ok || ttl <= 0:
+		return s.Front.Delete(k)
+	default:
+		err = ts.SetWithTTL(k, v, ttl)
+	}
+	if err != nil {
+		s.Front.Delete(k)
+	}
+	return err
+}
+
+// Get retrieves the stored value for the given key.
+func (s Store) Get(k string, v interface{}) (found bool, err error) {
+	if err := util.CheckKeyAndValue(k, v); err != nil {
+		return false, err
+	}
+	if found, err := s.Front.Get(k, v); err != nil || found {
+		if found {
+			atomic.AddInt64(&s.state.frontHits, 1)
+		}
+		return found, err
+	}
+
+	l := s.state.lock(k)
+	l.RLock()
+	defer l.RUnlock()
+	if s.state.knownMiss(k) {
+		atomic.AddInt64(&s.state.negativeHits, 1)
+		return false, nil
+	}
+	found, err = s.Back.Get(k, v)
+	if err != nil {
+		return false, err
+	}
+	if !found {
+		atomic.AddInt64(&s.state.misses, 1)
+		s.state.rememberMiss(k)
+		return false, nil
+	}
+	atomic.AddInt64(&s.state.backHits, 1)
+	return true, s.promote(k, v)
+}
+
+// promote caches the value v of k that was read from the back store.
+func (s Store) promote(k string, v interface{}) error {
+	deadline := s.state.deadline(k)
+	if deadline.IsZero() && s.frontTTL > 0 {
+		if _, ok := s.Front.(kv.TTLStore); ok {
+			deadline = kv.Deadline(s.frontTTL)
+		}
+	}
+	if err := s.setFront(k, v, deadline); err != nil {
+		return err
+	}
+	atomic.AddInt64(&s.state.promotions, 1)
+	return nil
+}
+
+// Delete deletes the stored value for the given key.
+func (s Store) Delete(k string) error {
+	if err := util.CheckKey(k); err != nil {
+		return err
+	}
+	l := s.state.lock(k)
+	l.Lock()
+	defer l.Unlock()
+
+	if err := s.Back.Delete(k); err != nil {
+		return err
+	}
+	s.state.written(k, time.Time{}, true)
+	return s.Front.Delete(k)
+}
+
+// Scan returns an iterator over the keys of the back store, or
+// kv.ErrNotSupported if it doesn't implement kv.Scanner.
+// In WriteBack mode, the queued changes are written to it first.
+func (s Store) Scan(prefix, start, end string) (kv.Iterator, error) {
+	sc, ok := s.Back.(kv.Scanner)
+	if !ok {
+		return nil, kv.ErrNotSupported
+	}
+	return sc.Scan(prefix, start, end)
+}
+
+// Flush writes the queued changes to the back store in WriteBack mode,
+// see writebehind.Store.Flush. It does nothing in the other modes.
+func (s Store) Flush() error {
+	if wb, ok := s.Back.(writebehind.Store); ok && s.mode == WriteBack {
+		return wb.Flush()
+	}
+	return nil
+}
+
+// Stats returns the counters of the store.
+func (s Store) Stats() Stats {
+	st := s.state
+	var queue writebehind.Stats
+	if wb, ok := s.Back.(writebehind.Store); ok && s.mode == WriteBack {
+		queue = wb.Stats()
+	}
+	return Stats{
+		FrontHits:    atomic.LoadInt64(&st.frontHits),
+		BackHits:     atomic.LoadInt64(&st.backHits),
+		Misses:       atomic.LoadInt64(&st.misses),
+		NegativeHits: atomic.LoadInt64(&st.negativeHits),
+		Promotions:   atomic.LoadInt64(&st.promotions),
+		Demotions:    queue.Written,
+		Dirty:        queue.Pending,
+	}
+}
+
+// Mode returns the mode of the store.
+func (s Store) Mode() Mode {
+	return s.mode
+}
+
+// Close writes the remaining changes to the back store
+// and closes both stores.
+func (s Store) Close() error {
+	var err error
+	s.state.closeOnce.Do(func() {
+		err = s.Back.Close()
+		if ferr := s.Front.Close(); err == nil {
+			err = ferr
+		}
+	})
+	return err
+}
+
+// Options are the options for the tiered store.
+type Options struct {
+	// How writes reach the back store.
+	Mode Mode
+	// How long misses are remembered. 0 disables remembering them.
+	NegativeTTL time.Duration
+	// Maximum number of remembered misses. When it's reached,
+	// all of them are forgotten.
+	MaxNegative int
+	// TTL of the values promoted into the front store, if it implements
+	// kv.TTLStore, which bounds how long values are cached. Values
+	// written with a TTL through the store are cached for the rest of it.
+	// 0 means promoted values don't expire.
+	FrontTTL time.Duration
+	// Interval of the background flushes in WriteBack mode.
+	FlushInterval time.Duration
+	// Number of changed keys that triggers a flush in WriteBack mode.
+	MaxDirty int
+	// Maximum number of queued changes in WriteBack mode,
+	// see writebehind.Options.
+	MaxPending int
+	// Encoding format that the queued values are copied with
+	// in WriteBack mode.
+	Codec encoding.Codec
+}
+
+// DefaultOptions is an Options object with default values.
+var DefaultOptions = Options{
+	Mode:          WriteThrough,
+	NegativeTTL:   time.Minute,
+	MaxNegative:   100000,
+	FlushInterval: time.Second,
+	MaxDirty:      10000,
+	MaxPending:    100000,
+	Codec:         encoding.JSON,
+}
+
+// NewStore creates a tiered store that caches the values of back in front.
+// The tiered store owns both stores and closes them when it's closed.
+func NewStore(front, back gokv.Store, options *Options) (Store, error) {
+	if options == nil {
+		options = &DefaultOptions
+	}
+	if front == nil || back == nil {
+		return Store{}, errors.New("tiered: NewStore needs a front and a back store")
+	}
+	if _, err := options.Mode.MarshalText(); err != nil {
+		return Store{}, err
+	}
+	if options.Mode == WriteBack {
+		wb, err := writebehind.NewStore(back, &writebehind.Options{
+			MaxPending:    options.MaxPending,
+			BatchSize:     options.MaxDirty,
+			FlushInterval: options.FlushInterval,
+			Codec:         options.Codec,
+		})
+		if err != nil {
+			return Store{}, err
+		}
+		back = wb
+	}
+	result := Store{
+		Front:    front,
+		Back:     back,
+		mode:     options.Mode,
+		frontTTL: options.FrontTTL,
+		state: &state{
+			negative:    make(map[string]time.Time),
+			negativeTTL: options.NegativeTTL,
+			maxNegative: options.MaxNegative,
+			deadlines:   make(map[string]time.Time),
+			sweepAt:     1024,
+		},
+	}
+
+	return result, nil
+}
+
+// init registers the store as "tiered" with package stores. Its config
+// has the configs of the "front" and "back" stores besides the options:
+//
+//	backend: tiered
+//	options:
+//	  mode: write-back
+//	  front: {backend: ristretto}
+//	  back: {backend: badgerdb, options: {dir: /var/lib/sensors}}
+func init() {
+	stores.Register("tiered", func(cfg map[string]interface{}) (gokv.Store, error) {
+		var frontCfg, backCfg stores.Config
+		rest := make(map[string]interface{}, len(cfg))
+		for name, value := range cfg {
+			var err error
+			switch name {
+			case "front":
+				frontCfg, err = stores.DecodeConfig(value)
+			case "back":
+				backCfg, err = stores.DecodeConfig(value)
+			default:
+				rest[name] = value
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+		}
+		if frontCfg.Backend == "" || backCfg.Backend == "" {
+			return nil, errors.New(`the config needs a "front" and a "back" store`)
+		}
+		options := DefaultOptions
+		if err := stores.DecodeOptions(rest, &options); err != nil {
+			return nil, err
+		}
+
+		front, err := frontCfg.Open()
+		if err != nil {
+			return nil, err
+		}
+		back, err := backCfg.Open()
+		if err != nil {
+			front.Close()
+			return nil, err
+		}
+		s, err := NewStore(front, back, &options)
+		if err != nil {
+			front.Close()
+			back.Close()
+			return nil, err
+		}
+		return s, nil
+	})
+}
@@ -0,0 +1,354 @@
+package tiered_test
+
+import (
+	"databases/memory"
+	"databases/pudge"
+	"databases/ristretto"
+	"databases/stores"
+	"databases/storetest"
+	"databases/tiered"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/philippgille/gokv"
+)
+
+func newMemoryStore(t *testing.T) memory.Store {
+	store, err := memory.NewStore(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func newStore(t *testing.T, front, back gokv.Store, options *tiered.Options) tiered.Store {
+	s, err := tiered.NewStore(front, back, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func withMode(mode tiered.Mode) *tiered.Options {
+	ops := tiered.DefaultOptions
+	ops.Mode = mode
+	return &ops
+}
+
+func TestStore(t *testing.T) {
+	for _, mode := range []tiered.Mode{tiered.WriteThrough, tiered.ReadThrough, tiered.WriteBack} {
+		t.Run(mode.String(), func(t *testing.T) {
+			storetest.TestStore(t, func(t *testing.T) gokv.Store {
+				return newStore(t, newMemoryStore(t), newMemoryStore(t), withMode(mode))
+			})
+		})
+	}
+}
+
+// TestRistrettoPudge tests the tiers the package is meant for.
+func TestRistrettoPudge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tiered")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	storetest.TestStoreWithOptions(t, func(t *testing.T) gokv.Store {
+		front, err := ristretto.NewStore(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpDir, err := ioutil.TempDir(dir, "store")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ops := pudge.DefaultOptions
+		ops.File = path.Join(tmpDir, "db")
+		back, err := pudge.NewStore(&ops)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return newStore(t, front, back, nil)
+	}, &storetest.Options{Eventual: true})
+}
+
+func get(t *testing.T, s gokv.Store, k string) (int, bool) {
+	t.Helper()
+	var v int
+	found, err := s.Get(k, &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v, found
+}
+
+func set(t *testing.T, s gokv.Store, k string, v int) {
+	t.Helper()
+	if err := s.Set(k, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestModes(t *testing.T) {
+	tests := []struct {
+		mode         tiered.Mode
+		front, back  bool
+		dirty        int
+		wantAfterGet tiered.Stats
+	}{
+		{tiered.WriteThrough, true, true, 0, tiered.Stats{FrontHits: 1}},
+		{tiered.ReadThrough, false, true, 0, tiered.Stats{BackHits: 1, Promotions: 1}},
+		{tiered.WriteBack, true, false, 1, tiered.Stats{FrontHits: 1, Dirty: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode.String(), func(t *testing.T) {
+			front, back := newMemoryStore(t), newMemoryStore(t)
+			s := newStore(t, front, back, withMode(tt.mode))
+			set(t, s, "sen1", 1)
+			if _, found := get(t, front, "sen1"); found != tt.front {
+				t.Errorf("front has the value: %v, want %v", found, tt.front)
+			}
+			if _, found := get(t, back, "sen1"); found != tt.back {
+				t.Errorf("back has the value: %v, want %v", found, tt.back)
+			}
+			if got := s.Stats().Dirty; got != tt.dirty {
+				t.Errorf("got %d dirty keys, want %d", got, tt.dirty)
+			}
+			if v, found := get(t, s, "sen1"); !found || v != 1 {
+				t.Errorf("got %d, %v", v, found)
+			}
+			if got := s.Stats(); got != tt.wantAfterGet {
+				t.Errorf("got %+v, want %+v", got, tt.wantAfterGet)
+			}
+			if _, found := get(t, front, "sen1"); !found {
+				t.Error("the value isn't cached after Get")
+			}
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestPromotion(t *testing.T) {
+	front, back := newMemoryStore(t), newMemoryStore(t)
+	s := newStore(t, front, back, nil)
+	defer s.Close()
+	set(t, back, "sen1", 1)
+	for i := 0; i < 3; i++ {
+		if v, found := get(t, s, "sen1"); !found || v != 1 {
+			t.Fatalf("got %d, %v", v, found)
+		}
+	}
+	want := tiered.Stats{FrontHits: 2, BackHits: 1, Promotions: 1}
+	if got := s.Stats(); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// Values written with a TTL are promoted with the rest of it.
+	if err := s.SetWithTTL("sen2", 2, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := front.Delete("sen2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := get(t, s, "sen2"); !found {
+		t.Fatal("the value with a TTL wasn't found")
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if _, found := get(t, front, "sen2"); found {
+		t.Error("the promoted value didn't expire")
+	}
+}
+
+// failingStore is a memory store whose Set fails while fail is set.
+type failingStore struct {
+	memory.Store
+	fail *bool
+}
+
+func (s failingStore) Set(k string, v interface{}) error {
+	if *s.fail {
+		return errors.New("front store is full")
+	}
+	return s.Store.Set(k, v)
+}
+
+// TestFrontError checks that the front store doesn't keep
+// serving the old value when caching the new one fails.
+func TestFrontError(t *testing.T) {
+	front := failingStore{Store: newMemoryStore(t), fail: new(bool)}
+	s := newStore(t, front, newMemoryStore(t), withMode(tiered.WriteThrough))
+	defer s.Close()
+
+	if err := s.Set("sen1", 1); err != nil {
+		t.Fatal(err)
+	}
+	*front.fail = true
+	if err := s.Set("sen1", 2); err == nil {
+		t.Fatal("Set succeeded although the front store failed")
+	}
+	*front.fail = false
+	var v int
+	if found, err := s.Get("sen1", &v); err != nil || !found || v != 2 {
+		t.Errorf("got %d, %v, %v, want the value in the back store", v, found, err)
+	}
+}
+
+func TestNegativeCaching(t *testing.T) {
+	front, back := newMemoryStore(t), newMemoryStore(t)
+	ops := tiered.DefaultOptions
+	ops.NegativeTTL = 200 * time.Millisecond
+	s := newStore(t, front, back, &ops)
+	defer s.Close()
+
+	for i := 0; i < 3; i++ {
+		if _, found := get(t, s, "sen1"); found {
+			t.Fatal("found a missing key")
+		}
+	}
+	want := tiered.Stats{Misses: 1, NegativeHits: 2}
+	if got := s.Stats(); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// Writes to the back store that bypass the tiered store aren't seen
+	// until the miss is forgotten.
+	set(t, back, "sen1", 1)
+	if _, found := get(t, s, "sen1"); found {
+		t.Error("the remembered miss was forgotten early")
+	}
+	time.Sleep(300 * time.Millisecond)
+	if _, found := get(t, s, "sen1"); !found {
+		t.Error("the remembered miss wasn't forgotten")
+	}
+
+	// Writes through the tiered store are seen at once.
+	if err := s.Delete("sen1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := get(t, s, "sen1"); found {
+		t.Error("found a deleted key")
+	}
+	set(t, s, "sen1", 2)
+	if err := front.Delete("sen1"); err != nil {
+		t.Fatal(err)
+	}
+	if v, found := get(t, s, "sen1"); !found || v != 2 {
+		t.Errorf("got %d, %v after the key was set again", v, found)
+	}
+}
+
+func TestWriteBack(t *testing.T) {
+	front, back := newMemoryStore(t), newMemoryStore(t)
+	ops := tiered.DefaultOptions
+	ops.Mode = tiered.WriteBack
+	ops.FlushInterval = time.Hour
+	ops.MaxDirty = 3
+	s := newStore(t, front, back, &ops)
+	defer s.Close()
+
+	// Changes are served even if the front store dropped them.
+	set(t, s, "sen1", 1)
+	set(t, s, "sen1", 2)
+	if err := front.Delete("sen1"); err != nil {
+		t.Fatal(err)
+	}
+	if v, found := get(t, s, "sen1"); !found || v != 2 {
+		t.Errorf("got %d, %v", v, found)
+	}
+	set(t, s, "sen2", 2)
+	if err := s.Delete("sen2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := get(t, s, "sen2"); found {
+		t.Error("found a deleted key")
+	}
+
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if v, found := get(t, back, "sen1"); !found || v != 2 {
+		t.Errorf("the back store has %d, %v", v, found)
+	}
+	if got := s.Stats(); got.Demotions != 2 || got.Dirty != 0 {
+		t.Errorf("got %+v, want 2 demotions and no dirty keys", got)
+	}
+
+	// MaxDirty changed keys trigger a flush.
+	for _, k := range []string{"sen3", "sen4", "sen5"} {
+		set(t, s, k, 3)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Stats().Dirty > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, found := get(t, back, "sen5"); !found {
+		t.Error("the changes weren't flushed after MaxDirty keys changed")
+	}
+}
+
+// TestClose checks that Close writes the changes to a persistent store.
+func TestClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tiered")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ops := pudge.DefaultOptions
+	ops.File = path.Join(dir, "db")
+	back, err := pudge.NewStore(&ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tops := tiered.DefaultOptions
+	tops.Mode = tiered.WriteBack
+	tops.FlushInterval = time.Hour
+	s := newStore(t, newMemoryStore(t), back, &tops)
+	set(t, s, "sen1", 1)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	back, err = pudge.NewStore(&ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer back.Close()
+	if v, found := get(t, back, "sen1"); !found || v != 1 {
+		t.Errorf("got %d, %v after Close", v, found)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	s, err := stores.Open("tiered", map[string]interface{}{
+		"mode":        "read-through",
+		"negativettl": "10s",
+		"front":       map[string]interface{}{"backend": "memory"},
+		"back":        stores.Config{Backend: "memory", Options: map[string]interface{}{"codec": "gob"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if mode := s.(tiered.Store).Mode(); mode != tiered.ReadThrough {
+		t.Errorf("got mode %v", mode)
+	}
+	set(t, s, "sen1", 1)
+	if v, found := get(t, s, "sen1"); !found || v != 1 {
+		t.Errorf("got %d, %v", v, found)
+	}
+
+	for _, cfg := range []map[string]interface{}{
+		{"front": map[string]interface{}{"backend": "memory"}},
+		{"front": map[string]interface{}{"backend": "memory"}, "back": map[string]interface{}{"backend": "unknown"}},
+		{"front": map[string]interface{}{"backend": "memory"}, "back": map[string]interface{}{"backend": "memory"}, "mode": "write-around"},
+	} {
+		if _, err := stores.Open("tiered", cfg); err == nil {
+			t.Errorf("Open with %v succeeded", cfg)
+		}
+	}
+}
@@ -0,0 +1,400 @@
+// Package watch adds kv.Watcher to any gokv.Store by publishing an event
+// for every change made through it.
+//
+// Only changes made through the watch.Store are seen, so every writer of
+// the underlying store must use it. Expiry is tracked by the watch.Store
+// itself, which publishes kv.EventExpire once the TTL of a value passed.
+//
+// The contexts, atomic operations and transactions of the underlying store
+// are forwarded, and their changes published, too. Transactions block the
+// other writes through the watch.Store while they run, so that their
+// changes are published in order with them.
+package watch
+
+import (
+	"context"
+	"databases/kv"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/philippgille/gokv"
+	"github.com/philippgille/gokv/encoding"
+)
+
+// Store is a gokv.Store that reports the changes made through it.
+type Store struct {
+	Store  gokv.Store
+	Codec  encoding.Codec
+	broker *kv.Broker
+	locks  *[numLocks]sync.Mutex
+	// txMu is held by transactions, and shared by the other writes.
+	txMu *sync.RWMutex
+}
+
+// numLocks is the number of locks that the keys are spread over.
+const numLocks = 256
+
+// lock locks the keys until the returned function is called, so that
+// the changes of a key are published in the order they were made.
+func (s Store) lock(keys ...string) (unlock func()) {
+	seen := make(map[int]bool, len(keys))
+	stripes := make([]int, 0, len(keys))
+	for _, k := range keys {
+		h := fnv.New32a()
+		h.Write([]byte(k))
+		i := int(h.Sum32() % numLocks)
+		if !seen[i] {
+			seen[i] = true
+			stripes = append(stripes, i)
+		}
+	}
+	// Locking in order avoids deadlocks between writes of many keys.
+	sort.Ints(stripes)
+	s.txMu.RLock()
+	for _, i := range stripes {
+		s.locks[i].Lock()
+	}
+	return func() {
+		for _, i := range stripes {
+			s.locks[i].Unlock()
+		}
+		s.txMu.RUnlock()
+	}
+}
+
+// Set stores the given value for the given key.
+func (s Store) Set(k string, v interface{}) error {
+	return s.set(k, v, func() error { return s.Store.Set(k, v) })
+}
+
+// SetContext stores the given value for the given key
+// with the context of the underlying store, if it has one.
+func (s Store) SetContext(ctx context.Context, k string, v interface{}) error {
+	return s.set(k, v, func() error { return kv.WithContext(s.Store).SetContext(ctx, k, v) })
+}
+
+// set publishes the set of k to v if write succeeds.
+func (s Store) set(k string, v interface{}, write func() error) error {
+	data, err := s.encode(k, v)
+	if err != nil {
+		return err
+	}
+	defer s.lock(k)()
+	if err := write(); err != nil {
+		return err
+	}
+	s.broker.PublishSet(k, data, time.Time{})
+	return nil
+}
+
+// SetWithTTL stores the given value for the given key.
+// The value expires after ttl. It returns kv.ErrNotSupported
+// if the underlying store doesn't implement kv.TTLStore.
+func (s Store) SetWithTTL(k string, v interface{}, ttl time.Duration) error {
+	ts, ok := s.Store.(kv.TTLStore)
+	if !ok {
+		return kv.ErrNotSupported
+	}
+	data, err := s.encode(k, v)
+	if err != nil {
+		return err
+	}
+	defer s.lock(k)()
+	if err := ts.SetWithTTL(k, v, ttl); err != nil {
+		return err
+	}
+	s.broker.PublishSet(k, data, kv.Deadline(ttl))
+	return nil
+}
+
+// encode encodes v for the event of a set of k, if anyone watches k.
+// It's called before the write, so that a value that can't be encoded
+// isn't written without an event.
+func (s Store) encode(k string, v interface{}) ([]byte, error) {
+	if !s.broker.Watched(k) {
+		return nil, nil
+	}
+	return s.Codec.Marshal(v)
+}
+
+// Get retrieves the stored value for the given key.
+func (s Store) Get(k string, v interface{}) (found bool, err error) {
+	return s.Store.Get(k, v)
+}
+
+// GetContext retrieves the stored value for the given key
+// with the context of the underlying store, if it has one.
+func (s Store) GetContext(ctx context.Context, k string, v interface{}) (found bool, err error) {
+	return kv.WithContext(s.Store).GetContext(ctx, k, v)
+}
+
+// Delete deletes the stored value for the given key.
+func (s Store) Delete(k string) error {
+	return s.delete(k, func() error { return s.Store.Delete(k) })
+}
+
+// DeleteContext deletes the stored value for the given key
+// with the context of the underlying store, if it has one.
+func (s Store) DeleteContext(ctx context.Context, k string) error {
+	return s.delete(k, func() error { return kv.WithContext(s.Store).DeleteContext(ctx, k) })
+}
+
+// delete publishes the deletion of k if write succeeds.
+func (s Store) delete(k string, write func() error) error {
+	defer s.lock(k)()
+	if err := write(); err != nil {
+		return err
+	}
+	s.broker.PublishDelete(k)
+	return nil
+}
+
+// SetMany stores the given values for their keys,
+// in a batch if the underlying store supports it.
+func (s Store) SetMany(values map[string]interface{}) error {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	data := make(map[string][]byte, len(values))
+	for k, v := range values {
+		var err error
+		if data[k], err = s.encode(k, v); err != nil {
+			return err
+		}
+	}
+	defer s.lock(keys...)()
+	if err := kv.WithBatch(s.Store).SetMany(values); err != nil {
+		return err
+	}
+	for k := range values {
+		s.broker.PublishSet(k, data[k], time.Time{})
+	}
+	return nil
+}
+
+// GetMany retrieves the stored values for the given keys.
+func (s Store) GetMany(keys []string, vs []interface{}) (found []bool, err error) {
+	return kv.WithBatch(s.Store).GetMany(keys, vs)
+}
+
+// DeleteMany deletes the stored values for the given keys,
+// in a batch if the underlying store supports it.
+func (s Store) DeleteMany(keys []string) error {
+	defer s.lock(keys...)()
+	if err := kv.WithBatch(s.Store).DeleteMany(keys); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		s.broker.PublishDelete(k)
+	}
+	return nil
+}
+
+// CompareAndSwap stores new for k if the stored value equals old and
+// reports whether it did. It returns kv.ErrNotSupported if the
+// underlying store doesn't implement kv.AtomicStore.
+func (s Store) CompareAndSwap(k string, old, new interface{}) (swapped bool, err error) {
+	as, ok := s.Store.(kv.AtomicStore)
+	if !ok {
+		return false, kv.ErrNotSupported
+	}
+	data, err := s.encode(k, new)
+	if err != nil {
+		return false, err
+	}
+	defer s.lock(k)()
+	if swapped, err = as.CompareAndSwap(k, old, new); err != nil || !swapped {
+		return swapped, err
+	}
+	s.broker.PublishSet(k, data, time.Time{})
+	return true, nil
+}
+
+// SetIfAbsent stores v for k if no value is stored for it and reports
+// whether it did. It returns kv.ErrNotSupported if the underlying store
+// doesn't implement kv.AtomicStore.
+func (s Store) SetIfAbsent(k string, v interface{}) (set bool, err error) {
+	as, ok := s.Store.(kv.AtomicStore)
+	if !ok {
+		return false, kv.ErrNotSupported
+	}
+	data, err := s.encode(k, v)
+	if err != nil {
+		return false, err
+	}
+	defer s.lock(k)()
+	if set, err = as.SetIfAbsent(k, v); err != nil || !set {
+		return set, err
+	}
+	s.broker.PublishSet(k, data, time.Time{})
+	return true, nil
+}
+
+// Increment adds delta to the int64 stored for k, or to 0 if none is,
+// and returns the result. It returns kv.ErrNotSupported if the
+// underlying store doesn't implement kv.AtomicStore.
+func (s Store) Increment(k string, delta int64) (int64, error) {
+	as, ok := s.Store.(kv.AtomicStore)
+	if !ok {
+		return 0, kv.ErrNotSupported
+	}
+	defer s.lock(k)()
+	n, err := as.Increment(k, delta)
+	if err != nil {
+		return 0, err
+	}
+	// The result is only known after the write. An int64 encodes
+	// with any codec, so the event isn't lost to an error here.
+	data, err := s.encode(k, n)
+	if err != nil {
+		return 0, err
+	}
+	s.broker.PublishSet(k, data, time.Time{})
+	return n, nil
+}
+
+// Update runs fn in a transaction of the underlying store and publishes
+// its changes once it's committed. Other writes through the store wait
+// until then, so fn must not write through the store itself.
+// It returns kv.ErrNotSupported if the underlying store doesn't
+// implement kv.Transactional.
+func (s Store) Update(fn func(tx kv.Txn) error) error {
+	ts, ok := s.Store.(kv.Transactional)
+	if !ok {
+		return kv.ErrNotSupported
+	}
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+	var t *tx
+	err := ts.Update(func(inner kv.Txn) error {
+		// The store may run fn again, so only the last run counts.
+		t = &tx{Txn: inner, s: s, changes: make(map[string]change)}
+		return fn(t)
+	})
+	if err != nil {
+		return err
+	}
+	for k, c := range t.changes {
+		if c.deleted {
+			s.broker.PublishDelete(k)
+		} else {
+			s.broker.PublishSet(k, c.data, c.deadline)
+		}
+	}
+	return nil
+}
+
+// View runs fn in a read-only transaction of the underlying store.
+// It returns kv.ErrNotSupported if the underlying store doesn't
+// implement kv.Transactional.
+func (s Store) View(fn func(tx kv.Txn) error) error {
+	ts, ok := s.Store.(kv.Transactional)
+	if !ok {
+		return kv.ErrNotSupported
+	}
+	return ts.View(fn)
+}
+
+// tx is a kv.TTLTxn that records the changes of a transaction.
+type tx struct {
+	kv.Txn
+	s       Store
+	changes map[string]change
+}
+
+// change is a change made in a transaction.
+type change struct {
+	data     []byte
+	deadline time.Time
+	deleted  bool
+}
+
+func (t *tx) Set(k string, v interface{}) error {
+	data, err := t.s.encode(k, v)
+	if err != nil {
+		return err
+	}
+	if err := t.Txn.Set(k, v); err != nil {
+		return err
+	}
+	t.changes[k] = change{data: data}
+	return nil
+}
+
+// SetWithTTL returns kv.ErrNotSupported if the
+// transaction of the underlying store isn't a kv.TTLTxn.
+func (t *tx) SetWithTTL(k string, v interface{}, ttl time.Duration) error {
+	tt, ok := t.Txn.(kv.TTLTxn)
+	if !ok {
+		return kv.ErrNotSupported
+	}
+	data, err := t.s.encode(k, v)
+	if err != nil {
+		return err
+	}
+	if err := tt.SetWithTTL(k, v, ttl); err != nil {
+		return err
+	}
+	t.changes[k] = change{data: data, deadline: kv.Deadline(ttl)}
+	return nil
+}
+
+func (t *tx) Delete(k string) error {
+	if err := t.Txn.Delete(k); err != nil {
+		return err
+	}
+	t.changes[k] = change{deleted: true}
+	return nil
+}
+
+// Scan returns an iterator over the keys of the underlying store, or
+// kv.ErrNotSupported if it doesn't implement kv.Scanner.
+func (s Store) Scan(prefix, start, end string) (kv.Iterator, error) {
+	sc, ok := s.Store.(kv.Scanner)
+	if !ok {
+		return nil, kv.ErrNotSupported
+	}
+	return sc.Scan(prefix, start, end)
+}
+
+// Watch returns a channel that receives an event for every change made
+// through the store to a key that starts with prefix, and a function
+// that stops watching and closes the channel.
+func (s Store) Watch(prefix string) (<-chan kv.Event, func()) {
+	return s.broker.Watch(prefix)
+}
+
+// Close stops all watchers and closes the underlying store.
+func (s Store) Close() error {
+	s.broker.Close()
+	return s.Store.Close()
+}
+
+// Options are the options for the watch store.
+type Options struct {
+	// Encoding format of the event values.
+	// It should be the Codec of the underlying store.
+	Codec encoding.Codec
+}
+
+// DefaultOptions is an Options object with default values.
+var DefaultOptions = Options{
+	Codec: encoding.JSON,
+}
+
+// NewStore creates a watch store that publishes the changes made to store.
+func NewStore(store gokv.Store, options *Options) Store {
+	if options == nil {
+		options = &DefaultOptions
+	}
+	return Store{
+		Store:  store,
+		Codec:  options.Codec,
+		broker: kv.NewBroker(),
+		locks:  new([numLocks]sync.Mutex),
+		txMu:   &sync.RWMutex{},
+	}
+}
@@ -0,0 +1,230 @@
+package watch_test
+
+import (
+	"databases/kv"
+	"databases/memory"
+	"databases/storetest"
+	"databases/watch"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/philippgille/gokv"
+)
+
+func newStore(t *testing.T) watch.Store {
+	store, err := memory.NewStore(&memory.DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return watch.NewStore(store, nil)
+}
+
+func TestStore(t *testing.T) {
+	storetest.TestStore(t, func(t *testing.T) gokv.Store {
+		return newStore(t)
+	})
+}
+
+func TestWatchPrefixes(t *testing.T) {
+	store := newStore(t)
+	defer store.Close()
+
+	all, stopAll := store.Watch("")
+	defer stopAll()
+	sen, stopSen := store.Watch("sen")
+	defer stopSen()
+
+	if err := store.SetMany(map[string]interface{}{"sen1": 1, "other": 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteMany([]string{"sen1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := receive(t, all, 3)
+	if len(got) != 3 || got[2] != "delete sen1" {
+		t.Errorf("watch of all keys got %v", got)
+	}
+	if got := receive(t, sen, 2); len(got) != 2 || got[0] != "set sen1" || got[1] != "delete sen1" {
+		t.Errorf("watch of prefix sen got %v", got)
+	}
+}
+
+func TestExpireOverwritten(t *testing.T) {
+	store := newStore(t)
+	defer store.Close()
+
+	events, stop := store.Watch("")
+	defer stop()
+	if err := store.SetWithTTL("sen1", 1, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	// Setting the key again cancels the pending expiry.
+	if err := store.Set("sen1", 2); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, events, 2); len(got) != 2 {
+		t.Fatalf("got %v", got)
+	}
+	select {
+	case e := <-events:
+		t.Errorf("unexpected event %v %q", e.Type, e.Key)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+// receive returns "<type> <key>" of the next n events.
+func receive(t *testing.T, events <-chan kv.Event, n int) []string {
+	t.Helper()
+	var got []string
+	for len(got) < n {
+		select {
+		case e := <-events:
+			got = append(got, e.Type.String()+" "+e.Key)
+		case <-time.After(time.Second):
+			t.Fatalf("got %v, want %d events", got, n)
+		}
+	}
+	return got
+}
+
+// stallingStore is a memory store whose Set of 0 stalls after writing,
+// so that another write of the key can overtake its publishing.
+type stallingStore struct {
+	memory.Store
+	written chan struct{}
+}
+
+func (s stallingStore) Set(k string, v interface{}) error {
+	if err := s.Store.Set(k, v); err != nil {
+		return err
+	}
+	if v == 0 {
+		close(s.written)
+		time.Sleep(50 * time.Millisecond)
+	}
+	return nil
+}
+
+// TestConcurrentOrder checks that the events of concurrent writes of
+// a key are published in the order of the writes.
+func TestConcurrentOrder(t *testing.T) {
+	inner, err := memory.NewStore(&memory.DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := watch.NewStore(stallingStore{Store: inner, written: make(chan struct{})}, nil)
+	defer store.Close()
+	events, stop := store.Watch("")
+	defer stop()
+
+	errc := make(chan error)
+	go func() { errc <- store.Set("sen1", 0) }()
+	<-store.Store.(stallingStore).written
+	go func() { errc <- store.Set("sen1", 1) }()
+	for i := 0; i < 2; i++ {
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var final int
+	if _, err := store.Get("sen1", &final); err != nil {
+		t.Fatal(err)
+	}
+	var last string
+	for i := 0; i < 2; i++ {
+		last = string((<-events).Value)
+	}
+	if want := strconv.Itoa(final); last != want {
+		t.Errorf("the last event has %s, the store %s", last, want)
+	}
+}
+
+// failingCodec can't encode any value.
+type failingCodec struct{}
+
+func (failingCodec) Marshal(v interface{}) ([]byte, error) {
+	return nil, errors.New("can't encode")
+}
+
+func (failingCodec) Unmarshal(data []byte, v interface{}) error {
+	return errors.New("can't decode")
+}
+
+// TestEncodeError checks that a value whose event can't be encoded isn't written.
+func TestEncodeError(t *testing.T) {
+	inner, err := memory.NewStore(&memory.DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := watch.NewStore(inner, &watch.Options{Codec: failingCodec{}})
+	defer store.Close()
+	_, stop := store.Watch("")
+	defer stop()
+
+	if err := store.Set("sen1", 1); err == nil {
+		t.Error("Set succeeded")
+	}
+	if err := store.SetMany(map[string]interface{}{"sen2": 1}); err == nil {
+		t.Error("SetMany succeeded")
+	}
+	for _, k := range []string{"sen1", "sen2"} {
+		if found, err := inner.Get(k, new(int)); err != nil || found {
+			t.Errorf("%s was written: %v, %v", k, found, err)
+		}
+	}
+}
+
+// TestForwarded checks that the atomic operations and transactions of
+// the underlying store are forwarded and publish their changes.
+func TestForwarded(t *testing.T) {
+	store := newStore(t)
+	defer store.Close()
+	events, stop := store.Watch("")
+	defer stop()
+
+	if set, err := store.SetIfAbsent("sen1", 1); err != nil || !set {
+		t.Fatalf("SetIfAbsent got %v, %v", set, err)
+	}
+	if swapped, err := store.CompareAndSwap("sen1", 1, 2); err != nil || !swapped {
+		t.Fatalf("CompareAndSwap got %v, %v", swapped, err)
+	}
+	if _, err := store.Increment("n", 3); err != nil {
+		t.Fatal(err)
+	}
+	err := store.Update(func(tx kv.Txn) error {
+		if err := tx.Set("sen2", 1); err != nil {
+			return err
+		}
+		return tx.Delete("sen1")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := receive(t, events, 5)
+	sort.Strings(got[3:])
+	want := "set sen1,set sen1,set n,delete sen1,set sen2"
+	if strings.Join(got, ",") != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	var st gokv.Store = plainStore{newStore(t)}
+	ws := watch.NewStore(st, nil)
+	defer ws.Close()
+	if _, err := ws.Increment("n", 1); err != kv.ErrNotSupported {
+		t.Errorf("Increment of a store without it got %v", err)
+	}
+	if err := ws.Update(func(kv.Txn) error { return nil }); err != kv.ErrNotSupported {
+		t.Errorf("Update of a store without it got %v", err)
+	}
+}
+
+// plainStore hides the optional interfaces of the store it wraps.
+type plainStore struct {
+	gokv.Store
+}
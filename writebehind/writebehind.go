@@ -0,0 +1,520 @@
+// Package writebehind buffers the writes to a slow store, like nutsdb or
+// badgerdb, whose every Set commits on its own.
+//
+// Set and Delete only queue the change in memory, where later changes of
+// a key replace the earlier ones, and the changes are written to the
+// underlying store in batches once BatchSize keys are queued or the
+// oldest change waited FlushInterval. Reads see the queued changes.
+//
+// Queued changes are lost if the process dies. When Flush returns nil,
+// every change made before it was called is written; Close flushes, too.
+// When MaxPending keys are queued, writes of other keys wait for a flush.
+package writebehind
+
+import (
+	"context"
+	"databases/kv"
+	"databases/stores"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/philippgille/gokv"
+	"github.com/philippgille/gokv/encoding"
+	"github.com/philippgille/gokv/util"
+)
+
+// ErrClosed is returned by writes to a closed store.
+var ErrClosed = errors.New("writebehind: store is closed")
+
+// Stats are the counters of a write-behind store.
+type Stats struct {
+	// Changes that aren't written yet.
+	Pending int
+	// Changes written to the underlying store.
+	Written int64
+	// Changes that replaced a queued change of the same key.
+	Coalesced int64
+	// Flushes of queued changes, including failed ones.
+	Flushes int64
+	// Writes that waited because the queue was full.
+	Waits int64
+}
+
+// Store is a gokv.Store that queues the writes to another store
+// and writes them in batches.
+type Store struct {
+	Store gokv.Store
+	// Codec copies the queued values, so that they don't change
+	// with the value they were set from.
+	Codec encoding.Codec
+	state *state
+}
+
+// state is the state that the copies of a Store share.
+type state struct {
+	// The counters of Stats, updated atomically.
+	written, coalesced, flushes, waits int64
+
+	mu sync.Mutex
+	// Queued changes, and the ones that are being written by the running flush.
+	pending  map[string]change
+	flushing map[string]change
+	// room is closed when the queue is emptied.
+	room chan struct{}
+	// err is the error of the last flush, if it failed.
+	err    error
+	closed bool
+
+	maxPending int
+	batchSize  int
+
+	flushMu sync.Mutex
+	kick    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// change is a queued change of a key.
+type change struct {
+	// data is the encoded value, and typ the type it was encoded from,
+	// or nil if the key was deleted.
+	data     []byte
+	typ      reflect.Type
+	deadline time.Time
+}
+
+// lookup returns the change of k that isn't written yet.
+func (s *state) lookup(k string) (change, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.pending[k]; ok {
+		return c, true
+	}
+	c, ok := s.flushing[k]
+	return c, ok
+}
+
+// Set queues the given value for the given key.
+func (s Store) Set(k string, v interface{}) error {
+	return s.SetContext(context.Background(), k, v)
+}
+
+// SetContext queues the given value for the given key
+// unless the context is done before there's room in the queue.
+func (s Store) SetContext(ctx context.Context, k string, v interface{}) error {
+	return s.set(ctx, k, v, 0)
+}
+
+// SetWithTTL queues the given value for the given key.
+// The value expires after ttl, counted from now. It returns
+// kv.ErrNotSupported if the underlying store doesn't implement kv.TTLStore.
+func (s Store) SetWithTTL(k string, v interface{}, ttl time.Duration) error {
+	if _, ok := s.Store.(kv.TTLStore); !ok {
+		return kv.ErrNotSupported
+	}
+	return s.set(context.Background(), k, v, ttl)
+}
+
+func (s Store) set(ctx context.Context, k string, v interface{}, ttl time.Duration) error {
+	if err := util.CheckKeyAndValue(k, v); err != nil {
+		return err
+	}
+	data, err := s.Codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.enqueue(ctx, k, change{data: data, typ: reflect.TypeOf(v), deadline: kv.Deadline(ttl)})
+}
+
+// enqueue queues the change of k. If the queue is full and k isn't in it,
+// it waits until a flush empties the queue. If the last flush failed, it
+// flushes itself instead of waiting for the next interval, and returns the
+// error if that flush fails, too.
+func (s Store) enqueue(ctx context.Context, k string, c change) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	st := s.state
+	st.mu.Lock()
+	for {
+		if st.closed {
+			st.mu.Unlock()
+			return ErrClosed
+		}
+		_, queued := st.pending[k]
+		if queued || len(st.pending) < st.maxPending {
+			break
+		}
+		if st.err != nil {
+			st.mu.Unlock()
+			if err := s.Flush(); err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			st.mu.Lock()
+			continue
+		}
+		room := st.room
+		st.mu.Unlock()
+		atomic.AddInt64(&st.waits, 1)
+		s.kickFlush()
+		select {
+		case <-room:
+		case <-st.stop:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		st.mu.Lock()
+	}
+	if _, queued := st.pending[k]; queued {
+		atomic.AddInt64(&st.coalesced, 1)
+	}
+	st.pending[k] = c
+	full := len(st.pending) >= st.batchSize
+	st.mu.Unlock()
+	if full {
+		s.kickFlush()
+	}
+	return nil
+}
+
+// Get retrieves the value for the given key,
+// from the queue if it has a change of the key.
+func (s Store) Get(k string, v interface{}) (found bool, err error) {
+	return s.GetContext(context.Background(), k, v)
+}
+
+// GetContext retrieves the value for the given key, from the queue if it
+// has a change of the key, and else from the underlying store with the
+// context if it implements kv.ContextStore.
+func (s Store) GetContext(ctx context.Context, k string, v interface{}) (found bool, err error) {
+	if err := util.CheckKeyAndValue(k, v); err != nil {
+		return false, err
+	}
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	if c, ok := s.state.lookup(k); ok {
+		if c.typ == nil || kv.Expired(c.deadline, time.Now()) {
+			return false, nil
+		}
+		return true, s.Codec.Unmarshal(c.data, v)
+	}
+	if cs, ok := s.Store.(kv.ContextStore); ok {
+		return cs.GetContext(ctx, k, v)
+	}
+	return s.Store.Get(k, v)
+}
+
+// Delete queues the deletion of the value for the given key.
+func (s Store) Delete(k string) error {
+	return s.DeleteContext(context.Background(), k)
+}
+
+// DeleteContext queues the deletion of the value for the given key
+// unless the context is done before there's room in the queue.
+func (s Store) DeleteContext(ctx context.Context, k string) error {
+	if err := util.CheckKey(k); err != nil {
+		return err
+	}
+	return s.enqueue(ctx, k, change{})
+}
+
+// Scan flushes the queue and returns an iterator over the keys of the
+// underlying store, or kv.ErrNotSupported if it doesn't implement kv.Scanner.
+func (s Store) Scan(prefix, start, end string) (kv.Iterator, error) {
+	sc, ok := s.Store.(kv.Scanner)
+	if !ok {
+		return nil, kv.ErrNotSupported
+	}
+	if err := s.Flush(); err != nil {
+		return nil, err
+	}
+	return sc.Scan(prefix, start, end)
+}
+
+// kickFlush starts a background flush unless one is about to start.
+func (s Store) kickFlush() {
+	select {
+	case s.state.kick <- struct{}{}:
+	default:
+	}
+}
+
+// Flush writes the queued changes to the underlying store. Changes that
+// fail to be written stay queued, unless they were replaced meanwhile,
+// and are written by the next flush.
+func (s Store) Flush() error {
+	st := s.state
+	st.flushMu.Lock()
+	defer st.flushMu.Unlock()
+	st.mu.Lock()
+	batch := st.pending
+	if len(batch) == 0 {
+		st.err = nil
+		st.mu.Unlock()
+		return nil
+	}
+	st.pending = make(map[string]change, len(batch))
+	st.flushing = batch
+	close(st.room)
+	st.room = make(chan struct{})
+	st.mu.Unlock()
+
+	failed, err := s.write(batch)
+
+	st.mu.Lock()
+	for k, c := range failed {
+		if _, newer := st.pending[k]; !newer {
+			st.pending[k] = c
+		}
+	}
+	st.flushing = nil
+	st.err = err
+	st.mu.Unlock()
+	atomic.AddInt64(&st.flushes, 1)
+	atomic.AddInt64(&st.written, int64(len(batch)-len(failed)))
+	return err
+}
+
+// write writes the changes to the underlying store, in batches of at most
+// batchSize sets and deletes, and returns the changes it failed to write
+// with the first error.
+func (s Store) write(batch map[string]change) (failed map[string]change, err error) {
+	bs := kv.WithBatch(s.Store)
+	failed = make(map[string]change)
+	fail := func(group map[string]change, e error) {
+		for k, c := range group {
+			failed[k] = c
+		}
+		if err == nil {
+			err = e
+		}
+	}
+
+	sets := make(map[string]change, s.state.batchSize)
+	deletes := make(map[string]change, s.state.batchSize)
+	writeSets := func() {
+		values := make(map[string]interface{}, len(sets))
+		for k, c := range sets {
+			v := reflect.New(c.typ)
+			if e := s.Codec.Unmarshal(c.data, v.Interface()); e != nil {
+				fail(sets, e)
+				sets = make(map[string]change, s.state.batchSize)
+				return
+			}
+			values[k] = v.Elem().Interface()
+		}
+		if e := bs.SetMany(values); e != nil {
+			fail(sets, e)
+		}
+		sets = make(map[string]change, s.state.batchSize)
+	}
+	writeDeletes := func() {
+		keys := make([]string, 0, len(deletes))
+		for k := range deletes {
+			keys = append(keys, k)
+		}
+		if e := bs.DeleteMany(keys); e != nil {
+			fail(deletes, e)
+		}
+		deletes = make(map[string]change, s.state.batchSize)
+	}
+
+	now := time.Now()
+	for k, c := range batch {
+		switch {
+		case c.typ == nil || kv.Expired(c.deadline, now):
+			deletes[k] = c
+			if len(deletes) == s.state.batchSize {
+				writeDeletes()
+			}
+		case c.deadline.IsZero():
+			sets[k] = c
+			if len(sets) == s.state.batchSize {
+				writeSets()
+			}
+		default:
+			// Values with a TTL are set one by one with the rest of it.
+			v := reflect.New(c.typ)
+			e := s.Codec.Unmarshal(c.data, v.Interface())
+			if e == nil {
+				e = s.Store.(kv.TTLStore).SetWithTTL(k, v.Elem().Interface(), time.Until(c.deadline))
+			}
+			if e != nil {
+				fail(map[string]change{k: c}, e)
+			}
+		}
+	}
+	if len(sets) > 0 {
+		writeSets()
+	}
+	if len(deletes) > 0 {
+		writeDeletes()
+	}
+	return failed, err
+}
+
+// flushLoop flushes the queue every interval and when it's kicked.
+func (s Store) flushLoop(interval time.Duration) {
+	defer close(s.state.done)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.state.stop:
+			return
+		case <-t.C:
+		case <-s.state.kick:
+		}
+		// Failed changes stay queued for the next flush,
+		// and writes that wait for room get the error.
+		s.Flush()
+	}
+}
+
+// Stats returns the counters of the store.
+func (s Store) Stats() Stats {
+	st := s.state
+	st.mu.Lock()
+	pending := len(st.pending)
+	for k := range st.flushing {
+		// Keys changed again during the flush are counted once.
+		if _, ok := st.pending[k]; !ok {
+			pending++
+		}
+	}
+	st.mu.Unlock()
+	return Stats{
+		Pending:   pending,
+		Written:   atomic.LoadInt64(&st.written),
+		Coalesced: atomic.LoadInt64(&st.coalesced),
+		Flushes:   atomic.LoadInt64(&st.flushes),
+		Waits:     atomic.LoadInt64(&st.waits),
+	}
+}
+
+// Close flushes the queue and closes the underlying store.
+// Writes fail with ErrClosed afterwards. The underlying store is
+// closed even if the flush fails, and its error is returned.
+func (s Store) Close() error {
+	st := s.state
+	st.mu.Lock()
+	if st.closed {
+		st.mu.Unlock()
+		return nil
+	}
+	st.closed = true
+	st.mu.Unlock()
+	close(st.stop)
+	<-st.done
+
+	err := s.Flush()
+	if cerr := s.Store.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Options are the options for the write-behind store.
+type Options struct {
+	// Maximum number of queued keys. Writes of keys that aren't
+	// queued wait while it's reached.
+	MaxPending int
+	// Number of queued keys that triggers a flush,
+	// and the maximum number of changes written in one batch.
+	BatchSize int
+	// Interval of the flushes, which bounds how long changes are queued.
+	FlushInterval time.Duration
+	// Encoding format that the queued values are copied with.
+	Codec encoding.Codec
+}
+
+// DefaultOptions is an Options object with default values.
+var DefaultOptions = Options{
+	MaxPending:    100000,
+	BatchSize:     1000,
+	FlushInterval: 100 * time.Millisecond,
+	Codec:         encoding.JSON,
+}
+
+// NewStore creates a store that queues the writes to store.
+// The write-behind store owns store and closes it when it's closed.
+func NewStore(store gokv.Store, options *Options) (Store, error) {
+	if options == nil {
+		options = &DefaultOptions
+	}
+	if store == nil {
+		return Store{}, errors.New("writebehind: NewStore needs a store")
+	}
+	if options.BatchSize <= 0 || options.MaxPending < options.BatchSize {
+		return Store{}, fmt.Errorf("writebehind: invalid BatchSize %d or MaxPending %d", options.BatchSize, options.MaxPending)
+	}
+	if options.FlushInterval <= 0 {
+		return Store{}, fmt.Errorf("writebehind: invalid FlushInterval %v", options.FlushInterval)
+	}
+	result := Store{
+		Store: store,
+		Codec: options.Codec,
+		state: &state{
+			pending:    make(map[string]change),
+			room:       make(chan struct{}),
+			maxPending: options.MaxPending,
+			batchSize:  options.BatchSize,
+			kick:       make(chan struct{}, 1),
+			stop:       make(chan struct{}),
+			done:       make(chan struct{}),
+		},
+	}
+	go result.flushLoop(options.FlushInterval)
+
+	return result, nil
+}
+
+// init registers the store as "writebehind" with package stores.
+// Its config has the config of the underlying "store" besides the options:
+//
+//	backend: writebehind
+//	options:
+//	  batchsize: 500
+//	  store: {backend: nutsdb, options: {dir: /var/lib/sensors}}
+func init() {
+	stores.Register("writebehind", func(cfg map[string]interface{}) (gokv.Store, error) {
+		var storeCfg stores.Config
+		rest := make(map[string]interface{}, len(cfg))
+		for name, value := range cfg {
+			if name != "store" {
+				rest[name] = value
+				continue
+			}
+			var err error
+			if storeCfg, err = stores.DecodeConfig(value); err != nil {
+				return nil, fmt.Errorf("store: %v", err)
+			}
+		}
+		if storeCfg.Backend == "" {
+			return nil, errors.New(`the config needs a "store"`)
+		}
+		options := DefaultOptions
+		if err := stores.DecodeOptions(rest, &options); err != nil {
+			return nil, err
+		}
+
+		store, err := storeCfg.Open()
+		if err != nil {
+			return nil, err
+		}
+		s, err := NewStore(store, &options)
+		if err != nil {
+			store.Close()
+			return nil, err
+		}
+		return s, nil
+	})
+}
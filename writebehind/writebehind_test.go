@@ -0,0 +1,369 @@
+package writebehind_test
+
+import (
+	"context"
+	"databases/memory"
+	"databases/pudge"
+	"databases/stores"
+	"databases/storetest"
+	"databases/writebehind"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/philippgille/gokv"
+)
+
+func newMemoryStore(t *testing.T) memory.Store {
+	store, err := memory.NewStore(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func newStore(t *testing.T, store gokv.Store, options *writebehind.Options) writebehind.Store {
+	s, err := writebehind.NewStore(store, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// manual returns options that only flush on Flush and Close, and when
+// batchSize keys are queued.
+func manual(maxPending, batchSize int) *writebehind.Options {
+	ops := writebehind.DefaultOptions
+	ops.MaxPending = maxPending
+	ops.BatchSize = batchSize
+	ops.FlushInterval = time.Hour
+	return &ops
+}
+
+// slowStore is a memory store that records the sizes of the batches
+// it's written, and whose SetMany can be held and made to fail.
+type slowStore struct {
+	memory.Store
+	mu      sync.Mutex
+	batches []int
+	err     error
+	// hold makes SetMany wait until it's closed.
+	hold chan struct{}
+}
+
+func (s *slowStore) SetMany(values map[string]interface{}) error {
+	s.mu.Lock()
+	hold, err := s.hold, s.err
+	s.batches = append(s.batches, len(values))
+	s.mu.Unlock()
+	if hold != nil {
+		<-hold
+	}
+	if err != nil {
+		return err
+	}
+	return s.Store.SetMany(values)
+}
+
+func (s *slowStore) set(hold chan struct{}, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hold, s.err = hold, err
+}
+
+func (s *slowStore) numBatches() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.batches)
+}
+
+// waitFor waits up to 5 seconds for cond to become true.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func get(t *testing.T, s gokv.Store, k string) (int, bool) {
+	t.Helper()
+	var v int
+	found, err := s.Get(k, &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v, found
+}
+
+func set(t *testing.T, s gokv.Store, k string, v int) {
+	t.Helper()
+	if err := s.Set(k, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStore(t *testing.T) {
+	storetest.TestStore(t, func(t *testing.T) gokv.Store {
+		return newStore(t, newMemoryStore(t), nil)
+	})
+}
+
+func TestCoalesce(t *testing.T) {
+	store := &slowStore{Store: newMemoryStore(t)}
+	s := newStore(t, store, manual(10, 10))
+	defer s.Close()
+	for i := 1; i <= 100; i++ {
+		set(t, s, "sen1", i)
+	}
+	set(t, s, "sen2", 1)
+	if err := s.Delete("sen2"); err != nil {
+		t.Fatal(err)
+	}
+	// Reads see the queued changes.
+	if v, found := get(t, s, "sen1"); !found || v != 100 {
+		t.Errorf("got %d, %v", v, found)
+	}
+	if _, found := get(t, s, "sen2"); found {
+		t.Error("found a deleted key")
+	}
+	if _, found := get(t, store, "sen1"); found {
+		t.Error("the change was written before the flush")
+	}
+
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if v, found := get(t, store, "sen1"); !found || v != 100 {
+		t.Errorf("the store has %d, %v", v, found)
+	}
+	want := writebehind.Stats{Written: 2, Coalesced: 100, Flushes: 1}
+	if got := s.Stats(); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestBatches(t *testing.T) {
+	store := &slowStore{Store: newMemoryStore(t)}
+	s := newStore(t, store, manual(100, 4))
+	defer s.Close()
+	// A change of a queued value doesn't change it.
+	v := []int{1}
+	if err := s.Set("sen0", v); err != nil {
+		t.Fatal(err)
+	}
+	v[0] = 2
+	for _, k := range []string{"sen1", "sen2", "sen3"} {
+		set(t, s, k, 1)
+	}
+
+	// BatchSize queued keys trigger a flush.
+	waitFor(t, "the flush", func() bool { return s.Stats().Written == 4 })
+	var got []int
+	if _, err := store.Get("sen0", &got); err != nil || len(got) != 1 || got[0] != 1 {
+		t.Errorf("got %v, %v", got, err)
+	}
+
+	// Larger queues are written in batches of BatchSize.
+	s.Close()
+	store = &slowStore{Store: newMemoryStore(t)}
+	s = newStore(t, store, manual(100, 4))
+	defer s.Close()
+	for i := 0; i < 10; i++ {
+		if err := s.Set(string(rune('a'+i)), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	for _, n := range store.batches {
+		if n > 4 {
+			t.Errorf("wrote a batch of %d values", n)
+		}
+	}
+}
+
+func TestBackpressure(t *testing.T) {
+	store := &slowStore{Store: newMemoryStore(t)}
+	hold := make(chan struct{})
+	store.set(hold, nil)
+	s := newStore(t, store, manual(2, 2))
+	defer s.Close()
+
+	// The first two keys are being flushed, the next two fill the queue.
+	set(t, s, "sen1", 1)
+	set(t, s, "sen2", 1)
+	waitFor(t, "the flush", func() bool { return store.numBatches() > 0 })
+	set(t, s, "sen3", 1)
+	set(t, s, "sen4", 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.SetContext(ctx, "sen5", 1); err != context.DeadlineExceeded {
+		t.Errorf("got %v while the queue was full, want DeadlineExceeded", err)
+	}
+	// Queued keys can still change.
+	set(t, s, "sen3", 2)
+	if got := s.Stats(); got.Waits != 1 || got.Pending != 4 {
+		t.Errorf("got %+v, want 1 wait and 4 pending changes", got)
+	}
+
+	errc := make(chan error)
+	go func() { errc <- s.Set("sen5", 1) }()
+	select {
+	case err := <-errc:
+		t.Fatalf("Set didn't wait for room: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	store.set(nil, nil)
+	close(hold)
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"sen1", "sen2", "sen3", "sen4", "sen5"} {
+		if _, found := get(t, store, k); !found {
+			t.Errorf("%s wasn't written", k)
+		}
+	}
+}
+
+func TestFlushError(t *testing.T) {
+	store := &slowStore{Store: newMemoryStore(t)}
+	errFull := errors.New("disk full")
+	store.set(nil, errFull)
+	s := newStore(t, store, manual(2, 2))
+	defer s.Close()
+
+	set(t, s, "sen1", 1)
+	if err := s.Flush(); err != errFull {
+		t.Errorf("got %v, want the error of the store", err)
+	}
+	// The change stays queued and readable.
+	if v, found := get(t, s, "sen1"); !found || v != 1 {
+		t.Errorf("got %d, %v", v, found)
+	}
+	set(t, s, "sen2", 1)
+	// A full queue flushes and returns the error instead of
+	// waiting for the next flush that fails, too.
+	flushes := s.Stats().Flushes
+	if err := s.Set("sen3", 1); err != errFull {
+		t.Errorf("got %v from a full queue, want the error of the store", err)
+	}
+	if got := s.Stats(); got.Flushes != flushes+1 {
+		t.Errorf("got %+v, want a flush of the full queue", got)
+	}
+
+	// Once the store recovers, the flush of the full queue succeeds.
+	store.set(nil, nil)
+	set(t, s, "sen3", 1)
+	for _, k := range []string{"sen1", "sen2"} {
+		if _, found := get(t, store, k); !found {
+			t.Errorf("%s wasn't written", k)
+		}
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Stats(); got.Pending != 0 {
+		t.Errorf("got %+v, want no pending changes", got)
+	}
+}
+
+func TestPendingDuringFlush(t *testing.T) {
+	store := &slowStore{Store: newMemoryStore(t)}
+	hold := make(chan struct{})
+	store.set(hold, nil)
+	s := newStore(t, store, manual(10, 10))
+	defer s.Close()
+
+	set(t, s, "sen1", 1)
+	set(t, s, "sen2", 1)
+	errc := make(chan error)
+	go func() { errc <- s.Flush() }()
+	waitFor(t, "the flush", func() bool { return store.numBatches() > 0 })
+	// A key changed during the flush is one pending change.
+	set(t, s, "sen1", 2)
+	if got := s.Stats(); got.Pending != 2 {
+		t.Errorf("got %+v, want 2 pending changes", got)
+	}
+	close(hold)
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Stats(); got.Pending != 1 {
+		t.Errorf("got %+v, want 1 pending change", got)
+	}
+}
+
+// TestClose checks that Close writes the queue to a persistent store.
+func TestClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "writebehind")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ops := pudge.DefaultOptions
+	ops.File = path.Join(dir, "db")
+	store, err := pudge.NewStore(&ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newStore(t, store, manual(10, 10))
+	set(t, s, "sen1", 1)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set("sen2", 1); err != writebehind.ErrClosed {
+		t.Errorf("got %v after Close, want ErrClosed", err)
+	}
+
+	store, err = pudge.NewStore(&ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if v, found := get(t, store, "sen1"); !found || v != 1 {
+		t.Errorf("got %d, %v after Close", v, found)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	s, err := stores.Open("writebehind", map[string]interface{}{
+		"batchsize":     10,
+		"flushinterval": "10ms",
+		"store":         map[string]interface{}{"backend": "memory"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	set(t, s, "sen1", 1)
+	store := s.(writebehind.Store).Store
+	waitFor(t, "the flush after FlushInterval", func() bool {
+		_, found := get(t, store, "sen1")
+		return found
+	})
+
+	for _, cfg := range []map[string]interface{}{
+		{"batchsize": 10},
+		{"store": map[string]interface{}{"backend": "memory"}, "batchsize": 0},
+		{"store": map[string]interface{}{"backend": "unknown"}},
+	} {
+		if _, err := stores.Open("writebehind", cfg); err == nil {
+			t.Errorf("Open with %v succeeded", cfg)
+		}
+	}
+}